@@ -0,0 +1,57 @@
+// SPDX-FileCopyrightText: 2020 SAP SE or an SAP affiliate company and Gardener contributors
+//
+// SPDX-License-Identifier: Apache-2.0
+
+package controller
+
+import (
+	"fmt"
+	"time"
+
+	"k8s.io/apimachinery/pkg/util/sets"
+)
+
+// AggregationOptions configure how the addresses of several lookups are combined
+type AggregationOptions struct {
+	// Window is the time an address is advertised after it was returned by a lookup for the last time, so that the
+	// union of the subsets returned by large load balancers is advertised. Zero advertises the last lookup only.
+	Window time.Duration
+}
+
+// Validate checks that the aggregation options are consistent
+func (o AggregationOptions) Validate() error {
+	if o.Window < 0 {
+		return fmt.Errorf("aggregation window must not be negative")
+	}
+	return nil
+}
+
+// aggregate records the addresses of a lookup and returns the union of all addresses returned within the window
+func (c *AWSLBReadvertiserController) aggregate(t *target, ips []string, now time.Time) []string {
+	if c.options.Aggregation.Window == 0 {
+		return ips
+	}
+
+	if t.lastSeen == nil {
+		t.lastSeen = map[string]time.Time{}
+	}
+	for _, ip := range ips {
+		t.lastSeen[ip] = now
+	}
+
+	union := sets.NewString()
+	for ip, seen := range t.lastSeen {
+		if now.Sub(seen) > c.options.Aggregation.Window {
+			t.log.Infof("IP %s was not returned for %s, expiring it", ip, c.options.Aggregation.Window)
+			delete(t.lastSeen, ip)
+			continue
+		}
+		union.Insert(ip)
+	}
+
+	aggregatedIPs.WithLabelValues(t.Key()).Set(float64(union.Len()))
+	if union.Len() != len(ips) {
+		t.log.Infof("Advertising %d IPs returned within the last %s, the last lookup returned %d", union.Len(), c.options.Aggregation.Window, len(ips))
+	}
+	return union.List()
+}
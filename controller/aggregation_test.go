@@ -0,0 +1,54 @@
+// SPDX-FileCopyrightText: 2020 SAP SE or an SAP affiliate company and Gardener contributors
+//
+// SPDX-License-Identifier: Apache-2.0
+
+package controller
+
+import (
+	"time"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	k8sinformers "k8s.io/client-go/informers"
+	"k8s.io/client-go/kubernetes/fake"
+	"k8s.io/client-go/tools/record"
+)
+
+var _ = Describe("#aggregate", func() {
+	var (
+		controller *AWSLBReadvertiserController
+		t          *target
+	)
+
+	newController := func(options AggregationOptions) {
+		fakeClient := fake.NewSimpleClientset()
+		sharedK8sInformerFactory := k8sinformers.NewSharedInformerFactory(fakeClient, time.Hour)
+		controller = newTestController(fakeClient, sharedK8sInformerFactory, staticResolver{}, record.NewFakeRecorder(100), nil, Options{Aggregation: options})
+		t = firstTarget(controller)
+	}
+
+	AfterEach(func() {
+		controller.queue.ShutDown()
+	})
+
+	It("should advertise the last lookup without a window", func() {
+		newController(AggregationOptions{})
+		now := time.Now()
+
+		Expect(controller.aggregate(t, []string{"1.1.1.1"}, now)).To(Equal([]string{"1.1.1.1"}))
+		Expect(controller.aggregate(t, []string{"2.2.2.2"}, now)).To(Equal([]string{"2.2.2.2"}))
+	})
+
+	It("should advertise the union of the addresses seen within the window", func() {
+		newController(AggregationOptions{Window: 5 * time.Minute})
+		now := time.Now()
+
+		Expect(controller.aggregate(t, []string{"1.1.1.1", "2.2.2.2"}, now)).To(Equal([]string{"1.1.1.1", "2.2.2.2"}))
+		Expect(controller.aggregate(t, []string{"3.3.3.3"}, now.Add(time.Minute))).To(Equal([]string{"1.1.1.1", "2.2.2.2", "3.3.3.3"}))
+		Expect(controller.aggregate(t, []string{"2.2.2.2"}, now.Add(4*time.Minute))).To(Equal([]string{"1.1.1.1", "2.2.2.2", "3.3.3.3"}))
+
+		// 1.1.1.1 was not returned for more than the window
+		Expect(controller.aggregate(t, []string{"3.3.3.3"}, now.Add(6*time.Minute))).To(Equal([]string{"2.2.2.2", "3.3.3.3"}))
+		Expect(controller.aggregate(t, []string{"3.3.3.3"}, now.Add(10*time.Minute))).To(Equal([]string{"3.3.3.3"}))
+	})
+})
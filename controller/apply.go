@@ -0,0 +1,147 @@
+// SPDX-FileCopyrightText: 2020 SAP SE or an SAP affiliate company and Gardener contributors
+//
+// SPDX-License-Identifier: Apache-2.0
+
+package controller
+
+import (
+	"context"
+	"fmt"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	corev1ac "k8s.io/client-go/applyconfigurations/core/v1"
+)
+
+const (
+	// DefaultFieldManager is the field manager of the writes of the readvertiser unless another one is configured
+	DefaultFieldManager = "aws-lb-readvertiser"
+	// maxFieldManagerLength is the longest field manager name accepted by the kube-apiserver
+	maxFieldManagerLength = 128
+)
+
+// WriteOptions configure how the managed objects are written
+type WriteOptions struct {
+	// FieldManager is the name the writes are recorded with in the managed fields, DefaultFieldManager if empty
+	FieldManager string
+	// ServerSideApply applies the managed Endpoints objects instead of patching them based on their cached copy, so
+	// that the ownership of their fields is recorded in the managed fields
+	ServerSideApply bool
+	// ForceConflicts takes over the fields owned by other field managers when applying instead of failing
+	ForceConflicts bool
+}
+
+// Validate checks that the write options are consistent
+func (o WriteOptions) Validate() error {
+	if len(o.FieldManager) > maxFieldManagerLength {
+		return fmt.Errorf("field manager must not be longer than %d characters", maxFieldManagerLength)
+	}
+	if o.ForceConflicts && !o.ServerSideApply {
+		return fmt.Errorf("forcing conflicts requires server-side apply")
+	}
+	return nil
+}
+
+// fieldManager returns the field manager of the writes of the readvertiser
+func (c *AWSLBReadvertiserController) fieldManager() string {
+	if len(c.options.Write.FieldManager) == 0 {
+		return DefaultFieldManager
+	}
+	return c.options.Write.FieldManager
+}
+
+// subsetApplyConfiguration returns the apply configuration of the given subset
+func subsetApplyConfiguration(subset corev1.EndpointSubset) *corev1ac.EndpointSubsetApplyConfiguration {
+	toAddresses := func(addresses []corev1.EndpointAddress) []*corev1ac.EndpointAddressApplyConfiguration {
+		var configurations []*corev1ac.EndpointAddressApplyConfiguration
+		for _, address := range addresses {
+			configuration := corev1ac.EndpointAddress().WithIP(address.IP)
+			if len(address.Hostname) != 0 {
+				configuration.WithHostname(address.Hostname)
+			}
+			if address.NodeName != nil {
+				configuration.WithNodeName(*address.NodeName)
+			}
+			if ref := address.TargetRef; ref != nil {
+				configuration.WithTargetRef(corev1ac.ObjectReference().
+					WithKind(ref.Kind).WithNamespace(ref.Namespace).WithName(ref.Name).WithUID(ref.UID).
+					WithAPIVersion(ref.APIVersion).WithResourceVersion(ref.ResourceVersion).WithFieldPath(ref.FieldPath))
+			}
+			configurations = append(configurations, configuration)
+		}
+		return configurations
+	}
+
+	configuration := corev1ac.EndpointSubset().
+		WithAddresses(toAddresses(subset.Addresses)...).
+		WithNotReadyAddresses(toAddresses(subset.NotReadyAddresses)...)
+	for _, port := range subset.Ports {
+		portConfiguration := corev1ac.EndpointPort().WithName(port.Name).WithPort(port.Port).WithProtocol(port.Protocol)
+		if port.AppProtocol != nil {
+			portConfiguration.WithAppProtocol(*port.AppProtocol)
+		}
+		configuration.WithPorts(portConfiguration)
+	}
+	return configuration
+}
+
+// endpointApplyConfiguration returns the fields of the endpoint of the target applied by the readvertiser: the given
+// subsets, the given labels and the annotations persisting the state of the addresses and the managed ports
+func endpointApplyConfiguration(t *target, subsets []corev1.EndpointSubset, labels map[string]string, addresses advertisedAddresses) *corev1ac.EndpointsApplyConfiguration {
+	// annotations which are not applied anymore are removed, as they are owned by the readvertiser
+	annotations := setManagedPortsAnnotation(nil, t.Ports)
+	for key, value := range addresses.desiredAnnotations() {
+		if len(value) != 0 {
+			annotations[key] = value
+		}
+	}
+
+	endpoint := corev1ac.Endpoints(t.EndpointName, t.EndpointNamespace).WithAnnotations(annotations)
+	if len(labels) != 0 {
+		endpoint.WithLabels(labels)
+	}
+	for _, subset := range subsets {
+		endpoint.WithSubsets(subsetApplyConfiguration(subset))
+	}
+	return endpoint
+}
+
+// applyEndpoint applies the endpoint of the target with the given addresses and ports, the endpoint is created if it
+// is missing. Unlike applyTwoWayEndpointMergePatch it does not diff against the cached copy of the endpoint, but its
+// unmanaged subsets are applied as they are cached since the subsets are applied as a whole. The resource version of
+// the copy is applied as precondition, so that the unmanaged subsets are not reverted to an outdated state. endpoint is
+// nil if it does not exist.
+func (c *AWSLBReadvertiserController) applyEndpoint(ctx context.Context, t *target, endpoint *corev1.Endpoints, addresses advertisedAddresses, ports []corev1.EndpointPort) (*corev1.Endpoints, *corev1.EndpointSubset, error) {
+	subset, err := createEndpointSubsetObjectFromRecords(addresses.ready, addresses.notReady, ports)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to apply endpoint: %v", err)
+	}
+
+	configuration := endpointApplyConfiguration(t, desiredSubsets(endpoint, *subset, ports, addresses.all()), c.setSkipMirrorLabel(nil), addresses)
+	if endpoint != nil {
+		configuration.WithResourceVersion(endpoint.ResourceVersion)
+	}
+	applied, err := c.client.CoreV1().Endpoints(t.EndpointNamespace).Apply(ctx, configuration, metav1.ApplyOptions{FieldManager: c.fieldManager(), Force: c.options.Write.ForceConflicts})
+	if isFieldManagerConflict(err) {
+		return nil, nil, fmt.Errorf("failed to apply endpoint, its fields are owned by other field managers: %w", err)
+	}
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to apply endpoint: %w", err)
+	}
+	return applied, subset, nil
+}
+
+// isFieldManagerConflict returns whether an apply failed because fields of the object are owned by other field
+// managers, unlike a conflict with a concurrent change of the object this is not resolved by retrying
+func isFieldManagerConflict(err error) bool {
+	return errors.IsConflict(err) && errors.HasStatusCause(err, metav1.CauseTypeFieldManagerConflict)
+}
+
+// writeFailedReason returns the reason of the event emitted when writing an endpoint failed with the given error
+func writeFailedReason(err error) string {
+	if isFieldManagerConflict(err) {
+		return EventReasonApplyConflict
+	}
+	return EventReasonPatchFailed
+}
@@ -0,0 +1,161 @@
+// SPDX-FileCopyrightText: 2020 SAP SE or an SAP affiliate company and Gardener contributors
+//
+// SPDX-License-Identifier: Apache-2.0
+
+package controller
+
+import (
+	"context"
+	"encoding/json"
+	"time"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/types"
+	k8sinformers "k8s.io/client-go/informers"
+	"k8s.io/client-go/kubernetes/fake"
+	k8stesting "k8s.io/client-go/testing"
+	"k8s.io/client-go/tools/record"
+)
+
+var _ = Describe("#applyEndpoint", func() {
+	var (
+		fakeClient               *fake.Clientset
+		sharedK8sInformerFactory k8sinformers.SharedInformerFactory
+		recorder                 *record.FakeRecorder
+		controller               *AWSLBReadvertiserController
+	)
+
+	BeforeEach(func() {
+		fakeClient = fake.NewSimpleClientset()
+		sharedK8sInformerFactory = k8sinformers.NewSharedInformerFactory(fakeClient, time.Hour)
+		recorder = record.NewFakeRecorder(10)
+		controller = newTestController(fakeClient, sharedK8sInformerFactory, staticResolver{"elb.example.com.": {"1.1.1.1"}}, recorder, nil, Options{Write: WriteOptions{FieldManager: "readvertiser", ServerSideApply: true}})
+
+		endpoint := &corev1.Endpoints{
+			ObjectMeta: metav1.ObjectMeta{Name: "kubernetes", Namespace: metav1.NamespaceDefault},
+			Subsets:    []corev1.EndpointSubset{{Addresses: []corev1.EndpointAddress{{IP: "10.0.0.1"}}, Ports: DefaultEndpointPorts()}},
+		}
+		_, err := fakeClient.CoreV1().Endpoints(metav1.NamespaceDefault).Create(context.TODO(), endpoint, metav1.CreateOptions{})
+		Expect(err).To(BeNil())
+		Expect(sharedK8sInformerFactory.Core().V1().Endpoints().Informer().GetStore().Add(endpoint)).To(Succeed())
+		fakeClient.ClearActions()
+	})
+
+	AfterEach(func() {
+		controller.queue.ShutDown()
+	})
+
+	It("should apply the subset and annotations owned by the readvertiser", func() {
+		Expect(controller.reconcileTarget(context.TODO(), firstTarget(controller))).To(Succeed())
+
+		Expect(fakeClient.Actions()).To(HaveLen(1))
+		patch, ok := fakeClient.Actions()[0].(k8stesting.PatchAction)
+		Expect(ok).To(BeTrue())
+		Expect(patch.GetPatchType()).To(Equal(types.ApplyPatchType))
+
+		applied := &corev1.Endpoints{}
+		Expect(json.Unmarshal(patch.GetPatch(), applied)).To(Succeed())
+		Expect(applied.Name).To(Equal("kubernetes"))
+		Expect(applied.Kind).To(Equal("Endpoints"))
+		Expect(applied.Annotations).To(Equal(map[string]string{managedPortsAnnotation: "https:443/TCP"}))
+		Expect(applied.Subsets).To(Equal([]corev1.EndpointSubset{{Addresses: []corev1.EndpointAddress{{IP: "1.1.1.1"}}, Ports: DefaultEndpointPorts()}}))
+
+		endpoint, err := fakeClient.CoreV1().Endpoints(metav1.NamespaceDefault).Get(context.TODO(), "kubernetes", metav1.GetOptions{})
+		Expect(err).To(BeNil())
+		Expect(endpoint.Subsets).To(Equal(applied.Subsets))
+	})
+
+	It("should surface conflicts with other field managers without retrying", func() {
+		fakeClient.PrependReactor("patch", "endpoints", func(action k8stesting.Action) (bool, runtime.Object, error) {
+			return true, nil, errors.NewApplyConflict([]metav1.StatusCause{{Type: metav1.CauseTypeFieldManagerConflict, Message: `conflict with "kube-apiserver"`, Field: ".subsets"}}, `Apply failed with 1 conflict: conflict with "kube-apiserver": .subsets`)
+		})
+
+		Expect(controller.reconcileTarget(context.TODO(), firstTarget(controller))).To(MatchError(ContainSubstring("owned by other field managers")))
+		Expect(fakeClient.Actions()).To(HaveLen(1))
+		Expect(recorder.Events).To(Receive(HavePrefix("Warning ApplyConflict")))
+	})
+
+	It("should retry applies conflicting with a concurrent change", func() {
+		conflicts := 1
+		fakeClient.PrependReactor("patch", "endpoints", func(action k8stesting.Action) (bool, runtime.Object, error) {
+			if conflicts == 0 {
+				return false, nil, nil
+			}
+			conflicts--
+			return true, nil, errors.NewConflict(schema.GroupResource{Resource: "endpoints"}, "kubernetes", errors.NewBadRequest("the object has been modified"))
+		})
+
+		Expect(controller.reconcileTarget(context.TODO(), firstTarget(controller))).To(Succeed())
+		var verbs []string
+		for _, action := range fakeClient.Actions() {
+			verbs = append(verbs, action.GetVerb())
+		}
+		Expect(verbs).To(Equal([]string{"patch", "get", "patch"}))
+		Expect(recorder.Events).NotTo(Receive(ContainSubstring("ApplyConflict")))
+	})
+
+	It("should not revert unmanaged subsets changed since the endpoint was cached", func() {
+		// the cached endpoint misses an unmanaged subset added concurrently
+		unmanaged := corev1.EndpointSubset{Addresses: []corev1.EndpointAddress{{IP: "10.0.0.2"}}, Ports: []corev1.EndpointPort{{Name: "metrics", Port: 9090, Protocol: corev1.ProtocolTCP}}}
+		cached := &corev1.Endpoints{
+			ObjectMeta: metav1.ObjectMeta{Name: "kubernetes", Namespace: metav1.NamespaceDefault, ResourceVersion: "5"},
+			Subsets:    []corev1.EndpointSubset{{Addresses: []corev1.EndpointAddress{{IP: "10.0.0.1"}}, Ports: DefaultEndpointPorts()}},
+		}
+		Expect(sharedK8sInformerFactory.Core().V1().Endpoints().Informer().GetStore().Update(cached)).To(Succeed())
+		current := cached.DeepCopy()
+		current.ResourceVersion = "6"
+		current.Subsets = append(current.Subsets, unmanaged)
+		_, err := fakeClient.CoreV1().Endpoints(metav1.NamespaceDefault).Update(context.TODO(), current, metav1.UpdateOptions{})
+		Expect(err).To(BeNil())
+		fakeClient.ClearActions()
+
+		fakeClient.PrependReactor("patch", "endpoints", func(action k8stesting.Action) (bool, runtime.Object, error) {
+			applied := &corev1.Endpoints{}
+			Expect(json.Unmarshal(action.(k8stesting.PatchAction).GetPatch(), applied)).To(Succeed())
+			if applied.ResourceVersion != "6" {
+				return true, nil, errors.NewConflict(schema.GroupResource{Resource: "endpoints"}, "kubernetes", errors.NewBadRequest("the object has been modified"))
+			}
+			return false, nil, nil
+		})
+
+		Expect(controller.reconcileTarget(context.TODO(), firstTarget(controller))).To(Succeed())
+		var verbs []string
+		for _, action := range fakeClient.Actions() {
+			verbs = append(verbs, action.GetVerb())
+		}
+		Expect(verbs).To(Equal([]string{"patch", "get", "patch"}))
+		Expect(string(fakeClient.Actions()[0].(k8stesting.PatchAction).GetPatch())).To(ContainSubstring(`"resourceVersion":"5"`))
+
+		endpoint, err := fakeClient.CoreV1().Endpoints(metav1.NamespaceDefault).Get(context.TODO(), "kubernetes", metav1.GetOptions{})
+		Expect(err).To(BeNil())
+		Expect(endpoint.Subsets).To(Equal([]corev1.EndpointSubset{{Addresses: []corev1.EndpointAddress{{IP: "1.1.1.1"}}, Ports: DefaultEndpointPorts()}, unmanaged}))
+		Expect(recorder.Events).NotTo(Receive(ContainSubstring("ApplyConflict")))
+	})
+
+	It("should not replace the unmanaged subsets of an endpoint missing in the cache", func() {
+		Expect(sharedK8sInformerFactory.Core().V1().Endpoints().Informer().GetStore().Replace(nil, "")).To(Succeed())
+		unmanaged := corev1.EndpointSubset{Addresses: []corev1.EndpointAddress{{IP: "10.0.0.2"}}, Ports: []corev1.EndpointPort{{Name: "metrics", Port: 9090, Protocol: corev1.ProtocolTCP}}}
+		endpoint, err := fakeClient.CoreV1().Endpoints(metav1.NamespaceDefault).Get(context.TODO(), "kubernetes", metav1.GetOptions{})
+		Expect(err).To(BeNil())
+		endpoint.Subsets = append(endpoint.Subsets, unmanaged)
+		_, err = fakeClient.CoreV1().Endpoints(metav1.NamespaceDefault).Update(context.TODO(), endpoint, metav1.UpdateOptions{})
+		Expect(err).To(BeNil())
+
+		Expect(controller.reconcileTarget(context.TODO(), firstTarget(controller))).To(Succeed())
+		endpoint, err = fakeClient.CoreV1().Endpoints(metav1.NamespaceDefault).Get(context.TODO(), "kubernetes", metav1.GetOptions{})
+		Expect(err).To(BeNil())
+		Expect(endpoint.Subsets).To(Equal([]corev1.EndpointSubset{{Addresses: []corev1.EndpointAddress{{IP: "1.1.1.1"}}, Ports: DefaultEndpointPorts()}, unmanaged}))
+	})
+
+	It("should validate the options", func() {
+		Expect(WriteOptions{}.Validate()).To(Succeed())
+		Expect(WriteOptions{ServerSideApply: true, ForceConflicts: true}.Validate()).To(Succeed())
+		Expect(WriteOptions{ForceConflicts: true}.Validate()).NotTo(Succeed())
+	})
+})
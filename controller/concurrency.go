@@ -0,0 +1,90 @@
+// SPDX-FileCopyrightText: 2020 SAP SE or an SAP affiliate company and Gardener contributors
+//
+// SPDX-License-Identifier: Apache-2.0
+
+package controller
+
+import (
+	"fmt"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+)
+
+const (
+	// maxWriteAttempts bounds the writes of a managed object which fail with a conflict within one reconcile
+	maxWriteAttempts = 3
+	// maxCacheLag is the time the informer cache is awaited to catch up with a write before it is trusted again
+	maxCacheLag = 30 * time.Second
+)
+
+// pendingWrite is a write of an existing managed object the informer cache did not catch up with yet. Creates are not
+// tracked: while the cache still misses a created object, creating it again fails with a conflict and is retried based
+// on the current object.
+type pendingWrite struct {
+	// base is the resource version of the object the write was based on
+	base string
+	// written is the resource version of the object returned by the write
+	written string
+	// at is the time of the write
+	at time.Time
+}
+
+// observeWrite records a successful write of the existing managed object identified by key, so that it is not written
+// again based on a cached copy which predates the write
+func (c *AWSLBReadvertiserController) observeWrite(t *target, key, base, written string) {
+	if t.writes == nil {
+		t.writes = map[string]pendingWrite{}
+	}
+	t.writes[key] = pendingWrite{base: base, written: written, at: time.Now()}
+}
+
+// awaitingWrite returns whether the cached managed object identified by key predates its last write, in which case
+// it must not be written again. found and resourceVersion describe the cached object. The informer enqueues the target
+// again once the write arrives in the cache.
+func (c *AWSLBReadvertiserController) awaitingWrite(t *target, key string, found bool, resourceVersion string) bool {
+	write, ok := t.writes[key]
+	if !ok {
+		return false
+	}
+
+	switch {
+	case found && resourceVersion == write.written:
+		// the cache caught up with the write
+	case time.Since(write.at) > maxCacheLag:
+		t.log.Warnf("The cached %s did not catch up with its write at %s, trusting it again", key, write.at.Format(time.RFC3339))
+	case found && resourceVersion == write.base:
+		t.log.Infof("The cached %s predates its write at %s, waiting for the write to arrive in the cache", key, write.at.Format(time.RFC3339))
+		t.compared = false
+		return true
+	default:
+		// the object was changed or deleted by another writer since, hence it is reconciled based on the cache again
+	}
+	delete(t.writes, key)
+	return false
+}
+
+// isWriteConflict returns whether a write of a managed object failed because it was based on an outdated copy of the
+// object. Server-side applies also conflict on fields owned by other field managers, these conflicts are not retried.
+func isWriteConflict(err error) bool {
+	return errors.IsAlreadyExists(err) || (errors.IsConflict(err) && !isFieldManagerConflict(err))
+}
+
+// retryOnConflict writes a managed object of the target until the write does not conflict with a concurrent change
+// anymore, at most maxWriteAttempts times. The first attempt is based on the cached object, the retries on the
+// current object read from the API server.
+func (c *AWSLBReadvertiserController) retryOnConflict(t *target, resource string, write func(fromCache bool) error) error {
+	for attempt := 1; ; attempt++ {
+		err := write(attempt == 1)
+		if !isWriteConflict(err) {
+			return err
+		}
+		endpointWriteConflicts.WithLabelValues(t.Key(), resource).Inc()
+		if attempt == maxWriteAttempts {
+			c.recordEvent(t, corev1.EventTypeWarning, EventReasonPatchFailed, "Giving up writing the %s after %d conflicting writes: %v", resource, attempt, err)
+			return fmt.Errorf("could not write the %s of %s after %d conflicting writes: %w", resource, t.Key(), attempt, err)
+		}
+		t.log.Infof("Writing the %s conflicted with a concurrent change, retrying with the current object: %v", resource, err)
+	}
+}
@@ -0,0 +1,153 @@
+// SPDX-FileCopyrightText: 2020 SAP SE or an SAP affiliate company and Gardener contributors
+//
+// SPDX-License-Identifier: Apache-2.0
+
+package controller
+
+import (
+	"context"
+	"time"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	k8sinformers "k8s.io/client-go/informers"
+	"k8s.io/client-go/kubernetes/fake"
+	k8stesting "k8s.io/client-go/testing"
+	"k8s.io/client-go/tools/record"
+)
+
+var _ = Describe("#retryOnConflict", func() {
+	var (
+		fakeClient *fake.Clientset
+		recorder   *record.FakeRecorder
+		controller *AWSLBReadvertiserController
+		conflicts  int
+	)
+
+	BeforeEach(func() {
+		fakeClient = fake.NewSimpleClientset()
+		sharedK8sInformerFactory := k8sinformers.NewSharedInformerFactory(fakeClient, time.Hour)
+		recorder = record.NewFakeRecorder(10)
+		controller = newTestController(fakeClient, sharedK8sInformerFactory, staticResolver{"elb.example.com.": {"1.1.1.1"}}, recorder, []Target{
+			{Hostnames: []string{"elb.example.com."}, EndpointNamespace: metav1.NamespaceDefault, EndpointName: "conflicts", Ports: DefaultEndpointPorts(), RefreshPeriod: time.Second},
+		}, Options{})
+
+		// the cached endpoint is outdated, the current one was changed concurrently
+		cached := &corev1.Endpoints{
+			ObjectMeta: metav1.ObjectMeta{Name: "conflicts", Namespace: metav1.NamespaceDefault, ResourceVersion: "5"},
+			Subsets:    []corev1.EndpointSubset{{Addresses: []corev1.EndpointAddress{{IP: "10.0.0.1"}}, Ports: DefaultEndpointPorts()}},
+		}
+		Expect(sharedK8sInformerFactory.Core().V1().Endpoints().Informer().GetStore().Add(cached)).To(Succeed())
+		current := cached.DeepCopy()
+		current.Subsets[0].Addresses[0].IP = "10.0.0.2"
+		_, err := fakeClient.CoreV1().Endpoints(metav1.NamespaceDefault).Create(context.TODO(), current, metav1.CreateOptions{})
+		Expect(err).To(BeNil())
+
+		conflicts = 0
+		fakeClient.PrependReactor("patch", "endpoints", func(action k8stesting.Action) (bool, runtime.Object, error) {
+			if conflicts == 0 {
+				return false, nil, nil
+			}
+			conflicts--
+			return true, nil, errors.NewConflict(schema.GroupResource{Resource: "endpoints"}, "conflicts", errors.NewBadRequest("the object has been modified"))
+		})
+		fakeClient.ClearActions()
+	})
+
+	AfterEach(func() {
+		controller.queue.ShutDown()
+	})
+
+	It("should send the resource version of the cached endpoint as precondition", func() {
+		Expect(controller.reconcileTarget(context.TODO(), firstTarget(controller))).To(Succeed())
+		Expect(fakeClient.Actions()).NotTo(BeEmpty())
+		Expect(string(fakeClient.Actions()[0].(k8stesting.PatchAction).GetPatch())).To(ContainSubstring(`"resourceVersion":"5"`))
+	})
+
+	It("should retry a conflicting write with the current endpoint", func() {
+		conflicts = 1
+		before := testutil.ToFloat64(endpointWriteConflicts.WithLabelValues("default/conflicts", resourceEndpoints))
+
+		Expect(controller.reconcileTarget(context.TODO(), firstTarget(controller))).To(Succeed())
+		var verbs []string
+		for _, action := range fakeClient.Actions() {
+			verbs = append(verbs, action.GetVerb())
+		}
+		Expect(verbs).To(Equal([]string{"patch", "get", "patch"}))
+		Expect(testutil.ToFloat64(endpointWriteConflicts.WithLabelValues("default/conflicts", resourceEndpoints)) - before).To(Equal(1.0))
+
+		endpoint, err := fakeClient.CoreV1().Endpoints(metav1.NamespaceDefault).Get(context.TODO(), "conflicts", metav1.GetOptions{})
+		Expect(err).To(BeNil())
+		Expect(endpoint.Subsets[0].Addresses).To(Equal([]corev1.EndpointAddress{{IP: "1.1.1.1"}}))
+		Expect(recorder.Events).NotTo(Receive(ContainSubstring("PatchFailed")))
+	})
+
+	It("should give up after the maximum number of conflicting writes", func() {
+		conflicts = maxWriteAttempts
+
+		Expect(controller.reconcileTarget(context.TODO(), firstTarget(controller))).To(MatchError(ContainSubstring("after 3 conflicting writes")))
+		Expect(recorder.Events).To(Receive(HavePrefix("Warning PatchFailed Giving up writing the endpoints after 3 conflicting writes")))
+	})
+})
+
+var _ = Describe("#awaitingWrite", func() {
+	var (
+		fakeClient               *fake.Clientset
+		sharedK8sInformerFactory k8sinformers.SharedInformerFactory
+		controller               *AWSLBReadvertiserController
+		t                        *target
+	)
+
+	BeforeEach(func() {
+		fakeClient = fake.NewSimpleClientset()
+		sharedK8sInformerFactory = k8sinformers.NewSharedInformerFactory(fakeClient, time.Hour)
+		controller = newTestController(fakeClient, sharedK8sInformerFactory, staticResolver{}, record.NewFakeRecorder(10), nil, Options{})
+		t = firstTarget(controller)
+	})
+
+	AfterEach(func() {
+		controller.queue.ShutDown()
+	})
+
+	It("should wait until the cache caught up with the write", func() {
+		Expect(controller.awaitingWrite(t, resourceEndpoints, true, "5")).To(BeFalse())
+
+		controller.observeWrite(t, resourceEndpoints, "5", "6")
+		Expect(controller.awaitingWrite(t, resourceEndpoints, true, "5")).To(BeTrue())
+		Expect(controller.awaitingWrite(t, resourceEndpoints, true, "6")).To(BeFalse())
+		Expect(controller.awaitingWrite(t, resourceEndpoints, true, "5")).To(BeFalse())
+	})
+
+	It("should not wait if the endpoint was changed or deleted by another writer", func() {
+		controller.observeWrite(t, resourceEndpoints, "5", "6")
+		Expect(controller.awaitingWrite(t, resourceEndpoints, true, "7")).To(BeFalse())
+
+		controller.observeWrite(t, resourceEndpoints, "5", "6")
+		Expect(controller.awaitingWrite(t, resourceEndpoints, false, "")).To(BeFalse())
+	})
+
+	It("should trust the cache again if it did not catch up in time", func() {
+		controller.observeWrite(t, resourceEndpoints, "5", "6")
+		write := t.writes[resourceEndpoints]
+		write.at = time.Now().Add(-maxCacheLag - time.Second)
+		t.writes[resourceEndpoints] = write
+		Expect(controller.awaitingWrite(t, resourceEndpoints, true, "5")).To(BeFalse())
+	})
+
+	It("should not write the endpoint while the cache predates the last write", func() {
+		Expect(sharedK8sInformerFactory.Core().V1().Endpoints().Informer().GetStore().Add(&corev1.Endpoints{
+			ObjectMeta: metav1.ObjectMeta{Name: "kubernetes", Namespace: metav1.NamespaceDefault, ResourceVersion: "5"},
+			Subsets:    []corev1.EndpointSubset{{Addresses: []corev1.EndpointAddress{{IP: "10.0.0.1"}}, Ports: DefaultEndpointPorts()}},
+		})).To(Succeed())
+		controller.observeWrite(t, resourceEndpoints, "5", "6")
+
+		Expect(controller.reconcileEndpoints(context.TODO(), t, readyAddresses([]string{"1.1.1.1"}))).To(Succeed())
+		Expect(fakeClient.Actions()).To(BeEmpty())
+	})
+})
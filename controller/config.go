@@ -0,0 +1,91 @@
+// SPDX-FileCopyrightText: 2020 SAP SE or an SAP affiliate company and Gardener contributors
+//
+// SPDX-License-Identifier: Apache-2.0
+
+package controller
+
+import (
+	"fmt"
+	"os"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"sigs.k8s.io/yaml"
+)
+
+// Config is the content of the YAML or JSON file given with --config
+type Config struct {
+	Targets []TargetConfig `json:"targets"`
+}
+
+// TargetConfig describes a Target in the config file
+type TargetConfig struct {
+	// Hostnames are the DNS names of the load balancers
+	Hostnames []string `json:"hostnames"`
+	// EndpointNamespace defaults to "default"
+	EndpointNamespace string `json:"endpointNamespace,omitempty"`
+	// EndpointName defaults to "kubernetes"
+	EndpointName string `json:"endpointName,omitempty"`
+	// Ports default to https:443/TCP
+	Ports []corev1.EndpointPort `json:"ports,omitempty"`
+	// RefreshInterval defaults to --refresh-period
+	RefreshInterval *metav1.Duration `json:"refreshInterval,omitempty"`
+	// StaticAddresses are always advertised in addition to the resolved addresses
+	StaticAddresses []string `json:"staticAddresses,omitempty"`
+	// ExcludeAddresses are never advertised, even if a hostname resolves to them
+	ExcludeAddresses []string `json:"excludeAddresses,omitempty"`
+}
+
+// LoadConfig reads the config file at the given path, unknown fields are rejected
+func LoadConfig(path string) (*Config, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read config file: %v", err)
+	}
+
+	config := &Config{}
+	if err := yaml.UnmarshalStrict(data, config); err != nil {
+		return nil, fmt.Errorf("failed to parse config file %q: %v", path, err)
+	}
+	return config, nil
+}
+
+// ToTargets defaults the configured targets and validates them
+func (c *Config) ToTargets(defaultRefreshPeriod time.Duration) ([]Target, error) {
+	var targets []Target
+	for _, tc := range c.Targets {
+		t := Target{
+			Hostnames:         append([]string(nil), tc.Hostnames...),
+			EndpointNamespace: tc.EndpointNamespace,
+			EndpointName:      tc.EndpointName,
+			Ports:             tc.Ports,
+			RefreshPeriod:     defaultRefreshPeriod,
+			StaticAddresses:   append([]string(nil), tc.StaticAddresses...),
+			ExcludeAddresses:  append([]string(nil), tc.ExcludeAddresses...),
+		}
+		if len(t.EndpointNamespace) == 0 {
+			t.EndpointNamespace = metav1.NamespaceDefault
+		}
+		if len(t.EndpointName) == 0 {
+			t.EndpointName = "kubernetes"
+		}
+		if len(t.Ports) == 0 {
+			t.Ports = DefaultEndpointPorts()
+		}
+		for i := range t.Ports {
+			if len(t.Ports[i].Protocol) == 0 {
+				t.Ports[i].Protocol = corev1.ProtocolTCP
+			}
+		}
+		if tc.RefreshInterval != nil {
+			t.RefreshPeriod = tc.RefreshInterval.Duration
+		}
+		targets = append(targets, t)
+	}
+
+	if err := ValidateTargets(targets); err != nil {
+		return nil, err
+	}
+	return targets, nil
+}
@@ -0,0 +1,127 @@
+// SPDX-FileCopyrightText: 2020 SAP SE or an SAP affiliate company and Gardener contributors
+//
+// SPDX-License-Identifier: Apache-2.0
+
+package controller
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	k8sinformers "k8s.io/client-go/informers"
+	"k8s.io/client-go/kubernetes/fake"
+	"k8s.io/client-go/tools/record"
+)
+
+// staticResolver answers lookups from a fixed map of hostnames without TTLs
+type staticResolver map[string][]string
+
+func (r staticResolver) LookupHost(_ context.Context, host string, _ IPFamily) ([]string, time.Duration, error) {
+	ips, ok := r[host]
+	if !ok {
+		return nil, 0, fmt.Errorf("no such host %q", host)
+	}
+	return ips, 0, nil
+}
+
+// firstTarget returns the target of a controller managing a single target
+func firstTarget(c *AWSLBReadvertiserController) *target {
+	for _, t := range c.targets {
+		return t
+	}
+	return nil
+}
+
+var _ = Describe("#LoadConfig", func() {
+	writeConfig := func(content string) string {
+		path := filepath.Join(GinkgoT().TempDir(), "config.yaml")
+		Expect(os.WriteFile(path, []byte(content), 0600)).To(Succeed())
+		return path
+	}
+
+	It("should load and default the targets", func() {
+		config, err := LoadConfig(writeConfig(`
+targets:
+- hostnames: [internal.elb.example.com]
+- hostnames: [external.elb.example.com, external2.elb.example.com.]
+  endpointNamespace: kube-system
+  endpointName: kube-apiserver-external
+  ports:
+  - name: https
+    port: 8443
+  - name: konnectivity
+    port: 8132
+    appProtocol: kubernetes.io/h2c
+  refreshInterval: 30s
+  staticAddresses: [10.0.0.1]
+  excludeAddresses: [3.3.3.3]
+`))
+		Expect(err).To(BeNil())
+
+		targets, err := config.ToTargets(5 * time.Second)
+		Expect(err).To(BeNil())
+		Expect(targets).To(HaveLen(2))
+
+		Expect(targets[0]).To(Equal(Target{
+			Hostnames:         []string{"internal.elb.example.com."},
+			EndpointNamespace: metav1.NamespaceDefault,
+			EndpointName:      "kubernetes",
+			Ports:             DefaultEndpointPorts(),
+			RefreshPeriod:     5 * time.Second,
+		}))
+		Expect(targets[1].Key()).To(Equal("kube-system/kube-apiserver-external"))
+		Expect(targets[1].Hostnames).To(Equal([]string{"external.elb.example.com.", "external2.elb.example.com."}))
+		Expect(targets[1].Ports[0]).To(Equal(corev1.EndpointPort{Name: "https", Port: 8443, Protocol: corev1.ProtocolTCP}))
+		Expect(*targets[1].Ports[1].AppProtocol).To(Equal("kubernetes.io/h2c"))
+		Expect(targets[1].RefreshPeriod).To(Equal(30 * time.Second))
+		Expect(targets[1].StaticAddresses).To(Equal([]string{"10.0.0.1"}))
+		Expect(targets[1].ExcludeAddresses).To(Equal([]string{"3.3.3.3"}))
+	})
+
+	It("should reject unknown fields", func() {
+		_, err := LoadConfig(writeConfig(`
+targets:
+- hostname: elb.example.com
+`))
+		Expect(err).NotTo(BeNil())
+	})
+
+	It("should reject targets managing the same endpoint", func() {
+		config, err := LoadConfig(writeConfig(`
+targets:
+- hostnames: [a.example.com]
+- hostnames: [b.example.com]
+`))
+		Expect(err).To(BeNil())
+		_, err = config.ToTargets(5 * time.Second)
+		Expect(err).NotTo(BeNil())
+	})
+})
+
+var _ = Describe("#reconcileTarget", func() {
+	It("should reconcile targets independently of each other", func() {
+		var (
+			fakeClient               = fake.NewSimpleClientset()
+			sharedK8sInformerFactory = k8sinformers.NewSharedInformerFactory(fakeClient, time.Hour)
+			resolver                 = staticResolver{"good.example.com.": {"1.2.3.4"}}
+		)
+		controller := newTestController(fakeClient, sharedK8sInformerFactory, resolver, record.NewFakeRecorder(100), []Target{
+			{Hostnames: []string{"bad.example.com."}, EndpointNamespace: metav1.NamespaceDefault, EndpointName: "bad", Ports: DefaultEndpointPorts(), RefreshPeriod: time.Second},
+			{Hostnames: []string{"good.example.com."}, EndpointNamespace: metav1.NamespaceDefault, EndpointName: "good", Ports: DefaultEndpointPorts(), RefreshPeriod: time.Second},
+		}, Options{})
+
+		Expect(controller.reconcileTarget(context.TODO(), controller.targets["default/bad"])).NotTo(Succeed())
+		Expect(controller.reconcileTarget(context.TODO(), controller.targets["default/good"])).To(Succeed())
+
+		endpoint, err := fakeClient.CoreV1().Endpoints(metav1.NamespaceDefault).Get(context.TODO(), "good", metav1.GetOptions{})
+		Expect(err).To(BeNil())
+		Expect(endpoint.Subsets[0].Addresses).To(Equal([]corev1.EndpointAddress{{IP: "1.2.3.4"}}))
+	})
+})
@@ -0,0 +1,158 @@
+// SPDX-FileCopyrightText: 2020 SAP SE or an SAP affiliate company and Gardener contributors
+//
+// SPDX-License-Identifier: Apache-2.0
+
+package controller
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"k8s.io/apimachinery/pkg/util/sets"
+)
+
+const (
+	// DampingModeNone advertises the addresses of every lookup right away
+	DampingModeNone = "none"
+	// DampingModeConsensus advertises a new set of addresses once it was returned by N of the last M lookups
+	DampingModeConsensus = "consensus"
+	// DampingModeStable advertises a new set of addresses once every lookup returned it for a given duration
+	DampingModeStable = "stable"
+)
+
+// DampingOptions configure how many lookups have to agree on a new set of addresses before it is advertised, so
+// that rotating or partial DNS answers do not cause endpoint churn
+type DampingOptions struct {
+	// Mode is one of DampingModeNone, DampingModeConsensus or DampingModeStable, empty means DampingModeNone
+	Mode string
+	// Observations is the number of lookups (N) out of the last Lookups (M) which must return a set in
+	// DampingModeConsensus
+	Observations int
+	// Lookups is the number of recent lookups (M) considered in DampingModeConsensus
+	Lookups int
+	// StableDuration is the time every lookup must return a set in DampingModeStable
+	StableDuration time.Duration
+}
+
+// Validate checks that the damping options are consistent
+func (o DampingOptions) Validate() error {
+	switch o.Mode {
+	case "", DampingModeNone:
+	case DampingModeConsensus:
+		if o.Lookups < 1 {
+			return fmt.Errorf("number of considered lookups must be positive")
+		}
+		if o.Observations < 1 || o.Observations > o.Lookups {
+			return fmt.Errorf("number of observations must be between 1 and the number of considered lookups (%d)", o.Lookups)
+		}
+	case DampingModeStable:
+		if o.StableDuration <= 0 {
+			return fmt.Errorf("stable duration must be positive")
+		}
+	default:
+		return fmt.Errorf("damping mode must be one of %q, %q or %q", DampingModeNone, DampingModeConsensus, DampingModeStable)
+	}
+	return nil
+}
+
+// dampingState is the damping state of a target. It is only accessed by the worker reconciling the target.
+type dampingState struct {
+	// accepted is the set of addresses which was agreed on last, nil until the first agreement
+	accepted []string
+	// history holds the keys of the address sets of the last lookups, the latest last (DampingModeConsensus)
+	history []string
+	// observedAt is the time of the last lookup recorded in the history (DampingModeConsensus)
+	observedAt time.Time
+	// candidate is the set of addresses which differs from the accepted one and was first seen at candidateSince
+	// (DampingModeStable)
+	candidate      string
+	candidateSince time.Time
+	// candidateLookups is the number of consecutive lookups which returned the candidate (DampingModeStable)
+	candidateLookups int
+}
+
+// addressSetKey returns a key which is equal for equal sets of addresses
+func addressSetKey(ips []string) string {
+	return strings.Join(sets.NewString(ips...).List(), ",")
+}
+
+// dampen records the addresses of a lookup and returns the addresses to advertise. It returns false if no set of
+// addresses was agreed on yet, in which case nothing is written. scheduled is set if the lookup was made by a scheduled
+// refresh of the target.
+func (c *AWSLBReadvertiserController) dampen(t *target, ips []string, scheduled bool) ([]string, bool) {
+	switch c.options.Damping.Mode {
+	case DampingModeConsensus:
+		now := time.Now()
+		if !scheduled && now.Sub(t.damping.observedAt) < c.options.Refresh.MinInterval {
+			// retries and changes of the managed objects must not outvote the refresh schedule
+			t.log.Debugf("Lookup was made within the minimum refresh interval after the last observation, not counting it")
+			return t.damping.accepted, t.damping.accepted != nil
+		}
+		t.damping.observedAt = now
+		return c.dampenByConsensus(t, ips)
+	case DampingModeStable:
+		return c.dampenByStability(t, ips, time.Now())
+	default:
+		return ips, true
+	}
+}
+
+func (c *AWSLBReadvertiserController) dampenByConsensus(t *target, ips []string) ([]string, bool) {
+	d := &t.damping
+	key := addressSetKey(ips)
+
+	d.history = append(d.history, key)
+	if len(d.history) > c.options.Damping.Lookups {
+		d.history = d.history[len(d.history)-c.options.Damping.Lookups:]
+	}
+
+	observations := 0
+	for _, k := range d.history {
+		if k == key {
+			observations++
+		}
+	}
+
+	if d.accepted != nil && addressSetKey(d.accepted) == key {
+		recordDampingCandidate(t, false, observations)
+		return d.accepted, true
+	}
+	if observations >= c.options.Damping.Observations {
+		t.log.Infof("Candidate IPs %q were returned by %d of the last %d lookups, advertising them", ips, observations, len(d.history))
+		d.accepted = ips
+		recordDampingCandidate(t, false, observations)
+		return d.accepted, true
+	}
+
+	t.log.Infof("Candidate IPs %q were returned by %d of the last %d lookups, %d are required, keeping IPs %q", ips, observations, len(d.history), c.options.Damping.Observations, d.accepted)
+	recordDampingCandidate(t, true, observations)
+	return d.accepted, d.accepted != nil
+}
+
+func (c *AWSLBReadvertiserController) dampenByStability(t *target, ips []string, now time.Time) ([]string, bool) {
+	d := &t.damping
+	key := addressSetKey(ips)
+
+	if d.accepted != nil && addressSetKey(d.accepted) == key {
+		d.candidate, d.candidateSince, d.candidateLookups = "", time.Time{}, 0
+		recordDampingCandidate(t, false, 0)
+		return d.accepted, true
+	}
+	if d.candidate != key {
+		d.candidate, d.candidateSince, d.candidateLookups = key, now, 0
+	}
+	d.candidateLookups++
+
+	if stable := now.Sub(d.candidateSince); stable >= c.options.Damping.StableDuration {
+		t.log.Infof("Candidate IPs %q were returned by every lookup for %s, advertising them", ips, stable.Round(time.Second))
+		d.accepted = ips
+		d.candidate, d.candidateSince, d.candidateLookups = "", time.Time{}, 0
+		recordDampingCandidate(t, false, 0)
+		return d.accepted, true
+	}
+
+	t.log.Infof("Candidate IPs %q were returned by every lookup for %s, %s are required, keeping IPs %q", ips, now.Sub(d.candidateSince).Round(time.Second), c.options.Damping.StableDuration, d.accepted)
+	recordDampingCandidate(t, true, d.candidateLookups)
+	return d.accepted, d.accepted != nil
+}
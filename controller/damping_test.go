@@ -0,0 +1,127 @@
+// SPDX-FileCopyrightText: 2020 SAP SE or an SAP affiliate company and Gardener contributors
+//
+// SPDX-License-Identifier: Apache-2.0
+
+package controller
+
+import (
+	"context"
+	"time"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	k8sinformers "k8s.io/client-go/informers"
+	"k8s.io/client-go/kubernetes/fake"
+	"k8s.io/client-go/tools/record"
+)
+
+var _ = Describe("#DampingOptions", func() {
+	It("should validate the options", func() {
+		Expect(DampingOptions{}.Validate()).To(Succeed())
+		Expect(DampingOptions{Mode: DampingModeConsensus, Observations: 3, Lookups: 5}.Validate()).To(Succeed())
+		Expect(DampingOptions{Mode: DampingModeStable, StableDuration: time.Minute}.Validate()).To(Succeed())
+
+		Expect(DampingOptions{Mode: "majority"}.Validate()).NotTo(Succeed())
+		Expect(DampingOptions{Mode: DampingModeConsensus, Observations: 6, Lookups: 5}.Validate()).NotTo(Succeed())
+		Expect(DampingOptions{Mode: DampingModeStable}.Validate()).NotTo(Succeed())
+	})
+})
+
+var _ = Describe("#dampen", func() {
+	var (
+		fakeClient *fake.Clientset
+		resolver   staticResolver
+		controller *AWSLBReadvertiserController
+		t          *target
+	)
+
+	newController := func(options DampingOptions) {
+		fakeClient = fake.NewSimpleClientset()
+		sharedK8sInformerFactory := k8sinformers.NewSharedInformerFactory(fakeClient, time.Hour)
+		resolver = staticResolver{}
+		controller = newTestController(fakeClient, sharedK8sInformerFactory, resolver, record.NewFakeRecorder(100), nil, Options{Damping: options})
+		t = firstTarget(controller)
+	}
+
+	AfterEach(func() {
+		controller.queue.ShutDown()
+	})
+
+	It("should advertise a set of addresses once N of the last M lookups returned it", func() {
+		newController(DampingOptions{Mode: DampingModeConsensus, Observations: 2, Lookups: 3})
+
+		_, ok := controller.dampen(t, []string{"1.1.1.1", "2.2.2.2"}, true)
+		Expect(ok).To(BeFalse())
+		ips, ok := controller.dampen(t, []string{"2.2.2.2", "1.1.1.1"}, true)
+		Expect(ok).To(BeTrue())
+		Expect(ips).To(Equal([]string{"2.2.2.2", "1.1.1.1"}))
+
+		// a partial answer is not advertised
+		ips, ok = controller.dampen(t, []string{"1.1.1.1"}, true)
+		Expect(ok).To(BeTrue())
+		Expect(ips).To(ConsistOf("1.1.1.1", "2.2.2.2"))
+
+		ips, _ = controller.dampen(t, []string{"1.1.1.1", "3.3.3.3"}, true)
+		Expect(ips).To(ConsistOf("1.1.1.1", "2.2.2.2"))
+		ips, _ = controller.dampen(t, []string{"1.1.1.1", "3.3.3.3"}, true)
+		Expect(ips).To(ConsistOf("1.1.1.1", "3.3.3.3"))
+	})
+
+	It("should only count lookups made by scheduled refreshes or spaced by the minimum refresh interval", func() {
+		newController(DampingOptions{Mode: DampingModeConsensus, Observations: 2, Lookups: 3})
+		controller.options.Refresh = RefreshOptions{Mode: RefreshModeFixed, MinInterval: time.Hour}
+
+		_, ok := controller.dampen(t, []string{"1.1.1.1"}, false)
+		Expect(ok).To(BeFalse())
+		// a retry or a change of the managed objects right after the first lookup is no observation
+		_, ok = controller.dampen(t, []string{"1.1.1.1"}, false)
+		Expect(ok).To(BeFalse())
+		Expect(t.damping.history).To(HaveLen(1))
+
+		ips, ok := controller.dampen(t, []string{"1.1.1.1"}, true)
+		Expect(ok).To(BeTrue())
+		Expect(ips).To(Equal([]string{"1.1.1.1"}))
+
+		// once the minimum refresh interval elapsed, the lookup is counted again
+		t.damping.observedAt = time.Now().Add(-time.Hour)
+		controller.dampen(t, []string{"2.2.2.2"}, false)
+		Expect(t.damping.history).To(HaveLen(3))
+	})
+
+	It("should advertise a set of addresses once every lookup returned it for the stable duration", func() {
+		newController(DampingOptions{Mode: DampingModeStable, StableDuration: time.Minute})
+		now := time.Now()
+
+		_, ok := controller.dampenByStability(t, []string{"1.1.1.1"}, now)
+		Expect(ok).To(BeFalse())
+		ips, ok := controller.dampenByStability(t, []string{"1.1.1.1"}, now.Add(time.Minute))
+		Expect(ok).To(BeTrue())
+		Expect(ips).To(Equal([]string{"1.1.1.1"}))
+
+		// a different answer restarts the stable duration
+		ips, _ = controller.dampenByStability(t, []string{"2.2.2.2"}, now.Add(2*time.Minute))
+		Expect(ips).To(Equal([]string{"1.1.1.1"}))
+		ips, _ = controller.dampenByStability(t, []string{"3.3.3.3"}, now.Add(150*time.Second))
+		Expect(ips).To(Equal([]string{"1.1.1.1"}))
+		ips, _ = controller.dampenByStability(t, []string{"3.3.3.3"}, now.Add(3*time.Minute))
+		Expect(ips).To(Equal([]string{"1.1.1.1"}))
+		ips, _ = controller.dampenByStability(t, []string{"3.3.3.3"}, now.Add(210*time.Second))
+		Expect(ips).To(Equal([]string{"3.3.3.3"}))
+	})
+
+	It("should leave the endpoint unchanged until the lookups agree", func() {
+		newController(DampingOptions{Mode: DampingModeConsensus, Observations: 2, Lookups: 2})
+		resolver["elb.example.com."] = []string{"1.2.3.4"}
+
+		Expect(controller.reconcileTarget(context.TODO(), t)).To(Succeed())
+		_, err := fakeClient.CoreV1().Endpoints(metav1.NamespaceDefault).Get(context.TODO(), "kubernetes", metav1.GetOptions{})
+		Expect(err).NotTo(BeNil())
+
+		Expect(controller.reconcileTarget(context.TODO(), t)).To(Succeed())
+		endpoint, err := fakeClient.CoreV1().Endpoints(metav1.NamespaceDefault).Get(context.TODO(), "kubernetes", metav1.GetOptions{})
+		Expect(err).To(BeNil())
+		Expect(endpoint.Subsets[0].Addresses).To(Equal([]corev1.EndpointAddress{{IP: "1.2.3.4"}}))
+	})
+})
@@ -16,12 +16,28 @@ package controller
 
 import (
 	"testing"
+	"time"
 
 	. "github.com/onsi/ginkgo"
 	. "github.com/onsi/gomega"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	k8sinformers "k8s.io/client-go/informers"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/tools/record"
 )
 
 func TestEndpoint(t *testing.T) {
 	RegisterFailHandler(Fail)
 	RunSpecs(t, "AWS-LB-Readvertiser Event Controller Suite")
 }
+
+// newTestController builds a controller with the informers of the given factory. Without targets it reconciles the
+// default/kubernetes endpoint of elb.example.com. The options override the defaults, e.g. the managed endpoint API.
+func newTestController(client kubernetes.Interface, informers k8sinformers.SharedInformerFactory, resolver Resolver, recorder record.EventRecorder, targets []Target, options Options) *AWSLBReadvertiserController {
+	if targets == nil {
+		targets = []Target{
+			{Hostnames: []string{"elb.example.com."}, EndpointNamespace: metav1.NamespaceDefault, EndpointName: "kubernetes", Ports: DefaultEndpointPorts(), RefreshPeriod: time.Second},
+		}
+	}
+	return NewAWSLBEndpointsController(client, informers.Core().V1().Endpoints(), informers.Discovery().V1().EndpointSlices(), resolver, recorder, targets, options)
+}
@@ -21,16 +21,17 @@ import (
 	. "github.com/onsi/ginkgo"
 	. "github.com/onsi/gomega"
 	corev1 "k8s.io/api/core/v1"
+	discoveryv1 "k8s.io/api/discovery/v1"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	k8sinformers "k8s.io/client-go/informers"
 	"k8s.io/client-go/kubernetes/fake"
+	"k8s.io/client-go/tools/record"
 )
 
 var _ = Describe("#applyTwoWayEndpointMergePatch", func() {
 	var (
 		fakeClient               = fake.NewSimpleClientset()
 		sharedK8sInformerFactory = k8sinformers.NewSharedInformerFactory(fakeClient, time.Duration(time.Hour))
-		endpointsInformer        = sharedK8sInformerFactory.Core().V1().Endpoints()
 
 		oldIP  = "1.2.3.4"
 		newIP  = "4.3.2.1"
@@ -64,14 +65,44 @@ var _ = Describe("#applyTwoWayEndpointMergePatch", func() {
 		_, err := fakeClient.CoreV1().Endpoints(metav1.NamespaceDefault).Create(context.TODO(), oldEndpoints, metav1.CreateOptions{})
 		Expect(err).To(BeNil())
 
-		controller := NewAWSLBEndpointsController(fakeClient, endpointsInformer, "elbHostname", "endpointName")
-		_, err = controller.applyTwoWayEndpointMergePatch(context.TODO(), oldEndpoints, []string{newIP})
+		controller := newTestController(fakeClient, sharedK8sInformerFactory, NewSystemResolver(0), record.NewFakeRecorder(100), nil, Options{})
+		_, _, err = controller.applyTwoWayEndpointMergePatch(context.TODO(), oldEndpoints, readyAddresses([]string{newIP}), DefaultEndpointPorts())
 		Expect(err).To(BeNil())
 
 		expected := oldEndpoints.DeepCopy()
 		expected.Subsets[0].Addresses[0].IP = newIP
+		expected.Annotations = map[string]string{managedPortsAnnotation: "https:443/TCP"}
 		actual, err := fakeClient.CoreV1().Endpoints(metav1.NamespaceDefault).Get(context.TODO(), epName, metav1.GetOptions{})
 		Expect(err).To(BeNil())
 		Expect(*actual).To(Equal(*expected))
 	})
+
+	It("should create the configured endpoint in the configured namespace", func() {
+		target := Target{Hostnames: []string{"elbHostname"}, EndpointNamespace: metav1.NamespaceSystem, EndpointName: "kube-apiserver-external", Ports: DefaultEndpointPorts(), RefreshPeriod: time.Second}
+		controller := newTestController(fakeClient, sharedK8sInformerFactory, NewSystemResolver(0), record.NewFakeRecorder(100), []Target{target}, Options{})
+		Expect(controller.reconcileEndpoints(context.TODO(), firstTarget(controller), readyAddresses([]string{newIP}))).To(Succeed())
+
+		actual, err := fakeClient.CoreV1().Endpoints(metav1.NamespaceSystem).Get(context.TODO(), "kube-apiserver-external", metav1.GetOptions{})
+		Expect(err).To(BeNil())
+		Expect(actual.Subsets).To(HaveLen(1))
+		Expect(actual.Subsets[0].Addresses).To(Equal([]corev1.EndpointAddress{{IP: newIP}}))
+	})
+
+	It("should exclude the endpoint from mirroring only while the endpointslices are written as well", func() {
+		target := Target{Hostnames: []string{"elbHostname"}, EndpointNamespace: metav1.NamespaceSystem, EndpointName: "kube-apiserver-mirrored", Ports: DefaultEndpointPorts(), RefreshPeriod: time.Second}
+		controller := newTestController(fakeClient, sharedK8sInformerFactory, NewSystemResolver(0), record.NewFakeRecorder(100), []Target{target}, Options{EndpointAPI: EndpointAPIBoth})
+		Expect(controller.reconcileEndpoints(context.TODO(), firstTarget(controller), readyAddresses([]string{newIP}))).To(Succeed())
+
+		actual, err := fakeClient.CoreV1().Endpoints(metav1.NamespaceSystem).Get(context.TODO(), "kube-apiserver-mirrored", metav1.GetOptions{})
+		Expect(err).To(BeNil())
+		Expect(actual.Labels).To(HaveKeyWithValue(discoveryv1.LabelSkipMirror, "true"))
+
+		Expect(sharedK8sInformerFactory.Core().V1().Endpoints().Informer().GetStore().Add(actual)).To(Succeed())
+		controller = newTestController(fakeClient, sharedK8sInformerFactory, NewSystemResolver(0), record.NewFakeRecorder(100), []Target{target}, Options{})
+		Expect(controller.reconcileEndpoints(context.TODO(), firstTarget(controller), readyAddresses([]string{newIP}))).To(Succeed())
+
+		actual, err = fakeClient.CoreV1().Endpoints(metav1.NamespaceSystem).Get(context.TODO(), "kube-apiserver-mirrored", metav1.GetOptions{})
+		Expect(err).To(BeNil())
+		Expect(actual.Labels).NotTo(HaveKey(discoveryv1.LabelSkipMirror))
+	})
 })
@@ -8,7 +8,7 @@ import (
 	"context"
 	"encoding/json"
 	"fmt"
-	"net"
+	"sync"
 	"time"
 
 	"k8s.io/apimachinery/pkg/api/errors"
@@ -18,186 +18,591 @@ import (
 	log "github.com/sirupsen/logrus"
 	corev1 "k8s.io/api/core/v1"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	utilerrors "k8s.io/apimachinery/pkg/util/errors"
 	"k8s.io/apimachinery/pkg/util/runtime"
+	"k8s.io/apimachinery/pkg/util/sets"
+	"k8s.io/apimachinery/pkg/util/wait"
 	informercorev1 "k8s.io/client-go/informers/core/v1"
+	informerdiscoveryv1 "k8s.io/client-go/informers/discovery/v1"
 	"k8s.io/client-go/kubernetes"
 	gettercorev1 "k8s.io/client-go/kubernetes/typed/core/v1"
 	listercorev1 "k8s.io/client-go/listers/core/v1"
+	listerdiscoveryv1 "k8s.io/client-go/listers/discovery/v1"
 	"k8s.io/client-go/tools/cache"
+	"k8s.io/client-go/tools/record"
+	"k8s.io/client-go/util/workqueue"
 )
 
-const endpointName = "kubernetes"
+const (
+	// retryBaseDelay is the delay before the first retry of a failed reconcile, it doubles with every failure
+	retryBaseDelay = time.Second
+	// retryMaxDelay caps the delay between retries of a failed reconcile
+	retryMaxDelay = 5 * time.Minute
+)
 
-//AWSLBReadvertiserController a controller for propagating newly monitored endpoints
+// AWSLBReadvertiserController a controller for propagating newly monitored endpoints
 type AWSLBReadvertiserController struct {
-	client              kubernetes.Interface
-	endpointsGetter     gettercorev1.EndpointsGetter
-	endpointsLister     listercorev1.EndpointsLister
-	endpointsListerSync cache.InformerSynced
+	client                  kubernetes.Interface
+	endpointsGetter         gettercorev1.EndpointsGetter
+	endpointsLister         listercorev1.EndpointsLister
+	endpointsListerSync     cache.InformerSynced
+	endpointSliceLister     listerdiscoveryv1.EndpointSliceLister
+	endpointSliceListerSync cache.InformerSynced
+	resolver                Resolver
+	recorder                record.EventRecorder
+
+	queue workqueue.RateLimitingInterface
+
+	endpointAPI string
+	ipFamily    IPFamily
+	targets     map[string]*target
+
+	options Options
+	health  *health
+}
 
-	elbHostName, endpointName string
+// Options tune the optional behaviour of the controller, their zero values keep it disabled
+type Options struct {
+	// EndpointAPI is one of EndpointAPIEndpoints, EndpointAPIEndpointSlice or EndpointAPIBoth, EndpointAPIEndpoints if
+	// empty
+	EndpointAPI string
+	// IPFamily are the addresses which are resolved and advertised, IPFamilyIPv4 if empty
+	IPFamily IPFamily
+
+	Health      HealthOptions
+	Refresh     RefreshOptions
+	Aggregation AggregationOptions
+	Damping     DampingOptions
+	GracePeriod GracePeriodOptions
+	// Prober checks the resolved IPs before they are advertised as ready, nil advertises all of them as ready
+	Prober Prober
+	// IdentityVerifier checks the TLS identity of the resolved IPs, IPs failing it are not advertised. nil disables
+	// the verification.
+	IdentityVerifier IdentityVerifier
+	// IPRanges are the AWS IP ranges the resolved IPs are validated against, nil disables the validation
+	IPRanges *IPRanges
+	// AddressFilter drops resolved addresses rejected by the configured CIDRs, invalid and special-purpose addresses
+	// are dropped even if it is nil
+	AddressFilter *AddressFilter
+	// Fallback configures how long the last known good addresses are served when the resolution fails
+	Fallback FallbackOptions
+	// Fight configures how overwrites of the managed endpoints by other writers are detected and handled
+	Fight FightOptions
+	// Write configures the field manager and whether the managed Endpoints objects are written by server-side apply
+	Write WriteOptions
 }
 
-// NewAWSLBEndpointsController initialize endpoints Informer
-func NewAWSLBEndpointsController(client kubernetes.Interface, endpointsInformer informercorev1.EndpointsInformer, elbHostName, endpointName string) *AWSLBReadvertiserController {
+// NewAWSLBEndpointsController initialize endpoints Informer, the given resolver is used to look up the ELB DNS names
+// of the targets and the recorder to emit events for the managed objects
+func NewAWSLBEndpointsController(client kubernetes.Interface, endpointsInformer informercorev1.EndpointsInformer, endpointSliceInformer informerdiscoveryv1.EndpointSliceInformer, resolver Resolver, recorder record.EventRecorder, targets []Target, options Options) *AWSLBReadvertiserController {
+	if len(options.EndpointAPI) == 0 {
+		options.EndpointAPI = EndpointAPIEndpoints
+	}
+	if len(options.IPFamily) == 0 {
+		options.IPFamily = IPFamilyIPv4
+	}
 	awsLBReadvertiserController := &AWSLBReadvertiserController{
-		client:              client,
-		endpointsGetter:     client.CoreV1(),
-		endpointsLister:     endpointsInformer.Lister(),
-		endpointsListerSync: endpointsInformer.Informer().HasSynced,
+		client:          client,
+		endpointsGetter: client.CoreV1(),
+		resolver:        resolver,
+		recorder:        recorder,
+
+		queue: workqueue.NewNamedRateLimitingQueue(workqueue.NewItemExponentialFailureRateLimiter(retryBaseDelay, retryMaxDelay), "targets"),
+
+		endpointAPI: options.EndpointAPI,
+		ipFamily:    options.IPFamily,
+		targets:     map[string]*target{},
+
+		options: options,
+		health:  newHealth(options.Health),
+	}
+
+	for _, spec := range targets {
+		awsLBReadvertiserController.targets[spec.Key()] = newTarget(spec)
+	}
 
-		elbHostName:  elbHostName,
-		endpointName: endpointName,
+	if awsLBReadvertiserController.manageEndpoints() {
+		awsLBReadvertiserController.endpointsLister = endpointsInformer.Lister()
+		awsLBReadvertiserController.endpointsListerSync = endpointsInformer.Informer().HasSynced
+		if _, err := endpointsInformer.Informer().AddEventHandler(awsLBReadvertiserController.endpointsEventHandler()); err != nil {
+			log.Errorf("could not register endpoints event handler: %v", err)
+		}
+	}
+	if awsLBReadvertiserController.manageEndpointSlices() {
+		awsLBReadvertiserController.endpointSliceLister = endpointSliceInformer.Lister()
+		awsLBReadvertiserController.endpointSliceListerSync = endpointSliceInformer.Informer().HasSynced
+		if _, err := endpointSliceInformer.Informer().AddEventHandler(awsLBReadvertiserController.endpointSliceEventHandler()); err != nil {
+			log.Errorf("could not register endpointslice event handler: %v", err)
+		}
 	}
 
 	return awsLBReadvertiserController
 }
 
-func (c *AWSLBReadvertiserController) applyTwoWayEndpointMergePatch(ctx context.Context, endpoint *corev1.Endpoints, dnsRecords []string) (*corev1.EndpointSubset, error) {
+// manageEndpoints returns true if the core/v1 Endpoints object is managed
+func (c *AWSLBReadvertiserController) manageEndpoints() bool {
+	return c.endpointAPI == EndpointAPIEndpoints || c.endpointAPI == EndpointAPIBoth
+}
+
+// manageEndpointSlices returns true if the discovery.k8s.io/v1 EndpointSlice is managed
+func (c *AWSLBReadvertiserController) manageEndpointSlices() bool {
+	return c.endpointAPI == EndpointAPIEndpointSlice || c.endpointAPI == EndpointAPIBoth
+}
+
+// listersSynced returns the sync functions of all registered informers
+func (c *AWSLBReadvertiserController) listersSynced() []cache.InformerSynced {
+	var synced []cache.InformerSynced
+	if c.endpointsListerSync != nil {
+		synced = append(synced, c.endpointsListerSync)
+	}
+	if c.endpointSliceListerSync != nil {
+		synced = append(synced, c.endpointSliceListerSync)
+	}
+	return synced
+}
+
+// applyTwoWayEndpointMergePatch patches the endpoint so that its managed subset carries the given addresses and ports,
+// the state of the addresses is persisted in annotations. Unmanaged subsets are left untouched. The patch fails with a
+// conflict if the endpoint changed since the given copy was read.
+func (c *AWSLBReadvertiserController) applyTwoWayEndpointMergePatch(ctx context.Context, endpoint *corev1.Endpoints, addresses advertisedAddresses, ports []corev1.EndpointPort) (*corev1.Endpoints, *corev1.EndpointSubset, error) {
 	endpointCopy := endpoint.DeepCopy()
 
-	endpoints, err := createEndpointSubsetObjectFromRecords(dnsRecords)
+	endpoints, err := createEndpointSubsetObjectFromRecords(addresses.ready, addresses.notReady, ports)
 	if err != nil {
-		return nil, fmt.Errorf("Failed to update endpoint")
+		return nil, nil, fmt.Errorf("Failed to update endpoint")
 	}
 
-	// Set Subset to new endpoint IPs
-	endpointCopy.Subsets = []corev1.EndpointSubset{*endpoints}
+	// Set the managed subset to new endpoint IPs
+	endpointCopy.Subsets = desiredSubsets(endpoint, *endpoints, ports, addresses.all())
+	endpointCopy.Annotations = setManagedPortsAnnotation(addresses.setAnnotations(endpointCopy.Annotations), ports)
+	endpointCopy.Labels = c.setSkipMirrorLabel(endpointCopy.Labels)
 
-	// start the update process with Kubernetes
-	oldEndpoint, err := json.Marshal(endpoint)
+	// start the update process with Kubernetes, the resource version is left out of the old endpoint so that the patch
+	// carries it as precondition
+	oldEndpointCopy := endpoint.DeepCopy()
+	oldEndpointCopy.ResourceVersion = ""
+	oldEndpoint, err := json.Marshal(oldEndpointCopy)
 	if err != nil {
-		return nil, fmt.Errorf("Failed to marshal old endpoint")
+		return nil, nil, fmt.Errorf("Failed to marshal old endpoint")
 	}
 
 	newEndPoint, err := json.Marshal(endpointCopy)
 	if err != nil {
-		return nil, fmt.Errorf("failed to marshal new endpoint")
+		return nil, nil, fmt.Errorf("failed to marshal new endpoint")
 	}
 
 	patchBytes, err := strategicpatch.CreateTwoWayMergePatch(oldEndpoint, newEndPoint, corev1.Endpoints{})
 	if err != nil {
-		return nil, fmt.Errorf("failed to patch bytes")
+		return nil, nil, fmt.Errorf("failed to patch bytes")
+	}
+
+	patched, err := c.client.CoreV1().Endpoints(endpoint.Namespace).Patch(ctx, endpoint.Name, types.StrategicMergePatchType, patchBytes, metav1.PatchOptions{FieldManager: c.fieldManager()})
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to update endpoint with new value: %w", err)
+	}
+	return patched, endpoints, nil
+}
+
+// writeEndpoint writes the addresses to the existing endpoint of the target, by server-side apply if it is enabled
+func (c *AWSLBReadvertiserController) writeEndpoint(ctx context.Context, t *target, endpoint *corev1.Endpoints, addresses advertisedAddresses) (*corev1.EndpointSubset, error) {
+	var written *corev1.Endpoints
+	var subset *corev1.EndpointSubset
+	var err error
+	if c.options.Write.ServerSideApply {
+		written, subset, err = c.applyEndpoint(ctx, t, endpoint, addresses, t.Ports)
+		recordEndpointWrite(t, resourceEndpoints, operationApply, err)
+	} else {
+		written, subset, err = c.applyTwoWayEndpointMergePatch(ctx, endpoint, addresses, t.Ports)
+		recordEndpointWrite(t, resourceEndpoints, operationPatch, err)
+	}
+	if err != nil {
+		return nil, err
+	}
+	c.observeWrite(t, resourceEndpoints, endpoint.ResourceVersion, written.ResourceVersion)
+	return subset, nil
+}
+
+// reconcileEndpoints creates or patches the endpoint so that it carries exactly the given addresses. Writes based on
+// an outdated copy of the endpoint are retried with the current one.
+func (c *AWSLBReadvertiserController) reconcileEndpoints(ctx context.Context, t *target, addresses advertisedAddresses) error {
+	return c.retryOnConflict(t, resourceEndpoints, func(fromCache bool) error {
+		return c.reconcileEndpointsFrom(ctx, t, addresses, fromCache)
+	})
+}
+
+// reconcileEndpointsFrom reconciles the endpoint based on its cached copy, or on the current one read from the API
+// server if fromCache is false. Nothing is written while the cached copy predates the last write of the endpoint.
+func (c *AWSLBReadvertiserController) reconcileEndpointsFrom(ctx context.Context, t *target, addresses advertisedAddresses, fromCache bool) error {
+	var endpoint *corev1.Endpoints
+	var err error
+	if fromCache {
+		endpoint, err = c.endpointsLister.Endpoints(t.EndpointNamespace).Get(t.EndpointName)
+		var resourceVersion string
+		if err == nil {
+			resourceVersion = endpoint.ResourceVersion
+		}
+		if c.awaitingWrite(t, resourceEndpoints, err == nil, resourceVersion) {
+			return nil
+		}
+		if errors.IsNotFound(err) && c.options.Write.ServerSideApply {
+			// an apply without resource version would replace the unmanaged subsets of an endpoint missing in the cache
+			endpoint, err = c.client.CoreV1().Endpoints(t.EndpointNamespace).Get(ctx, t.EndpointName, metav1.GetOptions{})
+		}
+	} else {
+		endpoint, err = c.client.CoreV1().Endpoints(t.EndpointNamespace).Get(ctx, t.EndpointName, metav1.GetOptions{})
+	}
+
+	createEndpoint := func() error {
+		var err error
+		if c.options.Write.ServerSideApply {
+			endpoint, _, err = c.applyEndpoint(ctx, t, nil, addresses, t.Ports)
+			recordEndpointWrite(t, resourceEndpoints, operationApply, err)
+		} else {
+			var endpointSubset *corev1.EndpointSubset
+			endpointSubset, err = createEndpointSubsetObjectFromRecords(addresses.ready, addresses.notReady, t.Ports)
+			if err != nil {
+				return fmt.Errorf("%s warning: could not resolve the DNS name of the elb: %v", time.Now(), err)
+			}
+
+			endpoint, err = c.client.CoreV1().Endpoints(t.EndpointNamespace).Create(ctx, &corev1.Endpoints{
+				ObjectMeta: metav1.ObjectMeta{
+					Name:        t.EndpointName,
+					Namespace:   t.EndpointNamespace,
+					Labels:      c.setSkipMirrorLabel(nil),
+					Annotations: setManagedPortsAnnotation(addresses.setAnnotations(nil), t.Ports),
+				},
+				Subsets: []corev1.EndpointSubset{*endpointSubset},
+			}, metav1.CreateOptions{FieldManager: c.fieldManager()})
+			recordEndpointWrite(t, resourceEndpoints, operationCreate, err)
+		}
+		if err != nil {
+			if !isWriteConflict(err) {
+				c.recordEvent(t, corev1.EventTypeWarning, EventReasonCreateFailed, "Failed to create endpoint: %v", err)
+			}
+			return fmt.Errorf("%s warning: could not create the %s/%s endpoint : %w", time.Now(), t.EndpointNamespace, t.EndpointName, err)
+		}
+		c.recordEvent(t, corev1.EventTypeNormal, EventReasonEndpointCreated, "Created endpoint with IPs %q", addresses.ready)
+		c.observeEndpointWrite(t, addresses, time.Now())
+
+		return nil
+	}
+
+	if err != nil {
+		// Check if the endpoint is there and create it if its not
+		if errors.IsNotFound(err) {
+			t.log.Infof("The %s/%s endpoint was not found, creating it now", t.EndpointNamespace, t.EndpointName)
+			if err := createEndpoint(); err != nil {
+				return err
+			}
+		} else {
+			return fmt.Errorf("%s error: could not get endpoint, an error occurred: %v", time.Now(), err)
+		}
+	} else {
+		ready, notReady := subsetIPs(managedSubsets(endpoint, t.Ports, addresses.all()))
+		write, err := c.checkFight(t, endpoint, ready, notReady)
+		if err != nil || !write {
+			return err
+		}
+	}
+
+	// only the subsets carrying the configured ports, or the ports written last, are managed
+	managed := managedSubsets(endpoint, t.Ports, addresses.all())
+
+	// handle the case where endpoint exists but has no managed subset
+	if len(managed) == 0 {
+		t.log.Infof("Found no subset with ports %v in the %s/%s endpoint, adding correct LB IPs", t.Ports, t.EndpointNamespace, t.EndpointName)
+		endpoints, err := c.writeEndpoint(ctx, t, endpoint, addresses)
+		if err != nil {
+			if !isWriteConflict(err) {
+				c.recordEvent(t, corev1.EventTypeWarning, writeFailedReason(err), "Failed to add IPs %q to empty endpoint: %v", addresses.ready, err)
+			}
+			return err
+		}
+		c.recordEvent(t, corev1.EventTypeNormal, EventReasonAddressesUpdated, "Advertised IPs changed from [] to %q", addresses.ready)
+		c.observeEndpointWrite(t, addresses, time.Now())
+		newEndpointAddresses, err := fetchEndpointIPsFromAddresses(endpoints.Addresses)
+		if err != nil {
+			t.log.Error("Endpoint subset has empty IPs")
+		}
+		t.log.Infof("New endpoint IPs are %q, resolved IPs are %q, static IPs are %q, excluded IPs are %q", newEndpointAddresses, addresses.resolved(), t.StaticAddresses, t.ExcludeAddresses)
+		return nil
+	}
+
+	// Endpoint exists but with the possibility of outdated IPs, the managed subsets may lack ready or not ready addresses
+	endpointIPs, notReadyIPs := subsetIPs(managed)
+	t.log.Infof("Kubernetes Endpoint IPs : %q", endpointIPs)
+
+	// Check validity of endpoint and change respectively, more than one managed subset is merged into one
+	ipsValid := checkEndpointIsStillValid(endpointIPs, addresses.ready)
+	notReadyValid := checkEndpointIsStillValid(notReadyIPs, addresses.notReady)
+	portsValid := checkEndpointPortsAreStillValid(managed[0].Ports, t.Ports)
+	subsetsValid := len(managed) == 1
+	annotationsValid := addresses.annotationsValid(endpoint.Annotations) && endpoint.Annotations[managedPortsAnnotation] == endpointPortsKey(t.Ports)
+	labelsValid := c.skipMirrorLabelValid(endpoint.Labels)
+	if ipsValid && notReadyValid && portsValid && subsetsValid && annotationsValid && labelsValid {
+		t.log.Info("Nothing to be done")
+		return nil
+	}
+
+	if !ipsValid || !notReadyValid {
+		t.log.Info("ELB records changed, reconciling cluster endpoint to match")
+	}
+	if !portsValid {
+		t.log.Infof("Endpoint ports %v differ from the configured ports %v, reconciling cluster endpoint to match", managed[0].Ports, t.Ports)
+	}
+	if !subsetsValid {
+		t.log.Infof("Merging the %d subsets with the configured ports %v into one", len(managed), t.Ports)
+	}
+
+	endpoints, err := c.writeEndpoint(ctx, t, endpoint, addresses)
+	if err != nil {
+		if !isWriteConflict(err) {
+			c.recordEvent(t, corev1.EventTypeWarning, writeFailedReason(err), "Failed to change advertised IPs from %q to %q: %v", endpointIPs, addresses.ready, err)
+		}
+		return err
+	}
+	c.observeEndpointWrite(t, addresses, time.Now())
+	if !ipsValid {
+		c.recordEvent(t, corev1.EventTypeNormal, EventReasonAddressesUpdated, "Advertised IPs changed from %q to %q", endpointIPs, addresses.ready)
+	}
+	if !notReadyValid {
+		c.recordEvent(t, corev1.EventTypeNormal, EventReasonAddressesUpdated, "Advertised not ready IPs changed from %q to %q", notReadyIPs, addresses.notReady)
+	}
+	if !portsValid {
+		c.recordEvent(t, corev1.EventTypeNormal, EventReasonAddressesUpdated, "Advertised ports changed from %v to %v", managed[0].Ports, t.Ports)
 	}
 
-	_, err = c.client.CoreV1().Endpoints(metav1.NamespaceDefault).Patch(ctx, endpoint.Name, types.StrategicMergePatchType, patchBytes, metav1.PatchOptions{})
+	newEndpointAddresses, err := fetchEndpointIPsFromAddresses(endpoints.Addresses)
 	if err != nil {
-		return nil, fmt.Errorf("failed to update endpoint with new value: %s", err.Error())
+		t.log.Info("Endpoint subset has no ready IPs")
 	}
-	return endpoints, nil
+	t.log.Infof("Old endpoint IPs are %q, new endpoint IPs are %q, resolved IPs are %q, static IPs are %q, excluded IPs are %q", endpointIPs, newEndpointAddresses, addresses.resolved(), t.StaticAddresses, t.ExcludeAddresses)
+	return nil
 }
 
-//Run the AWSLBReconciler
-func (c *AWSLBReadvertiserController) Run(ctx context.Context, refreshTicker *time.Ticker) {
+// Run the AWSLBReconciler. Targets are enqueued at their refresh period and whenever their managed objects change,
+// failed reconciles are retried with an exponential per target backoff.
+func (c *AWSLBReadvertiserController) Run(ctx context.Context) {
 	defer func() {
 		runtime.HandleCrash()
 	}()
+	defer c.queue.ShutDown()
 
 	log.Info("waiting for cache sync")
-	if !cache.WaitForCacheSync(ctx.Done(), c.endpointsListerSync) {
+	if !cache.WaitForCacheSync(ctx.Done(), c.listersSynced()...) {
 		log.Print("timed out waiting for cache sync")
 		return
 	}
 	log.Info("Caches are synced")
+	c.checkEndpointReconciler(ctx)
+
+	c.health.setRunning(true)
+	defer c.health.setRunning(false)
 
 	log.Info("Watching AWS ELB records for changes...!!")
-	for {
-		select {
+	for _, t := range c.targets {
+		c.queue.Add(t.Key())
+		// in RefreshModeTTL every successful reconcile schedules the next refresh of its target
+		if !c.refreshByTTL() {
+			go c.runRefreshTicker(ctx, t)
+			continue
+		}
+		t.log.Infof("Watching %q before their TTL expires, every %s to %s", t.Hostnames, c.options.Refresh.MinInterval, c.options.Refresh.MaxInterval)
+	}
 
-		case <-refreshTicker.C:
-			var (
-				endpointIPs []string
-				dnsRecords  []string
-			)
+	// every target gets its own worker, so that a slow lookup of one target does not delay the others
+	var wg sync.WaitGroup
+	for i := 0; i < len(c.targets); i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			wait.UntilWithContext(ctx, c.runWorker, time.Second)
+		}()
+	}
 
-			// lookup Elastic Loadbalancer DNS name
-			dnsRecords, err := net.LookupHost(c.elbHostName)
-			if err != nil {
-				log.Errorf("%s warning: could not resolve the DNS name of the elb: %v\n", time.Now(), err)
-				break
-			}
-			log.Printf("DNS lookup results are: %s", dnsRecords)
-
-			endpoint, err := c.endpointsLister.Endpoints(metav1.NamespaceDefault).Get(endpointName)
-			createEndpoint := func() error {
-				endpointSubset, err := createEndpointSubsetObjectFromRecords(dnsRecords)
-				if err != nil {
-					return fmt.Errorf("%s warning: could not resolve the DNS name of the elb: %v", time.Now(), err)
-				}
-
-				endpoint, err = c.client.CoreV1().Endpoints(metav1.NamespaceDefault).Create(ctx, &corev1.Endpoints{
-					ObjectMeta: metav1.ObjectMeta{
-						Name: endpointName,
-					},
-					Subsets: []corev1.EndpointSubset{*endpointSubset},
-				}, metav1.CreateOptions{})
-				if err != nil {
-					return fmt.Errorf("%s warning: could not create the kubernetes endpoint : %v", time.Now(), err)
-				}
-
-				return nil
-			}
+	<-ctx.Done()
+	// stop handing out targets and let in-flight reconciles finish before returning
+	c.queue.ShutDown()
+	wg.Wait()
+	log.Info("Stopped reconciling")
+}
 
-			if err != nil {
-				// Check if the endpoint is there and create it if its not
-				if errors.IsNotFound(err) {
-					log.Infof("The default %q endpoint was not found, creating it now", "kubernetes")
-					if err := createEndpoint(); err != nil {
-						log.Error(err)
-						break
-					}
-				} else {
-					log.Errorf("%s error: could not get endpoint, an error occurred: %v\n", time.Now(), err)
-					break
-				}
-			}
+// runRefreshTicker enqueues the target at its refresh period until the context is cancelled
+func (c *AWSLBReadvertiserController) runRefreshTicker(ctx context.Context, t *target) {
+	defer func() {
+		runtime.HandleCrash()
+	}()
 
-			// handle the case where endpoint exists but has no subsets
-			if len(endpoint.Subsets) == 0 {
-				log.Infof("Found empty %s endpoint, adding correct LB IPs", endpointName)
-				endpoints, err := c.applyTwoWayEndpointMergePatch(ctx, endpoint, dnsRecords)
-				if err != nil {
-					log.Error(err)
-					break
-				}
-				newEndpointAddresses, err := fetchEndpointIPsFromAddresses(endpoints.Addresses)
-				if err != nil {
-					log.Error("Endpoint subset has empty IPs")
-				}
-				log.Infof("New endpoint IPs are %q, ELB IPs are %s", newEndpointAddresses, dnsRecords)
-			} else {
-				// Endpoint exists but with the possibility of outdated IPs
-				endpointIPs, err = fetchEndpointIPsFromAddresses(endpoint.Subsets[0].Addresses)
-				if err != nil {
-					log.Error(err)
-					break
-				}
-				log.Infof("Kubernetes Endpoint IPs : %q", endpointIPs)
-
-				// Check validity of endpoint and change respectively
-				if checkEndpointIsStillValid(endpointIPs, dnsRecords) {
-					log.Info("Nothing to be done")
-					break
-				}
-
-				log.Info("ELB records changed, reconciling cluster endpoint to match")
-
-				endpoints, err := c.applyTwoWayEndpointMergePatch(ctx, endpoint, dnsRecords)
-				if err != nil {
-					log.Error(err)
-					break
-				}
-
-				newEndpointAddresses, err := fetchEndpointIPsFromAddresses(endpoints.Addresses)
-				if err != nil {
-					log.Error("Endpoint subset has empty IPs")
-				}
-				log.Infof("Old endpoint IPs are %q, new endpoint IPs are %q, ELB IPs are %s", endpointIPs, newEndpointAddresses, dnsRecords)
-			}
+	refreshTicker := time.NewTicker(t.RefreshPeriod)
+	defer refreshTicker.Stop()
+
+	t.log.Infof("Watching %q every %s", t.Hostnames, t.RefreshPeriod)
+	for {
+		select {
+		case <-refreshTicker.C:
+			c.enqueueRefresh(t)
 
 		case <-ctx.Done():
-			refreshTicker.Stop()
 			return
 		}
 	}
 }
+
+func (c *AWSLBReadvertiserController) runWorker(ctx context.Context) {
+	for c.processNextWorkItem(ctx) {
+	}
+}
+
+// processNextWorkItem reconciles the next target of the queue, a failing target is requeued with backoff
+func (c *AWSLBReadvertiserController) processNextWorkItem(ctx context.Context) bool {
+	key, quit := c.queue.Get()
+	if quit {
+		return false
+	}
+	defer c.queue.Done(key)
+
+	t, ok := c.targets[key.(string)]
+	if !ok {
+		c.queue.Forget(key)
+		return true
+	}
+
+	err := c.reconcileTarget(ctx, t)
+	recordReconcile(t, err)
+	c.health.observeReconcile(t.Key(), err)
+	if writesSuspended(err) {
+		t.log.Infof("Not writing the endpoint: %v", err)
+		err = nil
+	}
+	if err != nil {
+		t.log.Errorf("%v (retry %d)", err, c.queue.NumRequeues(key)+1)
+		c.queue.AddRateLimited(key)
+		return true
+	}
+
+	c.queue.Forget(key)
+	if c.refreshByTTL() {
+		if next := c.nextRefresh(t); c.scheduleRefresh(t, next) {
+			t.log.Debugf("Refreshing in %s (TTL %s)", next, t.ttl)
+		}
+	}
+	return true
+}
+
+// lookupTarget resolves all hostnames of the target and returns the union of their addresses
+func (c *AWSLBReadvertiserController) lookupTarget(ctx context.Context, t *target) ([]string, error) {
+	var ttl time.Duration
+	records := sets.NewString()
+	for _, hostname := range t.Hostnames {
+		// lookup Elastic Loadbalancer DNS name
+		start := time.Now()
+		dnsRecords, hostnameTTL, err := c.resolver.LookupHost(ctx, hostname, c.ipFamily)
+		recordDNSLookup(t, hostname, start, err)
+		if err != nil {
+			c.recordEvent(t, corev1.EventTypeWarning, EventReasonDNSLookupFailed, "Could not resolve %q: %v", hostname, err)
+			return nil, fmt.Errorf("%s warning: could not resolve the DNS name %q of the elb: %v", time.Now(), hostname, err)
+		}
+		t.log.Printf("DNS lookup results of %q are: %s", hostname, dnsRecords)
+		records.Insert(dnsRecords...)
+		ttl = minTTL(ttl, hostnameTTL)
+		if t.hostnames == nil {
+			t.hostnames = map[string]string{}
+		}
+		for _, ip := range dnsRecords {
+			t.hostnames[ip] = hostname
+		}
+	}
+	t.ttl = ttl
+	resolvedIPs.WithLabelValues(t.Key()).Set(float64(records.Len()))
+	dnsTTL.WithLabelValues(t.Key()).Set(ttl.Seconds())
+	return records.List(), nil
+}
+
+// reconcileTarget resolves the hostnames of the target and advertises their addresses in the managed endpoint objects
+func (c *AWSLBReadvertiserController) reconcileTarget(ctx context.Context, t *target) error {
+	t.compared = false
+	refresh := t.takeRefresh()
+	if !c.lookupDue(t, refresh, time.Now()) {
+		t.log.Debug("Managed objects changed within the minimum refresh interval, writing the addresses of the last lookup")
+		return c.writeAddresses(ctx, t, *t.lastLookup)
+	}
+
+	ips, err := c.resolveTarget(ctx, t)
+	if err != nil {
+		return c.fallBackToLastKnownGood(ctx, t, err)
+	}
+	lastKnownGoodAge.WithLabelValues(t.Key()).Set(0)
+
+	now := time.Now()
+	aggregated := c.aggregate(t, ips, now)
+	advertised, ok := c.dampen(t, aggregated, refresh)
+	if !ok {
+		t.log.Info("The lookups did not agree on the addresses yet, leaving the endpoint unchanged")
+		return nil
+	}
+	addresses := c.retainRemovedAddresses(t, advertised, now)
+	addresses = c.probeAddresses(ctx, t, addresses)
+	// the static addresses are not persisted, they are added to the last known good addresses when falling back
+	addresses.lastKnownGood = c.newLastKnownGood(t, addresses, now)
+	addresses = c.addStaticAddresses(t, addresses)
+
+	if err := c.writeAddresses(ctx, t, addresses); err != nil {
+		return err
+	}
+	t.lastKnownGood, t.lastKnownGoodLoaded = addresses.lastKnownGood, true
+	t.lastLookup, t.lastLookupAt = &addresses, now
+	c.persistLastKnownGood(ctx, t, addresses.lastKnownGood)
+	return nil
+}
+
+// resolveTarget looks up the hostnames of the target and returns the addresses which passed the filters, the IP ranges
+// validation and the identity verification. It fails if no address is left.
+func (c *AWSLBReadvertiserController) resolveTarget(ctx context.Context, t *target) ([]string, error) {
+	dnsRecords, err := c.lookupTarget(ctx, t)
+	if err != nil {
+		return nil, err
+	}
+
+	ipv4, ipv6 := partitionIPFamilies(c.filterAddresses(t, c.excludeAddresses(t, dnsRecords)), c.ipFamily)
+	if len(ipv4)+len(ipv6) == 0 {
+		c.recordEvent(t, corev1.EventTypeWarning, EventReasonEmptyResolution, "%q resolved to no addresses of IP family %s, keeping the advertised IPs", t.Hostnames, c.ipFamily)
+		return nil, fmt.Errorf("DNS lookup returned no addresses of IP family %s", c.ipFamily)
+	}
+
+	inRanges := c.validateIPRanges(t, append(ipv4, ipv6...))
+	if len(inRanges) == 0 {
+		return nil, fmt.Errorf("none of the resolved addresses is part of the AWS IP ranges")
+	}
+
+	verified := c.verifyIdentities(ctx, t, inRanges)
+	if len(verified) == 0 {
+		return nil, fmt.Errorf("none of the resolved addresses passed the TLS identity verification")
+	}
+	return verified, nil
+}
+
+// writeAddresses writes the addresses to the managed objects of the target
+func (c *AWSLBReadvertiserController) writeAddresses(ctx context.Context, t *target, addresses advertisedAddresses) error {
+	// reset if a managed object is skipped as it awaits its last write
+	t.compared = true
+	var errs []error
+	if c.manageEndpoints() {
+		if err := c.reconcileEndpoints(ctx, t, addresses); err != nil {
+			errs = append(errs, err)
+		}
+	}
+
+	if c.manageEndpointSlices() {
+		// EndpointSlices only carry a single address type, hence one slice is written per IP family
+		for _, addressType := range endpointSliceAddressTypes(c.ipFamily) {
+			if err := c.reconcileEndpointSlice(ctx, t, addressType, addresses.ofAddressType(addressType)); err != nil {
+				errs = append(errs, err)
+			}
+		}
+		if err := c.deleteStaleEndpointSlices(ctx, t); err != nil {
+			errs = append(errs, err)
+		}
+	}
+
+	return utilerrors.NewAggregate(errs)
+}
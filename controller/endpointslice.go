@@ -0,0 +1,265 @@
+// SPDX-FileCopyrightText: 2020 SAP SE or an SAP affiliate company and Gardener contributors
+//
+// SPDX-License-Identifier: Apache-2.0
+
+package controller
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	corev1 "k8s.io/api/core/v1"
+	discoveryv1 "k8s.io/api/discovery/v1"
+	"k8s.io/apimachinery/pkg/api/equality"
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	utilerrors "k8s.io/apimachinery/pkg/util/errors"
+	"k8s.io/apimachinery/pkg/util/sets"
+	"k8s.io/utils/pointer"
+)
+
+const (
+	// EndpointAPIEndpoints only manages the core/v1 Endpoints object
+	EndpointAPIEndpoints = "endpoints"
+	// EndpointAPIEndpointSlice only manages a discovery.k8s.io/v1 EndpointSlice
+	EndpointAPIEndpointSlice = "endpointslice"
+	// EndpointAPIBoth manages both the Endpoints object and the EndpointSlice
+	EndpointAPIBoth = "both"
+
+	// endpointSliceManagedBy is the value of the managed-by label of the EndpointSlices written by the readvertiser
+	endpointSliceManagedBy = "aws-lb-readvertiser.gardener.cloud"
+)
+
+// endpointSliceName returns the name of the EndpointSlice carrying the addresses of the given type
+func endpointSliceName(endpointName string, addressType discoveryv1.AddressType) string {
+	return fmt.Sprintf("%s-%s", endpointName, strings.ToLower(string(addressType)))
+}
+
+// setSkipMirrorLabel sets the skip-mirror label in the given labels of the Endpoints object if the readvertiser
+// writes the EndpointSlices itself, so that the EndpointSlice mirroring controller does not write a second set of
+// slices for it. Otherwise the label is removed, so that the mirrored slices are written again.
+func (c *AWSLBReadvertiserController) setSkipMirrorLabel(labels map[string]string) map[string]string {
+	if !c.manageEndpointSlices() {
+		delete(labels, discoveryv1.LabelSkipMirror)
+		return labels
+	}
+	if labels == nil {
+		labels = map[string]string{}
+	}
+	labels[discoveryv1.LabelSkipMirror] = "true"
+	return labels
+}
+
+// skipMirrorLabelValid returns whether the given labels of the Endpoints object carry the skip-mirror label if and
+// only if the readvertiser writes the EndpointSlices itself
+func (c *AWSLBReadvertiserController) skipMirrorLabelValid(labels map[string]string) bool {
+	value, ok := labels[discoveryv1.LabelSkipMirror]
+	if c.manageEndpointSlices() {
+		return value == "true"
+	}
+	return !ok
+}
+
+// endpointSliceAddressTypes returns the address types of the EndpointSlices written for the given IP family
+func endpointSliceAddressTypes(family IPFamily) []discoveryv1.AddressType {
+	switch family {
+	case IPFamilyIPv6:
+		return []discoveryv1.AddressType{discoveryv1.AddressTypeIPv6}
+	case IPFamilyDual:
+		return []discoveryv1.AddressType{discoveryv1.AddressTypeIPv4, discoveryv1.AddressTypeIPv6}
+	default:
+		return []discoveryv1.AddressType{discoveryv1.AddressTypeIPv4}
+	}
+}
+
+// createEndpointSliceObjectFromRecords creates the desired EndpointSlice of the given address type for a set of
+// addresses. No addresses result in a slice without endpoints, e.g. for the IPv6 slice of a dual-stack service while
+// the load balancer has no AAAA records.
+func createEndpointSliceObjectFromRecords(namespace, endpointName string, addressType discoveryv1.AddressType, addresses advertisedAddresses, endpointPorts []corev1.EndpointPort) *discoveryv1.EndpointSlice {
+	var endpoints []discoveryv1.Endpoint
+	// the IPs are sorted as DNS answers rotate and the order must not be detected as a change
+	for _, ip := range sets.NewString(addresses.ready...).List() {
+		endpoints = append(endpoints, discoveryv1.Endpoint{
+			Addresses: []string{ip},
+			Conditions: discoveryv1.EndpointConditions{
+				Ready: pointer.Bool(true),
+			},
+		})
+	}
+	for _, ip := range sets.NewString(addresses.notReady...).List() {
+		endpoints = append(endpoints, discoveryv1.Endpoint{
+			Addresses: []string{ip},
+			Conditions: discoveryv1.EndpointConditions{
+				Ready: pointer.Bool(false),
+			},
+		})
+	}
+
+	var ports []discoveryv1.EndpointPort
+	for _, port := range endpointPorts {
+		protocol := port.Protocol
+		ports = append(ports, discoveryv1.EndpointPort{
+			Name:        pointer.String(port.Name),
+			Port:        pointer.Int32(port.Port),
+			Protocol:    &protocol,
+			AppProtocol: port.AppProtocol,
+		})
+	}
+
+	return &discoveryv1.EndpointSlice{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      endpointSliceName(endpointName, addressType),
+			Namespace: namespace,
+			Labels: map[string]string{
+				discoveryv1.LabelServiceName: endpointName,
+				discoveryv1.LabelManagedBy:   endpointSliceManagedBy,
+			},
+			Annotations: addresses.setAnnotations(nil),
+		},
+		AddressType: addressType,
+		Endpoints:   endpoints,
+		Ports:       ports,
+	}
+}
+
+// checkEndpointSliceIsStillValid checks if the existing EndpointSlice carries the labels, state annotations,
+// addresses and ports of the desired one
+func checkEndpointSliceIsStillValid(current, desired *discoveryv1.EndpointSlice) bool {
+	for key, value := range desired.Labels {
+		if current.Labels[key] != value {
+			return false
+		}
+	}
+	for _, key := range []string{retainedAddressesAnnotation, lastKnownGoodAnnotation} {
+		if current.Annotations[key] != desired.Annotations[key] {
+			return false
+		}
+	}
+
+	return current.AddressType == desired.AddressType &&
+		equality.Semantic.DeepEqual(current.Endpoints, desired.Endpoints) &&
+		equality.Semantic.DeepEqual(current.Ports, desired.Ports)
+}
+
+// reconcileEndpointSlice creates or updates the EndpointSlice of the given address type so that it carries exactly
+// the given addresses. Writes based on an outdated copy of the EndpointSlice are retried with the current one.
+func (c *AWSLBReadvertiserController) reconcileEndpointSlice(ctx context.Context, t *target, addressType discoveryv1.AddressType, addresses advertisedAddresses) error {
+	return c.retryOnConflict(t, resourceEndpointSlice, func(fromCache bool) error {
+		return c.reconcileEndpointSliceFrom(ctx, t, addressType, addresses, fromCache)
+	})
+}
+
+// reconcileEndpointSliceFrom reconciles the EndpointSlice based on its cached copy, or on the current one read from
+// the API server if fromCache is false. Nothing is written while the cached copy predates the last write of the
+// EndpointSlice.
+func (c *AWSLBReadvertiserController) reconcileEndpointSliceFrom(ctx context.Context, t *target, addressType discoveryv1.AddressType, addresses advertisedAddresses, fromCache bool) error {
+	namespace := t.EndpointNamespace
+	desired := createEndpointSliceObjectFromRecords(namespace, t.EndpointName, addressType, addresses, t.Ports)
+	ips := addresses.all()
+	key := resourceEndpointSlice + "/" + desired.Name
+
+	var current *discoveryv1.EndpointSlice
+	var err error
+	if fromCache {
+		current, err = c.endpointSliceLister.EndpointSlices(namespace).Get(desired.Name)
+		var resourceVersion string
+		if err == nil {
+			resourceVersion = current.ResourceVersion
+		}
+		if c.awaitingWrite(t, key, err == nil, resourceVersion) {
+			return nil
+		}
+	} else {
+		current, err = c.client.DiscoveryV1().EndpointSlices(namespace).Get(ctx, desired.Name, metav1.GetOptions{})
+	}
+	if err != nil {
+		if !errors.IsNotFound(err) {
+			return fmt.Errorf("could not get endpointslice %s/%s: %v", namespace, desired.Name, err)
+		}
+
+		t.log.Infof("The %s/%s endpointslice was not found, creating it now", namespace, desired.Name)
+		_, err = c.client.DiscoveryV1().EndpointSlices(namespace).Create(ctx, desired, metav1.CreateOptions{FieldManager: c.fieldManager()})
+		recordEndpointWrite(t, resourceEndpointSlice, operationCreate, err)
+		if err != nil {
+			if !isWriteConflict(err) {
+				c.recordEvent(t, corev1.EventTypeWarning, EventReasonCreateFailed, "Failed to create endpointslice %s: %v", desired.Name, err)
+			}
+			return fmt.Errorf("could not create the %s/%s endpointslice: %w", namespace, desired.Name, err)
+		}
+		c.recordEvent(t, corev1.EventTypeNormal, EventReasonEndpointCreated, "Created endpointslice %s with IPs %q", desired.Name, ips)
+		t.log.Infof("Created endpointslice %s/%s with IPs %q", namespace, desired.Name, ips)
+		return nil
+	}
+
+	if current.AddressType != desired.AddressType {
+		return fmt.Errorf("endpointslice %s/%s has address type %s, expected %s", namespace, desired.Name, current.AddressType, desired.AddressType)
+	}
+
+	if checkEndpointSliceIsStillValid(current, desired) {
+		t.log.Infof("Endpointslice %s/%s is up to date", namespace, desired.Name)
+		return nil
+	}
+
+	updated := current.DeepCopy()
+	if updated.Labels == nil {
+		updated.Labels = map[string]string{}
+	}
+	for key, value := range desired.Labels {
+		updated.Labels[key] = value
+	}
+	updated.Annotations = addresses.setAnnotations(updated.Annotations)
+	updated.Endpoints = desired.Endpoints
+	updated.Ports = desired.Ports
+
+	// the update carries the resource version of the copy it is based on, hence it fails if the slice changed since
+	written, err := c.client.DiscoveryV1().EndpointSlices(namespace).Update(ctx, updated, metav1.UpdateOptions{FieldManager: c.fieldManager()})
+	recordEndpointWrite(t, resourceEndpointSlice, operationUpdate, err)
+	if err != nil {
+		if !isWriteConflict(err) {
+			c.recordEvent(t, corev1.EventTypeWarning, EventReasonPatchFailed, "Failed to update endpointslice %s: %v", desired.Name, err)
+		}
+		return fmt.Errorf("failed to update endpointslice %s/%s with new value: %w", namespace, desired.Name, err)
+	}
+	c.observeWrite(t, key, current.ResourceVersion, written.ResourceVersion)
+	t.log.Infof("Updated endpointslice %s/%s, old IPs are %q, new IPs are %q", namespace, desired.Name, fetchEndpointSliceIPs(current), ips)
+	c.recordEvent(t, corev1.EventTypeNormal, EventReasonAddressesUpdated, "Advertised IPs of endpointslice %s changed from %q to %q", desired.Name, fetchEndpointSliceIPs(current), ips)
+	return nil
+}
+
+// deleteStaleEndpointSlices deletes the EndpointSlices of the target written by the readvertiser whose address type is
+// not written anymore, e.g. the IPv6 slice after --ip-family changed from dual to ipv4
+func (c *AWSLBReadvertiserController) deleteStaleEndpointSlices(ctx context.Context, t *target) error {
+	selector := labels.SelectorFromSet(labels.Set{
+		discoveryv1.LabelServiceName: t.EndpointName,
+		discoveryv1.LabelManagedBy:   endpointSliceManagedBy,
+	})
+	slices, err := c.endpointSliceLister.EndpointSlices(t.EndpointNamespace).List(selector)
+	if err != nil {
+		return fmt.Errorf("could not list the endpointslices of %s/%s: %v", t.EndpointNamespace, t.EndpointName, err)
+	}
+
+	addressTypes := sets.NewString()
+	for _, addressType := range endpointSliceAddressTypes(c.ipFamily) {
+		addressTypes.Insert(string(addressType))
+	}
+	var errs []error
+	for _, slice := range slices {
+		if addressTypes.Has(string(slice.AddressType)) {
+			continue
+		}
+		// the precondition makes sure that a slice recreated in the meantime is not deleted
+		err := c.client.DiscoveryV1().EndpointSlices(slice.Namespace).Delete(ctx, slice.Name, metav1.DeleteOptions{Preconditions: metav1.NewUIDPreconditions(string(slice.UID))})
+		if errors.IsNotFound(err) {
+			continue
+		}
+		recordEndpointWrite(t, resourceEndpointSlice, operationDelete, err)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("could not delete the stale %s/%s endpointslice: %w", slice.Namespace, slice.Name, err))
+			continue
+		}
+		t.log.Infof("Deleted endpointslice %s/%s, its address type %s is not configured anymore", slice.Namespace, slice.Name, slice.AddressType)
+	}
+	return utilerrors.NewAggregate(errs)
+}
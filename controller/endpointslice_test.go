@@ -0,0 +1,112 @@
+// SPDX-FileCopyrightText: 2020 SAP SE or an SAP affiliate company and Gardener contributors
+//
+// SPDX-License-Identifier: Apache-2.0
+
+package controller
+
+import (
+	"context"
+	"time"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	corev1 "k8s.io/api/core/v1"
+	discoveryv1 "k8s.io/api/discovery/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	k8sinformers "k8s.io/client-go/informers"
+	"k8s.io/client-go/kubernetes/fake"
+	"k8s.io/client-go/tools/record"
+)
+
+var _ = Describe("#reconcileEndpointSlice", func() {
+	var (
+		fakeClient               = fake.NewSimpleClientset()
+		sharedK8sInformerFactory = k8sinformers.NewSharedInformerFactory(fakeClient, time.Duration(time.Hour))
+		endpointSliceInformer    = sharedK8sInformerFactory.Discovery().V1().EndpointSlices()
+
+		epName    = "kubernetes"
+		sliceName = "kubernetes-ipv4"
+	)
+
+	It("should create the endpointslice and update it when the ips change", func() {
+		controller := newTestController(fakeClient, sharedK8sInformerFactory, NewSystemResolver(0), record.NewFakeRecorder(100), []Target{
+			{Hostnames: []string{"elbHostname"}, EndpointNamespace: metav1.NamespaceDefault, EndpointName: epName, Ports: DefaultEndpointPorts(), RefreshPeriod: time.Second},
+		}, Options{EndpointAPI: EndpointAPIEndpointSlice})
+		target := firstTarget(controller)
+
+		Expect(controller.reconcileEndpointSlice(context.TODO(), target, discoveryv1.AddressTypeIPv4, readyAddresses([]string{"4.3.2.1", "1.2.3.4"}))).To(Succeed())
+
+		created, err := fakeClient.DiscoveryV1().EndpointSlices(metav1.NamespaceDefault).Get(context.TODO(), sliceName, metav1.GetOptions{})
+		Expect(err).To(BeNil())
+		Expect(created.Labels).To(HaveKeyWithValue(discoveryv1.LabelServiceName, epName))
+		Expect(created.Labels).To(HaveKeyWithValue(discoveryv1.LabelManagedBy, endpointSliceManagedBy))
+		Expect(created.AddressType).To(Equal(discoveryv1.AddressTypeIPv4))
+		Expect(fetchEndpointSliceIPs(created)).To(Equal([]string{"1.2.3.4", "4.3.2.1"}))
+		Expect(created.Ports).To(HaveLen(1))
+		Expect(*created.Ports[0].Port).To(Equal(int32(443)))
+
+		Expect(endpointSliceInformer.Informer().GetIndexer().Add(created)).To(Succeed())
+		Expect(controller.reconcileEndpointSlice(context.TODO(), target, discoveryv1.AddressTypeIPv4, readyAddresses([]string{"5.6.7.8"}))).To(Succeed())
+
+		updated, err := fakeClient.DiscoveryV1().EndpointSlices(metav1.NamespaceDefault).Get(context.TODO(), sliceName, metav1.GetOptions{})
+		Expect(err).To(BeNil())
+		Expect(fetchEndpointSliceIPs(updated)).To(Equal([]string{"5.6.7.8"}))
+	})
+
+	It("should write one endpointslice per address type", func() {
+		controller := newTestController(fakeClient, sharedK8sInformerFactory, NewSystemResolver(0), record.NewFakeRecorder(100), []Target{
+			{Hostnames: []string{"elbHostname"}, EndpointNamespace: metav1.NamespaceDefault, EndpointName: "dualstack", Ports: DefaultEndpointPorts(), RefreshPeriod: time.Second},
+		}, Options{EndpointAPI: EndpointAPIEndpointSlice, IPFamily: IPFamilyDual})
+		target := firstTarget(controller)
+		ipv4, ipv6 := partitionIPFamilies([]string{"1.2.3.4", "2001:db8::1", "not-an-ip"}, IPFamilyDual)
+		Expect(ipv4).To(Equal([]string{"1.2.3.4"}))
+		Expect(ipv6).To(Equal([]string{"2001:db8::1"}))
+
+		Expect(controller.reconcileEndpointSlice(context.TODO(), target, discoveryv1.AddressTypeIPv4, readyAddresses(ipv4))).To(Succeed())
+		Expect(controller.reconcileEndpointSlice(context.TODO(), target, discoveryv1.AddressTypeIPv6, readyAddresses(ipv6))).To(Succeed())
+
+		slice, err := fakeClient.DiscoveryV1().EndpointSlices(metav1.NamespaceDefault).Get(context.TODO(), "dualstack-ipv6", metav1.GetOptions{})
+		Expect(err).To(BeNil())
+		Expect(slice.AddressType).To(Equal(discoveryv1.AddressTypeIPv6))
+		Expect(fetchEndpointSliceIPs(slice)).To(Equal([]string{"2001:db8::1"}))
+	})
+
+	It("should delete the managed endpointslice of an address type which is not configured anymore", func() {
+		controller := newTestController(fakeClient, sharedK8sInformerFactory, NewSystemResolver(0), record.NewFakeRecorder(100), []Target{
+			{Hostnames: []string{"elbHostname"}, EndpointNamespace: metav1.NamespaceDefault, EndpointName: "formerdualstack", Ports: DefaultEndpointPorts(), RefreshPeriod: time.Second},
+		}, Options{EndpointAPI: EndpointAPIEndpointSlice, IPFamily: IPFamilyIPv4})
+		target := firstTarget(controller)
+
+		stale := createEndpointSliceObjectFromRecords(metav1.NamespaceDefault, "formerdualstack", discoveryv1.AddressTypeIPv6, readyAddresses([]string{"2001:db8::1"}), DefaultEndpointPorts())
+		foreign := stale.DeepCopy()
+		foreign.Name = "formerdualstack-foreign"
+		foreign.Labels[discoveryv1.LabelManagedBy] = "endpointslice-controller.k8s.io"
+		for _, slice := range []*discoveryv1.EndpointSlice{stale, foreign} {
+			_, err := fakeClient.DiscoveryV1().EndpointSlices(metav1.NamespaceDefault).Create(context.TODO(), slice, metav1.CreateOptions{})
+			Expect(err).To(BeNil())
+			Expect(endpointSliceInformer.Informer().GetIndexer().Add(slice)).To(Succeed())
+		}
+
+		Expect(controller.writeAddresses(context.TODO(), target, readyAddresses([]string{"1.2.3.4"}))).To(Succeed())
+
+		_, err := fakeClient.DiscoveryV1().EndpointSlices(metav1.NamespaceDefault).Get(context.TODO(), "formerdualstack-ipv6", metav1.GetOptions{})
+		Expect(errors.IsNotFound(err)).To(BeTrue())
+		_, err = fakeClient.DiscoveryV1().EndpointSlices(metav1.NamespaceDefault).Get(context.TODO(), "formerdualstack-ipv4", metav1.GetOptions{})
+		Expect(err).To(BeNil())
+		_, err = fakeClient.DiscoveryV1().EndpointSlices(metav1.NamespaceDefault).Get(context.TODO(), "formerdualstack-foreign", metav1.GetOptions{})
+		Expect(err).To(BeNil())
+	})
+
+	It("should detect an up to date endpointslice regardless of the order of the ips", func() {
+		current := createEndpointSliceObjectFromRecords(metav1.NamespaceDefault, epName, discoveryv1.AddressTypeIPv4, readyAddresses([]string{"1.2.3.4", "4.3.2.1"}), DefaultEndpointPorts())
+		desired := createEndpointSliceObjectFromRecords(metav1.NamespaceDefault, epName, discoveryv1.AddressTypeIPv4, readyAddresses([]string{"4.3.2.1", "1.2.3.4"}), DefaultEndpointPorts())
+		Expect(checkEndpointSliceIsStillValid(current, desired)).To(BeTrue())
+
+		desired = createEndpointSliceObjectFromRecords(metav1.NamespaceDefault, epName, discoveryv1.AddressTypeIPv4, readyAddresses([]string{"4.3.2.1"}), DefaultEndpointPorts())
+		Expect(checkEndpointSliceIsStillValid(current, desired)).To(BeFalse())
+
+		desired = createEndpointSliceObjectFromRecords(metav1.NamespaceDefault, epName, discoveryv1.AddressTypeIPv4, readyAddresses([]string{"1.2.3.4", "4.3.2.1"}), []corev1.EndpointPort{{Name: "https", Port: 8443, Protocol: corev1.ProtocolTCP}})
+		Expect(checkEndpointSliceIsStillValid(current, desired)).To(BeFalse())
+	})
+})
@@ -0,0 +1,65 @@
+// SPDX-FileCopyrightText: 2020 SAP SE or an SAP affiliate company and Gardener contributors
+//
+// SPDX-License-Identifier: Apache-2.0
+
+package controller
+
+import (
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+)
+
+// Reasons of the events emitted for the managed Endpoints object
+const (
+	// EventReasonEndpointCreated is emitted when a missing endpoint object was created
+	EventReasonEndpointCreated = "EndpointCreated"
+	// EventReasonAddressesUpdated is emitted when the advertised addresses or ports of an endpoint object changed
+	EventReasonAddressesUpdated = "AddressesUpdated"
+	// EventReasonDNSLookupFailed is emitted when a hostname of the target could not be resolved
+	EventReasonDNSLookupFailed = "DNSLookupFailed"
+	// EventReasonEmptyResolution is emitted when the hostnames resolved to no address of the configured IP family
+	EventReasonEmptyResolution = "EmptyResolution"
+	// EventReasonAddressesFiltered is emitted when resolved addresses are dropped because they are invalid,
+	// special-purpose addresses or rejected by the configured CIDRs
+	EventReasonAddressesFiltered = "AddressesFiltered"
+	// EventReasonOutsideIPRanges is emitted when resolved IPs are dropped because they are outside of the AWS IP ranges
+	EventReasonOutsideIPRanges = "OutsideIPRanges"
+	// EventReasonIdentityVerificationFailed is emitted when a resolved IP did not present the expected TLS identity
+	EventReasonIdentityVerificationFailed = "IdentityVerificationFailed"
+	// EventReasonLastKnownGoodExpired is emitted when the resolution failed and the last known good addresses are
+	// too old to be restored
+	EventReasonLastKnownGoodExpired = "LastKnownGoodExpired"
+	// EventReasonEndpointFight is emitted when the managed endpoint is repeatedly overwritten by another writer
+	EventReasonEndpointFight = "EndpointFight"
+	// EventReasonEndpointReconcilerConflict is emitted at start-up when the kube-apiservers may maintain the managed
+	// default/kubernetes endpoint
+	EventReasonEndpointReconcilerConflict = "EndpointReconcilerConflict"
+	// EventReasonCreateFailed is emitted when a missing endpoint object could not be created
+	EventReasonCreateFailed = "CreateFailed"
+	// EventReasonPatchFailed is emitted when an endpoint object could not be patched or updated
+	EventReasonPatchFailed = "PatchFailed"
+	// EventReasonApplyConflict is emitted when an endpoint object could not be applied because other field managers
+	// own its fields
+	EventReasonApplyConflict = "ApplyConflict"
+)
+
+// eventObject returns the object the events of the target are recorded for. This is the managed Endpoints object
+// if it is known, so that the events are shown by `kubectl describe endpoints`, or a reference to it otherwise.
+func (c *AWSLBReadvertiserController) eventObject(t *target) runtime.Object {
+	if c.endpointsLister != nil {
+		if endpoint, err := c.endpointsLister.Endpoints(t.EndpointNamespace).Get(t.EndpointName); err == nil {
+			return endpoint
+		}
+	}
+	return &corev1.ObjectReference{
+		Kind:       "Endpoints",
+		APIVersion: corev1.SchemeGroupVersion.String(),
+		Namespace:  t.EndpointNamespace,
+		Name:       t.EndpointName,
+	}
+}
+
+// recordEvent emits an event of the given type for the managed Endpoints object of the target
+func (c *AWSLBReadvertiserController) recordEvent(t *target, eventType, reason, messageFmt string, args ...interface{}) {
+	c.recorder.Eventf(c.eventObject(t), eventType, reason, messageFmt, args...)
+}
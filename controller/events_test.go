@@ -0,0 +1,115 @@
+// SPDX-FileCopyrightText: 2020 SAP SE or an SAP affiliate company and Gardener contributors
+//
+// SPDX-License-Identifier: Apache-2.0
+
+package controller
+
+import (
+	"context"
+	"time"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+	k8sinformers "k8s.io/client-go/informers"
+	"k8s.io/client-go/kubernetes/fake"
+	"k8s.io/client-go/tools/record"
+)
+
+// objectRecorder records the UIDs of the objects events are emitted for
+type objectRecorder struct {
+	uids []types.UID
+}
+
+func (r *objectRecorder) Event(object runtime.Object, _, _, _ string) {
+	accessor, err := meta.Accessor(object)
+	Expect(err).To(BeNil())
+	r.uids = append(r.uids, accessor.GetUID())
+}
+
+func (r *objectRecorder) Eventf(object runtime.Object, eventType, reason, messageFmt string, _ ...interface{}) {
+	r.Event(object, eventType, reason, messageFmt)
+}
+
+func (r *objectRecorder) AnnotatedEventf(object runtime.Object, _ map[string]string, eventType, reason, messageFmt string, _ ...interface{}) {
+	r.Event(object, eventType, reason, messageFmt)
+}
+
+var _ = Describe("#events", func() {
+	var (
+		fakeClient               *fake.Clientset
+		sharedK8sInformerFactory k8sinformers.SharedInformerFactory
+		recorder                 *record.FakeRecorder
+		resolver                 staticResolver
+		controller               *AWSLBReadvertiserController
+	)
+
+	BeforeEach(func() {
+		fakeClient = fake.NewSimpleClientset()
+		sharedK8sInformerFactory = k8sinformers.NewSharedInformerFactory(fakeClient, time.Hour)
+		recorder = record.NewFakeRecorder(10)
+		resolver = staticResolver{}
+		controller = newTestController(fakeClient, sharedK8sInformerFactory, resolver, recorder, nil, Options{})
+	})
+
+	AfterEach(func() {
+		controller.queue.ShutDown()
+	})
+
+	// syncEndpoint copies the endpoint written by the fake client into the informer cache read by the controller
+	syncEndpoint := func() {
+		endpoint, err := fakeClient.CoreV1().Endpoints(metav1.NamespaceDefault).Get(context.TODO(), "kubernetes", metav1.GetOptions{})
+		Expect(err).To(BeNil())
+		Expect(sharedK8sInformerFactory.Core().V1().Endpoints().Informer().GetStore().Add(endpoint)).To(Succeed())
+	}
+
+	It("should emit normal events when the endpoint is created and its addresses change", func() {
+		resolver["elb.example.com."] = []string{"1.2.3.4"}
+		Expect(controller.reconcileTarget(context.TODO(), firstTarget(controller))).To(Succeed())
+		Expect(recorder.Events).To(Receive(Equal(`Normal EndpointCreated Created endpoint with IPs ["1.2.3.4"]`)))
+
+		syncEndpoint()
+		resolver["elb.example.com."] = []string{"5.6.7.8"}
+		Expect(controller.reconcileTarget(context.TODO(), firstTarget(controller))).To(Succeed())
+		Expect(recorder.Events).To(Receive(Equal(`Normal AddressesUpdated Advertised IPs changed from ["1.2.3.4"] to ["5.6.7.8"]`)))
+		Expect(recorder.Events).NotTo(Receive())
+	})
+
+	It("should emit warning events when the lookup fails or resolves to no address", func() {
+		Expect(controller.reconcileTarget(context.TODO(), firstTarget(controller))).NotTo(Succeed())
+		Expect(recorder.Events).To(Receive(HavePrefix("Warning DNSLookupFailed ")))
+
+		resolver["elb.example.com."] = []string{"2001:db8::1"}
+		Expect(controller.reconcileTarget(context.TODO(), firstTarget(controller))).NotTo(Succeed())
+		Expect(recorder.Events).To(Receive(HavePrefix("Warning EmptyResolution ")))
+	})
+
+	It("should emit all events of a target for the same object", func() {
+		recorder := &objectRecorder{}
+		controller.queue.ShutDown()
+		controller = newTestController(fakeClient, sharedK8sInformerFactory, resolver, recorder, nil, Options{})
+
+		endpoint := &corev1.Endpoints{
+			ObjectMeta: metav1.ObjectMeta{Name: "kubernetes", Namespace: metav1.NamespaceDefault, UID: "endpoint-uid"},
+			Subsets:    []corev1.EndpointSubset{{Addresses: []corev1.EndpointAddress{{IP: "1.2.3.4"}}, Ports: DefaultEndpointPorts()}},
+		}
+		_, err := fakeClient.CoreV1().Endpoints(metav1.NamespaceDefault).Create(context.TODO(), endpoint, metav1.CreateOptions{})
+		Expect(err).To(BeNil())
+		Expect(sharedK8sInformerFactory.Core().V1().Endpoints().Informer().GetStore().Add(endpoint)).To(Succeed())
+
+		// the address change is recorded by the write path, the failed lookup by the resolution path
+		resolver["elb.example.com."] = []string{"5.6.7.8"}
+		Expect(controller.reconcileTarget(context.TODO(), firstTarget(controller))).To(Succeed())
+		delete(resolver, "elb.example.com.")
+		Expect(controller.reconcileTarget(context.TODO(), firstTarget(controller))).NotTo(Succeed())
+
+		Expect(len(recorder.uids)).To(BeNumerically(">=", 2))
+		for _, uid := range recorder.uids {
+			Expect(uid).To(Equal(types.UID("endpoint-uid")))
+		}
+	})
+})
@@ -0,0 +1,347 @@
+// SPDX-FileCopyrightText: 2020 SAP SE or an SAP affiliate company and Gardener contributors
+//
+// SPDX-License-Identifier: Apache-2.0
+
+package controller
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	discoveryv1 "k8s.io/api/discovery/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	utilerrors "k8s.io/apimachinery/pkg/util/errors"
+	"k8s.io/apimachinery/pkg/util/sets"
+)
+
+// lastKnownGoodAnnotation persists the last successfully resolved addresses together with the time they were resolved
+// on the managed objects, so that they can be restored when DNS is unavailable after a restart
+const lastKnownGoodAnnotation = "aws-lb-readvertiser.gardener.cloud/last-known-good"
+
+// minLastKnownGoodPersistInterval bounds how often the resolution time in the annotation is refreshed
+const minLastKnownGoodPersistInterval = time.Minute
+
+// FallbackOptions configure the fallback to the last known good addresses when DNS fails or returns nothing
+type FallbackOptions struct {
+	// MaxStaleness is the time the last known good addresses are served after they were resolved for the last time,
+	// zero disables the fallback
+	MaxStaleness time.Duration
+	// ConfigMap is the <namespace>/<name> of a ConfigMap the last known good addresses of all targets are persisted in
+	// as well, so that they can be restored after a restart even if the managed objects were deleted. Empty only
+	// persists them on the managed objects.
+	ConfigMap string
+}
+
+// Validate checks that the fallback options are consistent
+func (o FallbackOptions) Validate() error {
+	if o.MaxStaleness < 0 {
+		return fmt.Errorf("last known good maximum staleness must not be negative")
+	}
+	if len(o.ConfigMap) != 0 {
+		if parts := strings.Split(o.ConfigMap, "/"); len(parts) != 2 || len(parts[0]) == 0 || len(parts[1]) == 0 {
+			return fmt.Errorf("last known good ConfigMap must be given as <namespace>/<name>")
+		}
+	}
+	return nil
+}
+
+// persistInterval is the time after which the persisted resolution time of unchanged addresses is refreshed. Refreshing
+// it with every lookup would rewrite the managed objects at every refresh, hence it is only refreshed once it is older
+// than half of the maximum staleness. After a restart the fallback may hence end up to that interval early.
+func (o FallbackOptions) persistInterval() time.Duration {
+	interval := o.MaxStaleness / 2
+	if interval < minLastKnownGoodPersistInterval {
+		return minLastKnownGoodPersistInterval
+	}
+	return interval
+}
+
+// lastKnownGood are the addresses advertised after the last successful lookup
+type lastKnownGood struct {
+	// Addresses were advertised as ready. Annotations written before the not ready addresses were persisted
+	// separately carry all addresses here.
+	Addresses []string `json:"addresses"`
+	// NotReadyAddresses were advertised as not ready, e.g. because they failed their probe or are retained
+	NotReadyAddresses []string `json:"notReadyAddresses,omitempty"`
+	// ResolvedAt is the persisted resolution time, it is only refreshed once per persist interval
+	ResolvedAt time.Time `json:"resolvedAt"`
+
+	// lastResolved is the time the addresses were resolved for the last time, it is not persisted
+	lastResolved time.Time
+}
+
+// annotation returns the value of the last known good annotation, empty if there are no last known good addresses
+func (l *lastKnownGood) annotation() string {
+	if l == nil || len(l.all()) == 0 {
+		return ""
+	}
+	// the addresses are sorted and the time has a fixed format, hence the value is stable
+	value, _ := json.Marshal(lastKnownGood{
+		Addresses:         sets.NewString(l.Addresses...).List(),
+		NotReadyAddresses: sets.NewString(l.NotReadyAddresses...).List(),
+		ResolvedAt:        l.ResolvedAt.UTC(),
+	})
+	return string(value)
+}
+
+// all returns the ready and not ready last known good addresses
+func (l *lastKnownGood) all() []string {
+	return append(append([]string(nil), l.Addresses...), l.NotReadyAddresses...)
+}
+
+// resolved returns the time the addresses were resolved for the last time
+func (l *lastKnownGood) resolved() time.Time {
+	if l.lastResolved.After(l.ResolvedAt) {
+		return l.lastResolved
+	}
+	return l.ResolvedAt
+}
+
+// sameAddresses returns whether both last known good addresses advertise the same addresses with the same readiness
+func (l *lastKnownGood) sameAddresses(other *lastKnownGood) bool {
+	return sets.NewString(l.Addresses...).Equal(sets.NewString(other.Addresses...)) &&
+		sets.NewString(l.NotReadyAddresses...).Equal(sets.NewString(other.NotReadyAddresses...))
+}
+
+// parseLastKnownGoodAnnotation returns the last known good addresses persisted in the given annotations
+func parseLastKnownGoodAnnotation(annotations map[string]string) (*lastKnownGood, error) {
+	value, ok := annotations[lastKnownGoodAnnotation]
+	if !ok {
+		return nil, nil
+	}
+	l := &lastKnownGood{}
+	if err := json.Unmarshal([]byte(value), l); err != nil {
+		return nil, fmt.Errorf("invalid %s annotation: %v", lastKnownGoodAnnotation, err)
+	}
+	return l, nil
+}
+
+// lastKnownGoodConfigMapKey returns the key the last known good addresses of the target are persisted at in the
+// ConfigMap, e.g. default.kubernetes. Namespaces cannot contain dots, hence the key is unique.
+func lastKnownGoodConfigMapKey(t *target) string {
+	return t.EndpointNamespace + "." + t.EndpointName
+}
+
+// readLastKnownGoodConfigMap returns the last known good addresses of the target persisted in the ConfigMap, nil if
+// there are none
+func (c *AWSLBReadvertiserController) readLastKnownGoodConfigMap(ctx context.Context, t *target) (*lastKnownGood, error) {
+	namespace, name, _ := strings.Cut(c.options.Fallback.ConfigMap, "/")
+	configMap, err := c.client.CoreV1().ConfigMaps(namespace).Get(ctx, name, metav1.GetOptions{})
+	if errors.IsNotFound(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("could not read ConfigMap %s: %v", c.options.Fallback.ConfigMap, err)
+	}
+	value, ok := configMap.Data[lastKnownGoodConfigMapKey(t)]
+	if !ok {
+		return nil, nil
+	}
+	l := &lastKnownGood{}
+	if err := json.Unmarshal([]byte(value), l); err != nil {
+		return nil, fmt.Errorf("invalid key %s of ConfigMap %s: %v", lastKnownGoodConfigMapKey(t), c.options.Fallback.ConfigMap, err)
+	}
+	t.persistedLastKnownGood = value
+	return l, nil
+}
+
+// persistLastKnownGood stores the last known good addresses of the target in the ConfigMap, which is created if it is
+// missing. Only changes are written, and failures are only logged as the addresses are still persisted on the managed
+// objects; they are written again with the next reconcile.
+func (c *AWSLBReadvertiserController) persistLastKnownGood(ctx context.Context, t *target, l *lastKnownGood) {
+	value := l.annotation()
+	if len(c.options.Fallback.ConfigMap) == 0 || len(value) == 0 || value == t.persistedLastKnownGood {
+		return
+	}
+
+	namespace, name, _ := strings.Cut(c.options.Fallback.ConfigMap, "/")
+	key := lastKnownGoodConfigMapKey(t)
+	// a merge patch only replaces the key of the target, so that the workers of the targets do not conflict
+	patch, err := json.Marshal(map[string]interface{}{"data": map[string]string{key: value}})
+	if err != nil {
+		t.log.Warnf("Could not persist the last known good IPs in ConfigMap %s: %v", c.options.Fallback.ConfigMap, err)
+		return
+	}
+	configMaps := c.client.CoreV1().ConfigMaps(namespace)
+	_, err = configMaps.Patch(ctx, name, types.MergePatchType, patch, metav1.PatchOptions{FieldManager: c.fieldManager()})
+	if errors.IsNotFound(err) {
+		_, err = configMaps.Create(ctx, &corev1.ConfigMap{
+			ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: namespace},
+			Data:       map[string]string{key: value},
+		}, metav1.CreateOptions{FieldManager: c.fieldManager()})
+		if errors.IsAlreadyExists(err) {
+			_, err = configMaps.Patch(ctx, name, types.MergePatchType, patch, metav1.PatchOptions{FieldManager: c.fieldManager()})
+		}
+	}
+	if err != nil {
+		t.log.Warnf("Could not persist the last known good IPs in ConfigMap %s: %v", c.options.Fallback.ConfigMap, err)
+		return
+	}
+	t.persistedLastKnownGood = value
+}
+
+// newLastKnownGood returns the last known good addresses to persist for the given addresses of the target resolved at
+// now, nil if neither the fallback nor the removal grace period is enabled. The removal grace period restores the
+// addresses advertised before a restart from them. The persisted resolution time of unchanged addresses is kept until
+// the persist interval elapsed, so that the managed objects are not rewritten at every lookup.
+func (c *AWSLBReadvertiserController) newLastKnownGood(t *target, addresses advertisedAddresses, now time.Time) *lastKnownGood {
+	if c.options.Fallback.MaxStaleness == 0 && c.options.GracePeriod.RemovalGracePeriod == 0 {
+		return nil
+	}
+
+	l := &lastKnownGood{
+		Addresses:         sets.NewString(addresses.ready...).List(),
+		NotReadyAddresses: sets.NewString(addresses.notReady...).List(),
+		ResolvedAt:        now.Truncate(time.Second),
+		lastResolved:      now,
+	}
+	if previous := t.lastKnownGood; previous != nil && previous.sameAddresses(l) {
+		// without fallback the resolution time is never read, hence it is not refreshed at all
+		if c.options.Fallback.MaxStaleness == 0 || now.Sub(previous.ResolvedAt) < c.options.Fallback.persistInterval() {
+			l.ResolvedAt = previous.ResolvedAt
+		}
+	}
+	return l
+}
+
+// loadLastKnownGood restores the last known good addresses from the managed objects after a restart, or from the
+// ConfigMap if none of the managed objects carries them, e.g. because they were deleted
+func (c *AWSLBReadvertiserController) loadLastKnownGood(ctx context.Context, t *target) {
+	t.lastKnownGoodLoaded = true
+
+	var annotations []map[string]string
+	if c.manageEndpoints() {
+		if endpoint, err := c.endpointsLister.Endpoints(t.EndpointNamespace).Get(t.EndpointName); err == nil {
+			annotations = append(annotations, endpoint.Annotations)
+		}
+	}
+	if c.manageEndpointSlices() {
+		for _, addressType := range []discoveryv1.AddressType{discoveryv1.AddressTypeIPv4, discoveryv1.AddressTypeIPv6} {
+			if slice, err := c.endpointSliceLister.EndpointSlices(t.EndpointNamespace).Get(endpointSliceName(t.EndpointName, addressType)); err == nil {
+				annotations = append(annotations, slice.Annotations)
+			}
+		}
+	}
+
+	// EndpointSlices only carry the addresses of their family, hence the addresses of all objects are merged
+	var restored *lastKnownGood
+	for _, a := range annotations {
+		l, err := parseLastKnownGoodAnnotation(a)
+		if err != nil {
+			t.log.Warnf("Could not restore the last known good addresses: %v", err)
+			continue
+		}
+		if l == nil {
+			continue
+		}
+		if restored == nil {
+			restored = &lastKnownGood{ResolvedAt: l.ResolvedAt}
+		}
+		restored.Addresses = sets.NewString(restored.Addresses...).Insert(l.Addresses...).List()
+		restored.NotReadyAddresses = sets.NewString(restored.NotReadyAddresses...).Insert(l.NotReadyAddresses...).List()
+		if l.ResolvedAt.After(restored.ResolvedAt) {
+			restored.ResolvedAt = l.ResolvedAt
+		}
+	}
+	if restored == nil && len(c.options.Fallback.ConfigMap) != 0 {
+		l, err := c.readLastKnownGoodConfigMap(ctx, t)
+		if err != nil {
+			t.log.Warnf("Could not restore the last known good addresses: %v", err)
+		}
+		restored = l
+	}
+	if restored != nil {
+		t.log.Infof("Restored last known good IPs %q and not ready IPs %q resolved at %s", restored.Addresses, restored.NotReadyAddresses, restored.ResolvedAt.Format(time.RFC3339))
+		t.lastKnownGood = restored
+	}
+}
+
+// fallBackToLastKnownGood advertises the last known good addresses of the target after its resolution failed with the
+// given error, as long as they are not older than the maximum staleness. The static addresses are advertised in any
+// case, if there are no last known good addresses to restore they are added to the advertised ones. The resolution
+// error is always returned, so that the target is retried and counted as failing.
+func (c *AWSLBReadvertiserController) fallBackToLastKnownGood(ctx context.Context, t *target, cause error) error {
+	now := time.Now()
+	addresses, ok := c.restoreLastKnownGood(ctx, t, cause, now)
+	if !ok {
+		addresses = c.cachedAddresses(t)
+		if !c.missesStaticAddresses(t, addresses) {
+			return cause
+		}
+		t.log.Warnf("Resolution failed, adding the static IPs to the advertised IPs %q: %v", addresses.all(), cause)
+		if !t.lastKnownGoodLoaded && (c.options.Fallback.MaxStaleness != 0 || c.options.GracePeriod.RemovalGracePeriod != 0) {
+			c.loadLastKnownGood(ctx, t)
+		}
+		addresses.lastKnownGood = t.lastKnownGood
+		addresses = c.retainDuringFallback(t, addresses, now)
+	}
+
+	addresses = c.addStaticAddresses(t, addresses)
+	if err := c.writeAddresses(ctx, t, addresses); err != nil {
+		return utilerrors.NewAggregate([]error{cause, err})
+	}
+	return cause
+}
+
+// restoreLastKnownGood returns the last known good addresses of the target to advertise after its resolution failed
+// with the given error, or false if there are none or they are older than the maximum staleness
+func (c *AWSLBReadvertiserController) restoreLastKnownGood(ctx context.Context, t *target, cause error, now time.Time) (advertisedAddresses, bool) {
+	maxStaleness := c.options.Fallback.MaxStaleness
+	if maxStaleness == 0 {
+		return advertisedAddresses{}, false
+	}
+	if !t.lastKnownGoodLoaded {
+		c.loadLastKnownGood(ctx, t)
+	}
+	if t.lastKnownGood == nil {
+		t.log.Warn("No last known good addresses to fall back to")
+		return advertisedAddresses{}, false
+	}
+
+	l := t.lastKnownGood
+	age := now.Sub(l.resolved())
+	lastKnownGoodAge.WithLabelValues(t.Key()).Set(age.Seconds())
+	if age > maxStaleness {
+		c.recordEvent(t, corev1.EventTypeWarning, EventReasonLastKnownGoodExpired, "Last known good IPs %q resolved at %s are older than %s, not restoring them", l.all(), l.resolved().Format(time.RFC3339), maxStaleness)
+		return advertisedAddresses{}, false
+	}
+
+	t.log.Warnf("Resolution failed, serving the last known good IPs %q and not ready IPs %q resolved at %s: %v", l.Addresses, l.NotReadyAddresses, l.resolved().Format(time.RFC3339), cause)
+	return c.retainDuringFallback(t, advertisedAddresses{ready: l.Addresses, notReady: l.NotReadyAddresses, lastKnownGood: l}, now), true
+}
+
+// retainDuringFallback carries the addresses retained by the removal grace period through a fallback to the last
+// known good addresses, so that their grace period is still persisted. Retained addresses whose grace period ended
+// in the meantime are not restored.
+func (c *AWSLBReadvertiserController) retainDuringFallback(t *target, addresses advertisedAddresses, now time.Time) advertisedAddresses {
+	if c.options.GracePeriod.RemovalGracePeriod == 0 {
+		return addresses
+	}
+	if !t.grace.loaded {
+		c.loadRetainedAddresses(t)
+	}
+
+	expired := sets.NewString()
+	for ip, expiry := range t.grace.retained {
+		if !now.Before(expiry) {
+			expired.Insert(ip)
+			continue
+		}
+		if addresses.retained == nil {
+			addresses.retained = map[string]time.Time{}
+		}
+		addresses.retained[ip] = expiry
+	}
+	if expired.Len() != 0 {
+		t.log.Infof("Grace period of the last known good IPs %q ended, not restoring them", expired.List())
+		addresses.ready = sets.NewString(addresses.ready...).Difference(expired).List()
+		addresses.notReady = sets.NewString(addresses.notReady...).Difference(expired).List()
+	}
+	return addresses
+}
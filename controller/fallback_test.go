@@ -0,0 +1,174 @@
+// SPDX-FileCopyrightText: 2020 SAP SE or an SAP affiliate company and Gardener contributors
+//
+// SPDX-License-Identifier: Apache-2.0
+
+package controller
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	k8sinformers "k8s.io/client-go/informers"
+	"k8s.io/client-go/kubernetes/fake"
+	"k8s.io/client-go/tools/record"
+)
+
+var _ = Describe("#fallBackToLastKnownGood", func() {
+	var (
+		fakeClient               *fake.Clientset
+		sharedK8sInformerFactory k8sinformers.SharedInformerFactory
+		recorder                 *record.FakeRecorder
+		resolver                 staticResolver
+		controller               *AWSLBReadvertiserController
+	)
+
+	newController := func() {
+		recorder = record.NewFakeRecorder(100)
+		controller = newTestController(fakeClient, sharedK8sInformerFactory, resolver, recorder, nil, Options{Fallback: FallbackOptions{MaxStaleness: time.Hour}})
+	}
+
+	BeforeEach(func() {
+		fakeClient = fake.NewSimpleClientset()
+		sharedK8sInformerFactory = k8sinformers.NewSharedInformerFactory(fakeClient, time.Hour)
+		resolver = staticResolver{}
+		newController()
+	})
+
+	AfterEach(func() {
+		controller.queue.ShutDown()
+	})
+
+	getEndpoint := func() *corev1.Endpoints {
+		endpoint, err := fakeClient.CoreV1().Endpoints(metav1.NamespaceDefault).Get(context.TODO(), "kubernetes", metav1.GetOptions{})
+		Expect(err).To(BeNil())
+		return endpoint
+	}
+
+	// createEndpoint creates an endpoint without subsets carrying the given last known good annotation
+	createEndpoint := func(addresses []string, resolvedAt time.Time) {
+		endpoint := &corev1.Endpoints{ObjectMeta: metav1.ObjectMeta{
+			Name:        "kubernetes",
+			Namespace:   metav1.NamespaceDefault,
+			Annotations: map[string]string{lastKnownGoodAnnotation: (&lastKnownGood{Addresses: addresses, ResolvedAt: resolvedAt}).annotation()},
+		}}
+		_, err := fakeClient.CoreV1().Endpoints(metav1.NamespaceDefault).Create(context.TODO(), endpoint, metav1.CreateOptions{})
+		Expect(err).To(BeNil())
+		Expect(sharedK8sInformerFactory.Core().V1().Endpoints().Informer().GetStore().Add(endpoint)).To(Succeed())
+	}
+
+	It("should persist the last known good addresses and recreate the endpoint from them while DNS fails", func() {
+		resolver["elb.example.com."] = []string{"1.1.1.1", "2.2.2.2"}
+		Expect(controller.reconcileTarget(context.TODO(), firstTarget(controller))).To(Succeed())
+		restored, err := parseLastKnownGoodAnnotation(getEndpoint().Annotations)
+		Expect(err).To(BeNil())
+		Expect(restored.Addresses).To(Equal([]string{"1.1.1.1", "2.2.2.2"}))
+		Expect(time.Since(restored.ResolvedAt)).To(BeNumerically("<", time.Minute))
+
+		Expect(fakeClient.CoreV1().Endpoints(metav1.NamespaceDefault).Delete(context.TODO(), "kubernetes", metav1.DeleteOptions{})).To(Succeed())
+		delete(resolver, "elb.example.com.")
+		Expect(controller.reconcileTarget(context.TODO(), firstTarget(controller))).NotTo(Succeed())
+		Expect(getEndpoint().Subsets[0].Addresses).To(Equal([]corev1.EndpointAddress{{IP: "1.1.1.1"}, {IP: "2.2.2.2"}}))
+	})
+
+	It("should restore the last known good addresses after a restart with DNS unavailable", func() {
+		createEndpoint([]string{"1.1.1.1"}, time.Now().Add(-time.Minute))
+		newController()
+
+		Expect(controller.reconcileTarget(context.TODO(), firstTarget(controller))).NotTo(Succeed())
+		Expect(getEndpoint().Subsets[0].Addresses).To(Equal([]corev1.EndpointAddress{{IP: "1.1.1.1"}}))
+	})
+
+	It("should restore the deleted endpoint from the ConfigMap after a restart with DNS unavailable", func() {
+		options := Options{Fallback: FallbackOptions{MaxStaleness: time.Hour, ConfigMap: "kube-system/last-known-good"}}
+		controller.queue.ShutDown()
+		controller = newTestController(fakeClient, sharedK8sInformerFactory, resolver, recorder, nil, options)
+		resolver["elb.example.com."] = []string{"1.1.1.1", "2.2.2.2"}
+		Expect(controller.reconcileTarget(context.TODO(), firstTarget(controller))).To(Succeed())
+
+		configMap, err := fakeClient.CoreV1().ConfigMaps(metav1.NamespaceSystem).Get(context.TODO(), "last-known-good", metav1.GetOptions{})
+		Expect(err).To(BeNil())
+		Expect(configMap.Data).To(HaveKeyWithValue("default.kubernetes", getEndpoint().Annotations[lastKnownGoodAnnotation]))
+
+		Expect(fakeClient.CoreV1().Endpoints(metav1.NamespaceDefault).Delete(context.TODO(), "kubernetes", metav1.DeleteOptions{})).To(Succeed())
+		delete(resolver, "elb.example.com.")
+		controller.queue.ShutDown()
+		controller = newTestController(fakeClient, sharedK8sInformerFactory, resolver, recorder, nil, options)
+
+		Expect(controller.reconcileTarget(context.TODO(), firstTarget(controller))).NotTo(Succeed())
+		Expect(getEndpoint().Subsets[0].Addresses).To(Equal([]corev1.EndpointAddress{{IP: "1.1.1.1"}, {IP: "2.2.2.2"}}))
+	})
+
+	It("should not restore last known good addresses older than the maximum staleness", func() {
+		resolvedAt := time.Now().Add(-2 * time.Hour).Truncate(time.Second)
+		createEndpoint([]string{"1.1.1.1"}, resolvedAt)
+		newController()
+
+		Expect(controller.reconcileTarget(context.TODO(), firstTarget(controller))).NotTo(Succeed())
+		Expect(getEndpoint().Subsets).To(BeEmpty())
+		Expect(recorder.Events).To(Receive(ContainSubstring("DNSLookupFailed")))
+		Expect(recorder.Events).To(Receive(Equal(fmt.Sprintf(`Warning LastKnownGoodExpired Last known good IPs ["1.1.1.1"] resolved at %s are older than 1h0m0s, not restoring them`, resolvedAt.Format(time.RFC3339)))))
+	})
+
+	It("should only refresh the persisted resolution time if the addresses changed or once per persist interval", func() {
+		Expect(FallbackOptions{MaxStaleness: time.Hour}.persistInterval()).To(Equal(30 * time.Minute))
+		Expect(FallbackOptions{MaxStaleness: time.Minute}.persistInterval()).To(Equal(time.Minute))
+
+		t := firstTarget(controller)
+		now := time.Now()
+		t.lastKnownGood = controller.newLastKnownGood(t, readyAddresses([]string{"1.1.1.1"}), now)
+
+		unchanged := controller.newLastKnownGood(t, readyAddresses([]string{"1.1.1.1"}), now.Add(10*time.Minute))
+		Expect(unchanged.annotation()).To(Equal(t.lastKnownGood.annotation()))
+		Expect(unchanged.resolved()).To(Equal(now.Add(10 * time.Minute)))
+
+		changed := controller.newLastKnownGood(t, advertisedAddresses{notReady: []string{"1.1.1.1"}}, now.Add(10*time.Minute))
+		Expect(changed.annotation()).NotTo(Equal(t.lastKnownGood.annotation()))
+
+		refreshed := controller.newLastKnownGood(t, readyAddresses([]string{"1.1.1.1"}), now.Add(30*time.Minute))
+		Expect(refreshed.ResolvedAt).To(Equal(now.Add(30 * time.Minute).Truncate(time.Second)))
+	})
+
+	It("should restore the not ready addresses as not ready and keep the retained addresses", func() {
+		controller.options.GracePeriod = GracePeriodOptions{RemovalGracePeriod: time.Hour, NotReady: true}
+		t := firstTarget(controller)
+
+		resolver["elb.example.com."] = []string{"1.1.1.1", "2.2.2.2"}
+		Expect(controller.reconcileTarget(context.TODO(), t)).To(Succeed())
+		Expect(sharedK8sInformerFactory.Core().V1().Endpoints().Informer().GetStore().Add(getEndpoint())).To(Succeed())
+		resolver["elb.example.com."] = []string{"1.1.1.1"}
+		Expect(controller.reconcileTarget(context.TODO(), t)).To(Succeed())
+		retained := getEndpoint().Annotations[retainedAddressesAnnotation]
+		Expect(retained).NotTo(BeEmpty())
+
+		// the endpoint is emptied while DNS is down
+		endpoint := getEndpoint()
+		endpoint.Subsets = nil
+		endpoint.Annotations = map[string]string{lastKnownGoodAnnotation: endpoint.Annotations[lastKnownGoodAnnotation]}
+		_, err := fakeClient.CoreV1().Endpoints(metav1.NamespaceDefault).Update(context.TODO(), endpoint, metav1.UpdateOptions{})
+		Expect(err).To(BeNil())
+		Expect(sharedK8sInformerFactory.Core().V1().Endpoints().Informer().GetStore().Update(endpoint)).To(Succeed())
+		delete(resolver, "elb.example.com.")
+
+		Expect(controller.reconcileTarget(context.TODO(), t)).NotTo(Succeed())
+		endpoint = getEndpoint()
+		Expect(endpoint.Subsets[0].Addresses).To(Equal([]corev1.EndpointAddress{{IP: "1.1.1.1"}}))
+		Expect(endpoint.Subsets[0].NotReadyAddresses).To(Equal([]corev1.EndpointAddress{{IP: "2.2.2.2"}}))
+		Expect(endpoint.Annotations).To(HaveKeyWithValue(retainedAddressesAnnotation, retained))
+	})
+
+	It("should not restore retained addresses whose grace period ended", func() {
+		controller.options.GracePeriod = GracePeriodOptions{RemovalGracePeriod: time.Hour}
+		t := firstTarget(controller)
+		t.lastKnownGood, t.lastKnownGoodLoaded = &lastKnownGood{Addresses: []string{"1.1.1.1", "2.2.2.2"}, ResolvedAt: time.Now()}, true
+		t.grace.loaded = true
+		t.grace.retained = map[string]time.Time{"2.2.2.2": time.Now().Add(-time.Second)}
+
+		Expect(controller.reconcileTarget(context.TODO(), t)).NotTo(Succeed())
+		Expect(getEndpoint().Subsets[0].Addresses).To(Equal([]corev1.EndpointAddress{{IP: "1.1.1.1"}}))
+	})
+})
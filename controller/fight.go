@@ -0,0 +1,247 @@
+// SPDX-FileCopyrightText: 2020 SAP SE or an SAP affiliate company and Gardener contributors
+//
+// SPDX-License-Identifier: Apache-2.0
+
+package controller
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	utilerrors "k8s.io/apimachinery/pkg/util/errors"
+)
+
+const (
+	// FightPolicyKeepFighting keeps writing the endpoint while it is overwritten by another writer
+	FightPolicyKeepFighting = "keep-fighting"
+	// FightPolicyBackOff stops writing the endpoint for an exponentially growing time after every overwrite while it
+	// is fought over
+	FightPolicyBackOff = "back-off"
+	// FightPolicyFail fails the reconciles of the target while its endpoint is fought over
+	FightPolicyFail = "fail"
+
+	// apiServerFieldManager is the field manager of the endpoint reconciler of the kube-apiserver
+	apiServerFieldManager = "kube-apiserver"
+	// apiServerIdentityLabelSelector selects the identity leases the kube-apiservers maintain in kube-system
+	apiServerIdentityLabelSelector = "apiserver.kubernetes.io/identity=kube-apiserver"
+
+	// initialFightBackoff is the first time writes are suspended with FightPolicyBackOff
+	initialFightBackoff = time.Minute
+	// unknownFieldManager is reported if the writer of an overwrite is not recorded in the managed fields
+	unknownFieldManager = "unknown"
+)
+
+// errWritesSuspended is returned while the writes to a fought over endpoint are suspended with FightPolicyBackOff. The
+// endpoint is not in sync, but the reconcile did not fail.
+var errWritesSuspended = errors.New("writes to the fought over endpoint are suspended")
+
+// writesSuspended returns whether the reconcile only left the endpoint unchanged because its writes are suspended
+func writesSuspended(err error) bool {
+	if agg, ok := err.(utilerrors.Aggregate); ok {
+		for _, err := range agg.Errors() {
+			if !writesSuspended(err) {
+				return false
+			}
+		}
+		return len(agg.Errors()) != 0
+	}
+	return errors.Is(err, errWritesSuspended)
+}
+
+// FightOptions configure how overwrites of the managed endpoint by other writers, like the endpoint reconciler of
+// the kube-apiserver, are detected and handled
+type FightOptions struct {
+	// Policy is one of FightPolicyKeepFighting, FightPolicyBackOff or FightPolicyFail
+	Policy string
+	// Threshold is the number of overwrites within Window after which the endpoint is considered fought over, zero
+	// disables the detection
+	Threshold int
+	// Window is the time overwrites are counted in
+	Window time.Duration
+	// MaxBackoff bounds the time writes are suspended with FightPolicyBackOff
+	MaxBackoff time.Duration
+}
+
+// Validate checks that the fight options are consistent
+func (o FightOptions) Validate() error {
+	switch o.Policy {
+	case "", FightPolicyKeepFighting, FightPolicyBackOff, FightPolicyFail:
+	default:
+		return fmt.Errorf("fight policy must be one of %q, %q or %q", FightPolicyKeepFighting, FightPolicyBackOff, FightPolicyFail)
+	}
+	if o.Threshold < 0 {
+		return fmt.Errorf("fight threshold must not be negative")
+	}
+	if o.Threshold > 0 && o.Window <= 0 {
+		return fmt.Errorf("fight window must be positive")
+	}
+	if o.Policy == FightPolicyBackOff && o.MaxBackoff < initialFightBackoff {
+		return fmt.Errorf("fight maximum backoff must be at least %s", initialFightBackoff)
+	}
+	return nil
+}
+
+// fightState tracks the overwrites of the managed endpoint of a target. It is only accessed by the worker reconciling
+// the target.
+type fightState struct {
+	// written identifies the addresses of the last write, empty if nothing was written yet
+	written string
+	// writtenAt is the time of the last write
+	writtenAt time.Time
+	// overwrites are the times the endpoint was overwritten within the window
+	overwrites []time.Time
+	// fighting is set while the number of overwrites within the window reaches the threshold
+	fighting bool
+	// backoff is the time writes are suspended after the next overwrite with FightPolicyBackOff
+	backoff time.Duration
+	// suspendedUntil is the end of the current suspension of writes
+	suspendedUntil time.Time
+}
+
+// endpointAddressesKey identifies the ready and not ready addresses of the first subset of an endpoint
+func endpointAddressesKey(ready, notReady []string) string {
+	return addressSetKey(ready) + "|" + addressSetKey(notReady)
+}
+
+// lastOverwriter returns the field manager which changed the endpoint last apart from the given field manager of the
+// readvertiser
+func lastOverwriter(endpoint *corev1.Endpoints, fieldManager string) string {
+	manager, latest := unknownFieldManager, time.Time{}
+	for _, entry := range endpoint.ManagedFields {
+		if entry.Manager == fieldManager || entry.Time == nil {
+			continue
+		}
+		if entry.Time.Time.After(latest) || entry.Time.Time.Equal(latest) {
+			manager, latest = entry.Manager, entry.Time.Time
+		}
+	}
+	return manager
+}
+
+// observeEndpointWrite records the addresses written to the endpoint of the target
+func (c *AWSLBReadvertiserController) observeEndpointWrite(t *target, addresses advertisedAddresses, now time.Time) {
+	t.fight.written = endpointAddressesKey(addresses.ready, addresses.notReady)
+	t.fight.writtenAt = now
+}
+
+// observeEndpoint detects whether the addresses of the endpoint were overwritten since they were written by the
+// readvertiser and updates the fight state of the target
+func (c *AWSLBReadvertiserController) observeEndpoint(t *target, endpoint *corev1.Endpoints, ready, notReady []string, now time.Time) {
+	f := &t.fight
+	window := c.options.Fight.Window
+
+	var recent []time.Time
+	for _, overwrite := range f.overwrites {
+		if now.Sub(overwrite) < window {
+			recent = append(recent, overwrite)
+		}
+	}
+	f.overwrites = recent
+
+	if len(f.written) != 0 && endpointAddressesKey(ready, notReady) != f.written {
+		manager := lastOverwriter(endpoint, c.fieldManager())
+		after := now.Sub(f.writtenAt).Round(time.Second)
+		endpointOverwrites.WithLabelValues(t.Key(), manager).Inc()
+		t.log.Warnf("The endpoint was overwritten by %q %s after it was written, its IPs are %q", manager, after, ready)
+		// the overwrite is only counted once, even if the endpoint is not written again
+		f.written = ""
+		f.overwrites = append(f.overwrites, now)
+
+		if f.fighting && c.options.Fight.Policy == FightPolicyBackOff {
+			f.suspendedUntil = now.Add(f.backoff)
+			t.log.Warnf("Suspending writes to the fought over endpoint until %s", f.suspendedUntil.Format(time.RFC3339))
+			f.backoff *= 2
+			if f.backoff > c.options.Fight.MaxBackoff {
+				f.backoff = c.options.Fight.MaxBackoff
+			}
+		}
+
+		if !f.fighting && len(f.overwrites) >= c.options.Fight.Threshold {
+			f.fighting = true
+			f.backoff = initialFightBackoff
+			c.recordEvent(t, corev1.EventTypeWarning, EventReasonEndpointFight, "Endpoint was overwritten %d times within %s, last by %q %s after it was written, applying policy %s", len(f.overwrites), window, manager, after, c.fightPolicy())
+			if c.options.Fight.Policy == FightPolicyBackOff {
+				f.suspendedUntil = now.Add(f.backoff)
+				f.backoff *= 2
+			}
+		}
+	}
+
+	if f.fighting && len(f.overwrites) == 0 {
+		t.log.Info("The endpoint was not overwritten within the fight window anymore")
+		f.fighting = false
+		f.suspendedUntil = time.Time{}
+	}
+	if f.fighting {
+		endpointFight.WithLabelValues(t.Key()).Set(1)
+	} else {
+		endpointFight.WithLabelValues(t.Key()).Set(0)
+	}
+}
+
+// fightPolicy returns the configured fight policy, FightPolicyKeepFighting by default
+func (c *AWSLBReadvertiserController) fightPolicy() string {
+	if len(c.options.Fight.Policy) == 0 {
+		return FightPolicyKeepFighting
+	}
+	return c.options.Fight.Policy
+}
+
+// checkFight observes the existing endpoint of the target and returns whether it may be written according to the
+// fight policy, or an error if the target must fail. While the writes are suspended errWritesSuspended is returned.
+func (c *AWSLBReadvertiserController) checkFight(t *target, endpoint *corev1.Endpoints, ready, notReady []string) (bool, error) {
+	if c.options.Fight.Threshold == 0 {
+		return true, nil
+	}
+
+	now := time.Now()
+	c.observeEndpoint(t, endpoint, ready, notReady, now)
+	if !t.fight.fighting {
+		return true, nil
+	}
+
+	switch c.fightPolicy() {
+	case FightPolicyBackOff:
+		if now.Before(t.fight.suspendedUntil) {
+			return false, fmt.Errorf("%w until %s", errWritesSuspended, t.fight.suspendedUntil.Format(time.RFC3339))
+		}
+	case FightPolicyFail:
+		return false, fmt.Errorf("the endpoint %s is fought over by %q, not writing it", t.Key(), lastOverwriter(endpoint, c.fieldManager()))
+	}
+	return true, nil
+}
+
+// checkEndpointReconciler warns if the kube-apiservers may maintain the default/kubernetes endpoint managed by a
+// target. The identity leases only show that kube-apiservers run, not their --endpoint-reconciler-type, hence the
+// overwrites are detected by the fight detection and this check only hints at their likely cause.
+func (c *AWSLBReadvertiserController) checkEndpointReconciler(ctx context.Context) {
+	t, ok := c.targets[metav1.NamespaceDefault+"/kubernetes"]
+	if !ok || !c.manageEndpoints() {
+		return
+	}
+
+	leases, err := c.client.CoordinationV1().Leases(metav1.NamespaceSystem).List(ctx, metav1.ListOptions{LabelSelector: apiServerIdentityLabelSelector})
+	if err != nil {
+		t.log.Warnf("Could not check whether the kube-apiservers maintain the endpoint, listing their leases in %s failed: %v", metav1.NamespaceSystem, err)
+		return
+	}
+	if len(leases.Items) == 0 {
+		return
+	}
+
+	endpoint, err := c.endpointsLister.Endpoints(t.EndpointNamespace).Get(t.EndpointName)
+	if err != nil {
+		return
+	}
+	for _, entry := range endpoint.ManagedFields {
+		if entry.Manager == apiServerFieldManager {
+			c.recordEvent(t, corev1.EventTypeWarning, EventReasonEndpointReconcilerConflict, "The endpoint was written by the kube-apiserver and %d kube-apiservers hold leases in %s, they may overwrite it unless they run with --endpoint-reconciler-type=none", len(leases.Items), metav1.NamespaceSystem)
+			return
+		}
+	}
+	t.log.Infof("%d kube-apiservers hold leases in %s, make sure they run with --endpoint-reconciler-type=none", len(leases.Items), metav1.NamespaceSystem)
+}
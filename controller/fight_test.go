@@ -0,0 +1,183 @@
+// SPDX-FileCopyrightText: 2020 SAP SE or an SAP affiliate company and Gardener contributors
+//
+// SPDX-License-Identifier: Apache-2.0
+
+package controller
+
+import (
+	"context"
+	"time"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	coordinationv1 "k8s.io/api/coordination/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	k8sinformers "k8s.io/client-go/informers"
+	"k8s.io/client-go/kubernetes/fake"
+	"k8s.io/client-go/tools/record"
+)
+
+var _ = Describe("#checkFight", func() {
+	var (
+		fakeClient               *fake.Clientset
+		sharedK8sInformerFactory k8sinformers.SharedInformerFactory
+		recorder                 *record.FakeRecorder
+		controller               *AWSLBReadvertiserController
+	)
+
+	newController := func(options FightOptions) {
+		fakeClient = fake.NewSimpleClientset()
+		sharedK8sInformerFactory = k8sinformers.NewSharedInformerFactory(fakeClient, time.Hour)
+		recorder = record.NewFakeRecorder(100)
+		controller = newTestController(fakeClient, sharedK8sInformerFactory, staticResolver{"elb.example.com.": {"1.1.1.1"}}, recorder, nil, Options{Fight: options})
+	}
+
+	AfterEach(func() {
+		controller.queue.ShutDown()
+	})
+
+	getEndpoint := func() *corev1.Endpoints {
+		endpoint, err := fakeClient.CoreV1().Endpoints(metav1.NamespaceDefault).Get(context.TODO(), "kubernetes", metav1.GetOptions{})
+		Expect(err).To(BeNil())
+		return endpoint
+	}
+
+	// reconcile reconciles the target with the endpoint written by the fake client in the informer cache
+	reconcile := func() error {
+		if endpoint, err := fakeClient.CoreV1().Endpoints(metav1.NamespaceDefault).Get(context.TODO(), "kubernetes", metav1.GetOptions{}); err == nil {
+			Expect(sharedK8sInformerFactory.Core().V1().Endpoints().Informer().GetStore().Update(endpoint)).To(Succeed())
+		}
+		return controller.reconcileTarget(context.TODO(), firstTarget(controller))
+	}
+
+	// overwrite rewrites the endpoint like the endpoint reconciler of the kube-apiserver
+	overwrite := func() {
+		endpoint := getEndpoint()
+		endpoint.Subsets = []corev1.EndpointSubset{{Addresses: []corev1.EndpointAddress{{IP: "10.0.0.1"}}, Ports: DefaultEndpointPorts()}}
+		endpoint.ManagedFields = []metav1.ManagedFieldsEntry{
+			{Manager: DefaultFieldManager, Operation: metav1.ManagedFieldsOperationUpdate, Time: &metav1.Time{Time: time.Now().Add(-time.Second)}},
+			{Manager: apiServerFieldManager, Operation: metav1.ManagedFieldsOperationUpdate, Time: &metav1.Time{Time: time.Now()}},
+		}
+		_, err := fakeClient.CoreV1().Endpoints(metav1.NamespaceDefault).Update(context.TODO(), endpoint, metav1.UpdateOptions{})
+		Expect(err).To(BeNil())
+	}
+
+	advertisedIPs := func() []corev1.EndpointAddress {
+		return getEndpoint().Subsets[0].Addresses
+	}
+
+	It("should detect the fight and keep writing the endpoint", func() {
+		newController(FightOptions{Policy: FightPolicyKeepFighting, Threshold: 2, Window: time.Hour})
+		Expect(reconcile()).To(Succeed())
+
+		overwrite()
+		Expect(reconcile()).To(Succeed())
+		Expect(advertisedIPs()).To(Equal([]corev1.EndpointAddress{{IP: "1.1.1.1"}}))
+		Expect(firstTarget(controller).fight.fighting).To(BeFalse())
+
+		overwrite()
+		Expect(reconcile()).To(Succeed())
+		Expect(advertisedIPs()).To(Equal([]corev1.EndpointAddress{{IP: "1.1.1.1"}}))
+		Expect(firstTarget(controller).fight.fighting).To(BeTrue())
+
+		var events []string
+		for len(recorder.Events) != 0 {
+			events = append(events, <-recorder.Events)
+		}
+		Expect(events).To(ContainElement(HavePrefix(`Warning EndpointFight Endpoint was overwritten 2 times within 1h0m0s, last by "kube-apiserver"`)))
+	})
+
+	It("should not count changes written by the readvertiser itself", func() {
+		newController(FightOptions{Policy: FightPolicyKeepFighting, Threshold: 1, Window: time.Hour})
+		Expect(reconcile()).To(Succeed())
+		Expect(reconcile()).To(Succeed())
+		Expect(firstTarget(controller).fight.overwrites).To(BeEmpty())
+	})
+
+	It("should suspend writes while fighting with the back-off policy", func() {
+		newController(FightOptions{Policy: FightPolicyBackOff, Threshold: 1, Window: time.Hour, MaxBackoff: time.Hour})
+		Expect(reconcile()).To(Succeed())
+
+		overwrite()
+		Expect(writesSuspended(reconcile())).To(BeTrue())
+		Expect(advertisedIPs()).To(Equal([]corev1.EndpointAddress{{IP: "10.0.0.1"}}))
+		Expect(firstTarget(controller).fight.suspendedUntil).To(BeTemporally("~", time.Now().Add(time.Minute), time.Second))
+
+		// writes are resumed once the suspension ended and suspended for longer after the next overwrite
+		firstTarget(controller).fight.suspendedUntil = time.Now()
+		Expect(reconcile()).To(Succeed())
+		Expect(advertisedIPs()).To(Equal([]corev1.EndpointAddress{{IP: "1.1.1.1"}}))
+
+		overwrite()
+		Expect(writesSuspended(reconcile())).To(BeTrue())
+		Expect(advertisedIPs()).To(Equal([]corev1.EndpointAddress{{IP: "10.0.0.1"}}))
+		Expect(firstTarget(controller).fight.suspendedUntil).To(BeTemporally("~", time.Now().Add(2*time.Minute), time.Second))
+	})
+
+	It("should report suspended writes as out of sync without failing or succeeding", func() {
+		newController(FightOptions{Policy: FightPolicyBackOff, Threshold: 1, Window: time.Hour, MaxBackoff: time.Hour})
+		t := firstTarget(controller)
+		Expect(reconcile()).To(Succeed())
+		recordReconcile(t, nil)
+		controller.health.observeReconcile(t.Key(), nil)
+		lastSuccess := controller.health.targets[t.Key()].lastSuccess
+		lastSuccessfulReconcile.lock.Lock()
+		lastSuccessfulAt := lastSuccessfulReconcile.lastSuccess[t.Key()]
+		lastSuccessfulReconcile.lock.Unlock()
+		suspended := testutil.ToFloat64(reconciles.WithLabelValues(t.Key(), resultSuspended))
+
+		overwrite()
+		endpoint := getEndpoint()
+		Expect(sharedK8sInformerFactory.Core().V1().Endpoints().Informer().GetStore().Update(endpoint)).To(Succeed())
+		controller.queue.Add(t.Key())
+		Expect(controller.processNextWorkItem(context.TODO())).To(BeTrue())
+
+		Expect(advertisedIPs()).To(Equal([]corev1.EndpointAddress{{IP: "10.0.0.1"}}))
+		Expect(testutil.ToFloat64(reconciles.WithLabelValues(t.Key(), resultSuspended)) - suspended).To(Equal(1.0))
+		Expect(testutil.ToFloat64(endpointInSync.WithLabelValues(t.Key()))).To(Equal(0.0))
+		lastSuccessfulReconcile.lock.Lock()
+		Expect(lastSuccessfulReconcile.lastSuccess[t.Key()]).To(Equal(lastSuccessfulAt))
+		lastSuccessfulReconcile.lock.Unlock()
+		Expect(controller.health.targets[t.Key()].lastSuccess).To(Equal(lastSuccess))
+		Expect(controller.health.targets[t.Key()].consecutiveFailures).To(BeZero())
+		Expect(controller.queue.NumRequeues(t.Key())).To(BeZero())
+	})
+
+	It("should fail the target while fighting with the fail policy", func() {
+		newController(FightOptions{Policy: FightPolicyFail, Threshold: 1, Window: time.Hour})
+		Expect(reconcile()).To(Succeed())
+
+		overwrite()
+		Expect(reconcile()).To(MatchError(ContainSubstring(`fought over by "kube-apiserver"`)))
+		Expect(advertisedIPs()).To(Equal([]corev1.EndpointAddress{{IP: "10.0.0.1"}}))
+	})
+
+	It("should warn if the kube-apiservers maintain the endpoint", func() {
+		newController(FightOptions{})
+		Expect(reconcile()).To(Succeed())
+		overwrite()
+		Expect(sharedK8sInformerFactory.Core().V1().Endpoints().Informer().GetStore().Update(getEndpoint())).To(Succeed())
+		_, err := fakeClient.CoordinationV1().Leases(metav1.NamespaceSystem).Create(context.TODO(), &coordinationv1.Lease{ObjectMeta: metav1.ObjectMeta{
+			Name:      "kube-apiserver-abc",
+			Namespace: metav1.NamespaceSystem,
+			Labels:    map[string]string{"apiserver.kubernetes.io/identity": "kube-apiserver"},
+		}}, metav1.CreateOptions{})
+		Expect(err).To(BeNil())
+		for len(recorder.Events) != 0 {
+			<-recorder.Events
+		}
+
+		controller.checkEndpointReconciler(context.TODO())
+		Expect(recorder.Events).To(Receive(Equal("Warning EndpointReconcilerConflict The endpoint was written by the kube-apiserver and 1 kube-apiservers hold leases in kube-system, they may overwrite it unless they run with --endpoint-reconciler-type=none")))
+	})
+
+	It("should validate the options", func() {
+		Expect(FightOptions{}.Validate()).To(Succeed())
+		Expect(FightOptions{Policy: FightPolicyBackOff, Threshold: 3, Window: time.Minute, MaxBackoff: time.Hour}.Validate()).To(Succeed())
+		Expect(FightOptions{Policy: "surrender"}.Validate()).NotTo(Succeed())
+		Expect(FightOptions{Threshold: 3}.Validate()).NotTo(Succeed())
+		Expect(FightOptions{Policy: FightPolicyBackOff, Threshold: 3, Window: time.Minute}.Validate()).NotTo(Succeed())
+	})
+})
@@ -0,0 +1,127 @@
+// SPDX-FileCopyrightText: 2020 SAP SE or an SAP affiliate company and Gardener contributors
+//
+// SPDX-License-Identifier: Apache-2.0
+
+package controller
+
+import (
+	"fmt"
+	"net"
+	"strings"
+
+	corev1 "k8s.io/api/core/v1"
+)
+
+// Reasons addresses returned by the resolver are filtered for
+const (
+	filterReasonInvalid     = "invalid"
+	filterReasonUnspecified = "unspecified"
+	filterReasonLoopback    = "loopback"
+	filterReasonLinkLocal   = "link-local"
+	filterReasonMulticast   = "multicast"
+	filterReasonBroadcast   = "broadcast"
+	filterReasonDenied      = "denied"
+	filterReasonNotAllowed  = "not-allowed"
+)
+
+// FilterOptions configure the CIDRs resolved addresses are filtered with
+type FilterOptions struct {
+	// AllowCIDRs are the CIDRs a resolved address must be part of, all addresses are allowed if empty
+	AllowCIDRs []string
+	// DenyCIDRs are the CIDRs a resolved address must not be part of
+	DenyCIDRs []string
+}
+
+// AddressFilter drops resolved addresses which must not be advertised
+type AddressFilter struct {
+	allow []*net.IPNet
+	deny  []*net.IPNet
+}
+
+// NewAddressFilter returns the AddressFilter for the given options
+func NewAddressFilter(options FilterOptions) (*AddressFilter, error) {
+	parse := func(cidrs []string) ([]*net.IPNet, error) {
+		var networks []*net.IPNet
+		for _, cidr := range cidrs {
+			_, network, err := net.ParseCIDR(strings.TrimSpace(cidr))
+			if err != nil {
+				return nil, fmt.Errorf("invalid CIDR %q: %v", cidr, err)
+			}
+			networks = append(networks, network)
+		}
+		return networks, nil
+	}
+
+	allow, err := parse(options.AllowCIDRs)
+	if err != nil {
+		return nil, err
+	}
+	deny, err := parse(options.DenyCIDRs)
+	if err != nil {
+		return nil, err
+	}
+	return &AddressFilter{allow: allow, deny: deny}, nil
+}
+
+// filterReason returns why the given address must not be advertised, empty if it can be advertised. Invalid and
+// special-purpose addresses are always rejected, the CIDRs are only checked if the filter is not nil.
+func (f *AddressFilter) filterReason(address string) string {
+	ip := net.ParseIP(address)
+	switch {
+	case ip == nil:
+		return filterReasonInvalid
+	case ip.IsUnspecified():
+		return filterReasonUnspecified
+	case ip.IsLoopback():
+		return filterReasonLoopback
+	case ip.IsLinkLocalUnicast(), ip.IsLinkLocalMulticast():
+		return filterReasonLinkLocal
+	case ip.IsMulticast():
+		return filterReasonMulticast
+	case ip.Equal(net.IPv4bcast):
+		return filterReasonBroadcast
+	}
+	if f == nil {
+		return ""
+	}
+
+	contains := func(networks []*net.IPNet) bool {
+		for _, network := range networks {
+			if network.Contains(ip) {
+				return true
+			}
+		}
+		return false
+	}
+	if contains(f.deny) {
+		return filterReasonDenied
+	}
+	if len(f.allow) != 0 && !contains(f.allow) {
+		return filterReasonNotAllowed
+	}
+	return ""
+}
+
+// filterAddresses drops the records returned by the resolver which are no IP, special-purpose addresses or rejected
+// by the configured CIDRs
+func (c *AWSLBReadvertiserController) filterAddresses(t *target, records []string) []string {
+	var (
+		valid    []string
+		filtered []string
+	)
+	for _, record := range records {
+		reason := c.options.AddressFilter.filterReason(record)
+		if len(reason) == 0 {
+			valid = append(valid, record)
+			continue
+		}
+		filteredAddresses.WithLabelValues(t.Key(), reason).Inc()
+		filtered = append(filtered, fmt.Sprintf("%s (%s)", record, reason))
+	}
+
+	if len(filtered) != 0 {
+		t.log.Warnf("Not advertising the filtered addresses %v", filtered)
+		c.recordEvent(t, corev1.EventTypeWarning, EventReasonAddressesFiltered, "Not advertising the filtered addresses %v", filtered)
+	}
+	return valid
+}
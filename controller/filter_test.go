@@ -0,0 +1,97 @@
+// SPDX-FileCopyrightText: 2020 SAP SE or an SAP affiliate company and Gardener contributors
+//
+// SPDX-License-Identifier: Apache-2.0
+
+package controller
+
+import (
+	"context"
+	"time"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	k8sinformers "k8s.io/client-go/informers"
+	"k8s.io/client-go/kubernetes/fake"
+	"k8s.io/client-go/tools/record"
+)
+
+var _ = Describe("AddressFilter", func() {
+	It("should reject invalid and special-purpose addresses without CIDRs", func() {
+		var filter *AddressFilter
+		for address, reason := range map[string]string{
+			"52.95.1.1":       "",
+			"10.250.0.1":      "",
+			"2a05:d018::1":    "",
+			"elb.example.com": filterReasonInvalid,
+			"0.0.0.0":         filterReasonUnspecified,
+			"::":              filterReasonUnspecified,
+			"127.0.0.1":       filterReasonLoopback,
+			"::1":             filterReasonLoopback,
+			"169.254.169.254": filterReasonLinkLocal,
+			"fe80::1":         filterReasonLinkLocal,
+			"224.1.1.1":       filterReasonMulticast,
+			"255.255.255.255": filterReasonBroadcast,
+		} {
+			Expect(filter.filterReason(address)).To(Equal(reason), address)
+		}
+	})
+
+	It("should apply the allow and deny CIDRs", func() {
+		filter, err := NewAddressFilter(FilterOptions{AllowCIDRs: []string{"52.0.0.0/8", "2a05:d018::/36"}, DenyCIDRs: []string{"52.95.0.0/16"}})
+		Expect(err).To(BeNil())
+
+		Expect(filter.filterReason("52.94.1.1")).To(BeEmpty())
+		Expect(filter.filterReason("2a05:d018::1")).To(BeEmpty())
+		Expect(filter.filterReason("52.95.1.1")).To(Equal(filterReasonDenied))
+		Expect(filter.filterReason("3.5.140.1")).To(Equal(filterReasonNotAllowed))
+		Expect(filter.filterReason("127.0.0.1")).To(Equal(filterReasonLoopback))
+	})
+
+	It("should reject invalid CIDRs", func() {
+		_, err := NewAddressFilter(FilterOptions{DenyCIDRs: []string{"52.95.0.0"}})
+		Expect(err).NotTo(BeNil())
+	})
+})
+
+var _ = Describe("#filterAddresses", func() {
+	var (
+		fakeClient *fake.Clientset
+		recorder   *record.FakeRecorder
+		resolver   staticResolver
+		controller *AWSLBReadvertiserController
+	)
+
+	BeforeEach(func() {
+		filter, err := NewAddressFilter(FilterOptions{DenyCIDRs: []string{"6.6.6.0/24"}})
+		Expect(err).To(BeNil())
+
+		fakeClient = fake.NewSimpleClientset()
+		sharedK8sInformerFactory := k8sinformers.NewSharedInformerFactory(fakeClient, time.Hour)
+		recorder = record.NewFakeRecorder(10)
+		resolver = staticResolver{}
+		controller = newTestController(fakeClient, sharedK8sInformerFactory, resolver, recorder, nil, Options{AddressFilter: filter})
+	})
+
+	AfterEach(func() {
+		controller.queue.ShutDown()
+	})
+
+	It("should not advertise filtered addresses", func() {
+		resolver["elb.example.com."] = []string{"1.1.1.1", "127.0.0.1", "6.6.6.6"}
+		Expect(controller.reconcileTarget(context.TODO(), firstTarget(controller))).To(Succeed())
+
+		endpoint, err := fakeClient.CoreV1().Endpoints(metav1.NamespaceDefault).Get(context.TODO(), "kubernetes", metav1.GetOptions{})
+		Expect(err).To(BeNil())
+		Expect(endpoint.Subsets[0].Addresses).To(Equal([]corev1.EndpointAddress{{IP: "1.1.1.1"}}))
+		Expect(recorder.Events).To(Receive(Equal(`Warning AddressesFiltered Not advertising the filtered addresses [127.0.0.1 (loopback) 6.6.6.6 (denied)]`)))
+	})
+
+	It("should keep the endpoint if every address is filtered", func() {
+		resolver["elb.example.com."] = []string{"0.0.0.0"}
+		Expect(controller.reconcileTarget(context.TODO(), firstTarget(controller))).NotTo(Succeed())
+		Expect(recorder.Events).To(Receive(ContainSubstring("AddressesFiltered")))
+		Expect(recorder.Events).To(Receive(ContainSubstring("EmptyResolution")))
+	})
+})
@@ -0,0 +1,275 @@
+// SPDX-FileCopyrightText: 2020 SAP SE or an SAP affiliate company and Gardener contributors
+//
+// SPDX-License-Identifier: Apache-2.0
+
+package controller
+
+import (
+	"encoding/json"
+	"fmt"
+	"time"
+
+	discoveryv1 "k8s.io/api/discovery/v1"
+	"k8s.io/apimachinery/pkg/util/sets"
+)
+
+// retainedAddressesAnnotation persists the addresses retained after they disappeared from DNS together with the end
+// of their grace period on the managed objects, so that a restart does not drop them early
+const retainedAddressesAnnotation = "aws-lb-readvertiser.gardener.cloud/retained-addresses"
+
+// GracePeriodOptions configure how long addresses which disappeared from DNS are still advertised
+type GracePeriodOptions struct {
+	// RemovalGracePeriod is the time an address is retained after it disappeared from DNS, zero removes it right away
+	RemovalGracePeriod time.Duration
+	// NotReady advertises the retained addresses as not ready, so that no new connections are sent to them
+	NotReady bool
+}
+
+// Validate checks that the grace period options are consistent
+func (o GracePeriodOptions) Validate() error {
+	if o.RemovalGracePeriod < 0 {
+		return fmt.Errorf("removal grace period must not be negative")
+	}
+	return nil
+}
+
+// advertisedAddresses are the addresses written to the managed objects of a target
+type advertisedAddresses struct {
+	// ready are advertised as ready addresses
+	ready []string
+	// notReady are advertised as not ready addresses
+	notReady []string
+	// retained maps the addresses which disappeared from DNS to the end of their grace period, they are part of
+	// ready or notReady
+	retained map[string]time.Time
+	// static are the static addresses advertised in addition to the resolved ones, they are part of ready
+	static []string
+	// lastKnownGood are persisted to fall back to when the resolution fails, nil if neither the fallback nor the
+	// removal grace period is enabled
+	lastKnownGood *lastKnownGood
+}
+
+// readyAddresses returns the addresses advertising all given IPs as ready
+func readyAddresses(ips []string) advertisedAddresses {
+	return advertisedAddresses{ready: ips}
+}
+
+// all returns the ready and not ready IPs
+func (a advertisedAddresses) all() []string {
+	return append(append([]string(nil), a.ready...), a.notReady...)
+}
+
+// resolved returns the ready and not ready IPs apart from the static ones
+func (a advertisedAddresses) resolved() []string {
+	return sets.NewString(a.all()...).Difference(sets.NewString(a.static...)).List()
+}
+
+// ofAddressType returns the addresses of the given EndpointSlice address type
+func (a advertisedAddresses) ofAddressType(addressType discoveryv1.AddressType) advertisedAddresses {
+	pick := func(ips []string) []string {
+		ipv4, ipv6 := partitionIPFamilies(ips, IPFamilyDual)
+		if addressType == discoveryv1.AddressTypeIPv6 {
+			return ipv6
+		}
+		return ipv4
+	}
+
+	result := advertisedAddresses{
+		ready:    pick(a.ready),
+		notReady: pick(a.notReady),
+		static:   pick(a.static),
+	}
+	for _, ip := range pick(sets.StringKeySet(a.retained).List()) {
+		if result.retained == nil {
+			result.retained = map[string]time.Time{}
+		}
+		result.retained[ip] = a.retained[ip]
+	}
+	if a.lastKnownGood != nil {
+		result.lastKnownGood = &lastKnownGood{
+			Addresses:         pick(a.lastKnownGood.Addresses),
+			NotReadyAddresses: pick(a.lastKnownGood.NotReadyAddresses),
+			ResolvedAt:        a.lastKnownGood.ResolvedAt,
+			lastResolved:      a.lastKnownGood.lastResolved,
+		}
+	}
+	return result
+}
+
+// retainedAnnotation returns the value of the retained addresses annotation, empty if no address is retained
+func (a advertisedAddresses) retainedAnnotation() string {
+	if len(a.retained) == 0 {
+		return ""
+	}
+	expiries := map[string]string{}
+	for ip, expiry := range a.retained {
+		expiries[ip] = expiry.UTC().Format(time.RFC3339)
+	}
+	// the keys of a map are marshalled in order, hence the value is stable
+	value, _ := json.Marshal(expiries)
+	return string(value)
+}
+
+// desiredAnnotations returns the values of the annotations persisting the state of the addresses, empty values
+// remove the annotation
+func (a advertisedAddresses) desiredAnnotations() map[string]string {
+	return map[string]string{
+		retainedAddressesAnnotation: a.retainedAnnotation(),
+		lastKnownGoodAnnotation:     a.lastKnownGood.annotation(),
+	}
+}
+
+// setAnnotations sets or removes the annotations persisting the state of the addresses in the given annotations
+func (a advertisedAddresses) setAnnotations(annotations map[string]string) map[string]string {
+	for key, value := range a.desiredAnnotations() {
+		if len(value) == 0 {
+			delete(annotations, key)
+			continue
+		}
+		if annotations == nil {
+			annotations = map[string]string{}
+		}
+		annotations[key] = value
+	}
+	return annotations
+}
+
+// annotationsValid checks if the given annotations persist the state of the addresses
+func (a advertisedAddresses) annotationsValid(annotations map[string]string) bool {
+	for key, value := range a.desiredAnnotations() {
+		if annotations[key] != value {
+			return false
+		}
+	}
+	return true
+}
+
+// parseRetainedAnnotation returns the retained addresses persisted in the given annotations
+func parseRetainedAnnotation(annotations map[string]string) (map[string]time.Time, error) {
+	value, ok := annotations[retainedAddressesAnnotation]
+	if !ok {
+		return nil, nil
+	}
+	expiries := map[string]string{}
+	if err := json.Unmarshal([]byte(value), &expiries); err != nil {
+		return nil, fmt.Errorf("invalid %s annotation: %v", retainedAddressesAnnotation, err)
+	}
+
+	retained := map[string]time.Time{}
+	for ip, expiry := range expiries {
+		t, err := time.Parse(time.RFC3339, expiry)
+		if err != nil {
+			return nil, fmt.Errorf("invalid %s annotation: %v", retainedAddressesAnnotation, err)
+		}
+		retained[ip] = t
+	}
+	return retained, nil
+}
+
+// graceState is the removal grace period state of a target. It is only accessed by the worker reconciling the target.
+type graceState struct {
+	// loaded is set once the state was restored from the managed objects
+	loaded bool
+	// previous are the addresses advertised by the last reconcile
+	previous sets.String
+	// retained maps the addresses which disappeared from DNS to the end of their grace period
+	retained map[string]time.Time
+}
+
+// loadRetainedAddresses restores the advertised and retained addresses from the annotations of the managed objects
+// after a restart. The addresses found in the managed objects are not trusted, as they may have been written by
+// others, e.g. the endpoint reconciler of the kube-apiserver, and must not be retained.
+func (c *AWSLBReadvertiserController) loadRetainedAddresses(t *target) {
+	g := &t.grace
+	g.loaded = true
+	g.previous = sets.NewString()
+	g.retained = map[string]time.Time{}
+
+	var annotations []map[string]string
+	if c.manageEndpoints() {
+		if endpoint, err := c.endpointsLister.Endpoints(t.EndpointNamespace).Get(t.EndpointName); err == nil {
+			annotations = append(annotations, endpoint.Annotations)
+		}
+	}
+	if c.manageEndpointSlices() {
+		for _, addressType := range []discoveryv1.AddressType{discoveryv1.AddressTypeIPv4, discoveryv1.AddressTypeIPv6} {
+			if slice, err := c.endpointSliceLister.EndpointSlices(t.EndpointNamespace).Get(endpointSliceName(t.EndpointName, addressType)); err == nil {
+				annotations = append(annotations, slice.Annotations)
+			}
+		}
+	}
+
+	for _, a := range annotations {
+		retained, err := parseRetainedAnnotation(a)
+		if err != nil {
+			t.log.Warnf("Could not restore the retained addresses: %v", err)
+			continue
+		}
+		for ip, expiry := range retained {
+			g.retained[ip] = expiry
+			g.previous.Insert(ip)
+		}
+
+		// the last known good addresses are the addresses advertised by the last reconcile
+		l, err := parseLastKnownGoodAnnotation(a)
+		if err != nil {
+			t.log.Warnf("Could not restore the advertised addresses: %v", err)
+			continue
+		}
+		if l != nil {
+			g.previous.Insert(l.all()...)
+		}
+	}
+	if len(g.retained) != 0 {
+		t.log.Infof("Restored %d retained addresses", len(g.retained))
+	}
+}
+
+// retainRemovedAddresses returns the addresses to advertise for the given resolved IPs. IPs which were advertised
+// before but are no longer resolved are retained until their grace period ends.
+func (c *AWSLBReadvertiserController) retainRemovedAddresses(t *target, ips []string, now time.Time) advertisedAddresses {
+	gracePeriod := c.options.GracePeriod.RemovalGracePeriod
+	if gracePeriod == 0 {
+		return readyAddresses(ips)
+	}
+
+	g := &t.grace
+	if !g.loaded {
+		c.loadRetainedAddresses(t)
+	}
+
+	resolved := sets.NewString(ips...)
+	for _, ip := range g.previous.Difference(resolved).List() {
+		if _, ok := g.retained[ip]; !ok {
+			g.retained[ip] = now.Add(gracePeriod)
+			t.log.Infof("IP %s disappeared from DNS, retaining it until %s", ip, g.retained[ip].Format(time.RFC3339))
+		}
+	}
+	for ip, expiry := range g.retained {
+		switch {
+		case resolved.Has(ip):
+			t.log.Infof("Retained IP %s is resolved again", ip)
+			delete(g.retained, ip)
+		case !now.Before(expiry):
+			t.log.Infof("Grace period of IP %s ended, removing it", ip)
+			delete(g.retained, ip)
+		}
+	}
+
+	addresses := advertisedAddresses{ready: ips}
+	if len(g.retained) != 0 {
+		addresses.retained = map[string]time.Time{}
+		for ip, expiry := range g.retained {
+			addresses.retained[ip] = expiry
+		}
+		if c.options.GracePeriod.NotReady {
+			addresses.notReady = sets.StringKeySet(g.retained).List()
+		} else {
+			addresses.ready = append(addresses.ready, sets.StringKeySet(g.retained).List()...)
+		}
+	}
+	retainedIPs.WithLabelValues(t.Key()).Set(float64(len(g.retained)))
+
+	g.previous = sets.NewString(addresses.all()...)
+	return addresses
+}
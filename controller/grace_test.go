@@ -0,0 +1,116 @@
+// SPDX-FileCopyrightText: 2020 SAP SE or an SAP affiliate company and Gardener contributors
+//
+// SPDX-License-Identifier: Apache-2.0
+
+package controller
+
+import (
+	"context"
+	"time"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	k8sinformers "k8s.io/client-go/informers"
+	"k8s.io/client-go/kubernetes/fake"
+	"k8s.io/client-go/tools/record"
+)
+
+var _ = Describe("#retainRemovedAddresses", func() {
+	var (
+		fakeClient               *fake.Clientset
+		sharedK8sInformerFactory k8sinformers.SharedInformerFactory
+		resolver                 staticResolver
+		controller               *AWSLBReadvertiserController
+		t                        *target
+	)
+
+	newController := func(options GracePeriodOptions) {
+		fakeClient = fake.NewSimpleClientset()
+		sharedK8sInformerFactory = k8sinformers.NewSharedInformerFactory(fakeClient, time.Hour)
+		resolver = staticResolver{}
+		controller = newTestController(fakeClient, sharedK8sInformerFactory, resolver, record.NewFakeRecorder(100), nil, Options{GracePeriod: options})
+		t = firstTarget(controller)
+	}
+
+	AfterEach(func() {
+		controller.queue.ShutDown()
+	})
+
+	It("should retain addresses which disappeared from DNS until the grace period ends", func() {
+		newController(GracePeriodOptions{RemovalGracePeriod: time.Minute})
+		now := time.Now()
+
+		Expect(controller.retainRemovedAddresses(t, []string{"1.1.1.1", "2.2.2.2"}, now)).To(Equal(readyAddresses([]string{"1.1.1.1", "2.2.2.2"})))
+
+		addresses := controller.retainRemovedAddresses(t, []string{"1.1.1.1"}, now.Add(time.Second))
+		Expect(addresses.ready).To(Equal([]string{"1.1.1.1", "2.2.2.2"}))
+		Expect(addresses.retained).To(Equal(map[string]time.Time{"2.2.2.2": now.Add(61 * time.Second)}))
+
+		// the grace period is not extended by later lookups
+		addresses = controller.retainRemovedAddresses(t, []string{"1.1.1.1"}, now.Add(30*time.Second))
+		Expect(addresses.retained).To(Equal(map[string]time.Time{"2.2.2.2": now.Add(61 * time.Second)}))
+
+		Expect(controller.retainRemovedAddresses(t, []string{"1.1.1.1"}, now.Add(61*time.Second))).To(Equal(readyAddresses([]string{"1.1.1.1"})))
+	})
+
+	It("should stop retaining addresses which are resolved again", func() {
+		newController(GracePeriodOptions{RemovalGracePeriod: time.Minute, NotReady: true})
+		now := time.Now()
+
+		controller.retainRemovedAddresses(t, []string{"1.1.1.1", "2.2.2.2"}, now)
+		addresses := controller.retainRemovedAddresses(t, []string{"1.1.1.1"}, now)
+		Expect(addresses.ready).To(Equal([]string{"1.1.1.1"}))
+		Expect(addresses.notReady).To(Equal([]string{"2.2.2.2"}))
+
+		Expect(controller.retainRemovedAddresses(t, []string{"1.1.1.1", "2.2.2.2"}, now)).To(Equal(readyAddresses([]string{"1.1.1.1", "2.2.2.2"})))
+	})
+
+	It("should persist the retained addresses on the endpoint and restore them after a restart", func() {
+		newController(GracePeriodOptions{RemovalGracePeriod: time.Hour, NotReady: true})
+
+		resolver["elb.example.com."] = []string{"1.1.1.1", "2.2.2.2"}
+		Expect(controller.reconcileTarget(context.TODO(), t)).To(Succeed())
+		endpoint, err := fakeClient.CoreV1().Endpoints(metav1.NamespaceDefault).Get(context.TODO(), "kubernetes", metav1.GetOptions{})
+		Expect(err).To(BeNil())
+		Expect(sharedK8sInformerFactory.Core().V1().Endpoints().Informer().GetStore().Add(endpoint)).To(Succeed())
+
+		resolver["elb.example.com."] = []string{"1.1.1.1"}
+		Expect(controller.reconcileTarget(context.TODO(), t)).To(Succeed())
+		endpoint, err = fakeClient.CoreV1().Endpoints(metav1.NamespaceDefault).Get(context.TODO(), "kubernetes", metav1.GetOptions{})
+		Expect(err).To(BeNil())
+		Expect(endpoint.Subsets[0].Addresses).To(Equal([]corev1.EndpointAddress{{IP: "1.1.1.1"}}))
+		Expect(endpoint.Subsets[0].NotReadyAddresses).To(Equal([]corev1.EndpointAddress{{IP: "2.2.2.2"}}))
+		Expect(endpoint.Annotations).To(HaveKey(retainedAddressesAnnotation))
+		expiry := t.grace.retained["2.2.2.2"]
+
+		// a new controller restores the retained address from the endpoint
+		Expect(sharedK8sInformerFactory.Core().V1().Endpoints().Informer().GetStore().Update(endpoint)).To(Succeed())
+		restarted := newTestController(fakeClient, sharedK8sInformerFactory, resolver, record.NewFakeRecorder(100), []Target{t.Target}, Options{GracePeriod: GracePeriodOptions{RemovalGracePeriod: time.Hour, NotReady: true}})
+		defer restarted.queue.ShutDown()
+
+		addresses := restarted.retainRemovedAddresses(firstTarget(restarted), []string{"1.1.1.1"}, time.Now())
+		Expect(addresses.notReady).To(Equal([]string{"2.2.2.2"}))
+		Expect(addresses.retained["2.2.2.2"].Equal(expiry.Truncate(time.Second))).To(BeTrue())
+	})
+
+	It("should only retain addresses it advertised itself after a restart", func() {
+		newController(GracePeriodOptions{RemovalGracePeriod: time.Hour})
+
+		// 10.0.0.5 was added to the subset by another writer, 2.2.2.2 disappeared from DNS during the restart
+		lastAdvertised := &lastKnownGood{Addresses: []string{"1.1.1.1", "2.2.2.2"}, ResolvedAt: time.Now()}
+		Expect(sharedK8sInformerFactory.Core().V1().Endpoints().Informer().GetStore().Add(&corev1.Endpoints{
+			ObjectMeta: metav1.ObjectMeta{Name: "kubernetes", Namespace: metav1.NamespaceDefault, Annotations: map[string]string{lastKnownGoodAnnotation: lastAdvertised.annotation()}},
+			Subsets: []corev1.EndpointSubset{{
+				Addresses: []corev1.EndpointAddress{{IP: "1.1.1.1"}, {IP: "10.0.0.5"}, {IP: "2.2.2.2"}},
+				Ports:     DefaultEndpointPorts(),
+			}},
+		})).To(Succeed())
+
+		addresses := controller.retainRemovedAddresses(t, []string{"1.1.1.1"}, time.Now())
+		Expect(addresses.ready).To(Equal([]string{"1.1.1.1", "2.2.2.2"}))
+		Expect(addresses.retained).To(HaveLen(1))
+		Expect(addresses.retained).To(HaveKey("2.2.2.2"))
+	})
+})
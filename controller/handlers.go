@@ -0,0 +1,102 @@
+// SPDX-FileCopyrightText: 2020 SAP SE or an SAP affiliate company and Gardener contributors
+//
+// SPDX-License-Identifier: Apache-2.0
+
+package controller
+
+import (
+	"fmt"
+
+	log "github.com/sirupsen/logrus"
+	discoveryv1 "k8s.io/api/discovery/v1"
+	"k8s.io/apimachinery/pkg/api/meta"
+	"k8s.io/client-go/tools/cache"
+)
+
+// endpointsEventHandler enqueues the target managing an Endpoints object whenever the object is added, changed or
+// deleted, so that drift is repaired without waiting for the next refresh. Resyncs of unchanged objects are ignored.
+func (c *AWSLBReadvertiserController) endpointsEventHandler() cache.ResourceEventHandler {
+	return cache.ResourceEventHandlerFuncs{
+		AddFunc: c.enqueueEndpoints,
+		UpdateFunc: func(oldObj, newObj interface{}) {
+			if !resourceVersionChanged(oldObj, newObj) {
+				return
+			}
+			c.enqueueEndpoints(newObj)
+		},
+		DeleteFunc: c.enqueueEndpoints,
+	}
+}
+
+// endpointSliceEventHandler enqueues the target owning an EndpointSlice written by the readvertiser
+func (c *AWSLBReadvertiserController) endpointSliceEventHandler() cache.ResourceEventHandler {
+	return cache.ResourceEventHandlerFuncs{
+		AddFunc: c.enqueueEndpointSlice,
+		UpdateFunc: func(oldObj, newObj interface{}) {
+			if !resourceVersionChanged(oldObj, newObj) {
+				return
+			}
+			c.enqueueEndpointSlice(newObj)
+		},
+		DeleteFunc: c.enqueueEndpointSlice,
+	}
+}
+
+// resourceVersionChanged returns whether an update of the informer changed the object, periodic resyncs deliver
+// updates with the same resource version
+func resourceVersionChanged(oldObj, newObj interface{}) bool {
+	oldMeta, err := meta.Accessor(oldObj)
+	if err != nil {
+		return true
+	}
+	newMeta, err := meta.Accessor(newObj)
+	if err != nil {
+		return true
+	}
+	return oldMeta.GetResourceVersion() != newMeta.GetResourceVersion()
+}
+
+func (c *AWSLBReadvertiserController) enqueueEndpoints(obj interface{}) {
+	key, err := cache.DeletionHandlingMetaNamespaceKeyFunc(obj)
+	if err != nil {
+		log.Errorf("could not get key of endpoint: %v", err)
+		return
+	}
+	c.enqueue(key)
+}
+
+func (c *AWSLBReadvertiserController) enqueueEndpointSlice(obj interface{}) {
+	if tombstone, ok := obj.(cache.DeletedFinalStateUnknown); ok {
+		obj = tombstone.Obj
+	}
+	endpointSlice, ok := obj.(*discoveryv1.EndpointSlice)
+	if !ok {
+		log.Errorf("could not get endpointslice from object %T", obj)
+		return
+	}
+	if endpointSlice.Labels[discoveryv1.LabelManagedBy] != endpointSliceManagedBy {
+		return
+	}
+	c.enqueue(fmt.Sprintf("%s/%s", endpointSlice.Namespace, endpointSlice.Labels[discoveryv1.LabelServiceName]))
+}
+
+// enqueue adds the key to the queue if it belongs to a target, changes to other objects are ignored
+func (c *AWSLBReadvertiserController) enqueue(key string) {
+	t, ok := c.targets[key]
+	if !ok {
+		return
+	}
+	t.log.Debug("Managed endpoint changed, enqueueing target")
+	c.queue.Add(key)
+}
+
+// enqueueRefresh adds the key of the target to the queue when its refresh is due, so that its hostnames are resolved
+// again. Targets which are backing off after a failed reconcile are skipped, the rate limited retry reconciles them.
+func (c *AWSLBReadvertiserController) enqueueRefresh(t *target) {
+	if c.queue.NumRequeues(t.Key()) > 0 {
+		t.log.Debug("Target is backing off after a failed reconcile, skipping refresh")
+		return
+	}
+	t.requestRefresh()
+	c.queue.Add(t.Key())
+}
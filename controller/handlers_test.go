@@ -0,0 +1,143 @@
+// SPDX-FileCopyrightText: 2020 SAP SE or an SAP affiliate company and Gardener contributors
+//
+// SPDX-License-Identifier: Apache-2.0
+
+package controller
+
+import (
+	"context"
+	"time"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	corev1 "k8s.io/api/core/v1"
+	discoveryv1 "k8s.io/api/discovery/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	k8sinformers "k8s.io/client-go/informers"
+	"k8s.io/client-go/kubernetes/fake"
+	"k8s.io/client-go/tools/cache"
+	"k8s.io/client-go/tools/record"
+)
+
+var _ = Describe("#processNextWorkItem", func() {
+	var (
+		fakeClient *fake.Clientset
+		controller *AWSLBReadvertiserController
+	)
+
+	BeforeEach(func() {
+		fakeClient = fake.NewSimpleClientset()
+		sharedK8sInformerFactory := k8sinformers.NewSharedInformerFactory(fakeClient, time.Hour)
+		controller = newTestController(fakeClient, sharedK8sInformerFactory, staticResolver{"good.example.com.": {"1.2.3.4"}}, record.NewFakeRecorder(100), []Target{
+			{Hostnames: []string{"bad.example.com."}, EndpointNamespace: metav1.NamespaceDefault, EndpointName: "bad", Ports: DefaultEndpointPorts(), RefreshPeriod: time.Second},
+			{Hostnames: []string{"good.example.com."}, EndpointNamespace: metav1.NamespaceDefault, EndpointName: "good", Ports: DefaultEndpointPorts(), RefreshPeriod: time.Second},
+		}, Options{EndpointAPI: EndpointAPIBoth})
+	})
+
+	AfterEach(func() {
+		controller.queue.ShutDown()
+	})
+
+	It("should enqueue targets for changes of their managed objects only", func() {
+		controller.enqueueEndpoints(&corev1.Endpoints{ObjectMeta: metav1.ObjectMeta{Namespace: metav1.NamespaceDefault, Name: "other"}})
+		Expect(controller.queue.Len()).To(Equal(0))
+
+		controller.enqueueEndpoints(cache.DeletedFinalStateUnknown{Key: "default/good", Obj: &corev1.Endpoints{ObjectMeta: metav1.ObjectMeta{Namespace: metav1.NamespaceDefault, Name: "good"}}})
+		Expect(controller.queue.Len()).To(Equal(1))
+
+		controller.enqueueEndpointSlice(&discoveryv1.EndpointSlice{ObjectMeta: metav1.ObjectMeta{Namespace: metav1.NamespaceDefault, Name: "bad-ipv4", Labels: map[string]string{
+			discoveryv1.LabelServiceName: "bad",
+			discoveryv1.LabelManagedBy:   endpointSliceManagedBy,
+		}}})
+		Expect(controller.queue.Len()).To(Equal(2))
+	})
+
+	It("should ignore resyncs of unchanged objects", func() {
+		endpoint := &corev1.Endpoints{ObjectMeta: metav1.ObjectMeta{Namespace: metav1.NamespaceDefault, Name: "good", ResourceVersion: "1"}}
+		controller.endpointsEventHandler().OnUpdate(endpoint, endpoint.DeepCopy())
+		Expect(controller.queue.Len()).To(Equal(0))
+
+		changed := endpoint.DeepCopy()
+		changed.ResourceVersion = "2"
+		controller.endpointsEventHandler().OnUpdate(endpoint, changed)
+		Expect(controller.queue.Len()).To(Equal(1))
+	})
+
+	It("should back off failing targets and forget successful ones", func() {
+		controller.queue.Add("default/bad")
+		controller.queue.Add("default/good")
+
+		Expect(controller.processNextWorkItem(context.TODO())).To(BeTrue())
+		Expect(controller.processNextWorkItem(context.TODO())).To(BeTrue())
+
+		Expect(controller.queue.NumRequeues("default/bad")).To(Equal(1))
+		Expect(controller.queue.NumRequeues("default/good")).To(Equal(0))
+
+		// refreshes of a backing off target are left to the rate limited retry
+		controller.enqueueRefresh(controller.targets["default/bad"])
+		controller.enqueueRefresh(controller.targets["default/good"])
+		Expect(controller.queue.Len()).To(Equal(1))
+	})
+})
+
+var _ = Describe("#lookupDue", func() {
+	var (
+		fakeClient               *fake.Clientset
+		sharedK8sInformerFactory k8sinformers.SharedInformerFactory
+		resolver                 staticResolver
+		controller               *AWSLBReadvertiserController
+	)
+
+	BeforeEach(func() {
+		fakeClient = fake.NewSimpleClientset()
+		sharedK8sInformerFactory = k8sinformers.NewSharedInformerFactory(fakeClient, time.Hour)
+		resolver = staticResolver{"elb.example.com.": {"1.2.3.4"}}
+		controller = newTestController(fakeClient, sharedK8sInformerFactory, resolver, record.NewFakeRecorder(100), nil, Options{Refresh: RefreshOptions{Mode: RefreshModeFixed, MinInterval: time.Hour}})
+	})
+
+	AfterEach(func() {
+		controller.queue.ShutDown()
+	})
+
+	// overwrite changes the advertised IP like another writer would and returns the IP advertised after the reconcile
+	overwrite := func(ip string) string {
+		endpoint, err := fakeClient.CoreV1().Endpoints(metav1.NamespaceDefault).Get(context.TODO(), "kubernetes", metav1.GetOptions{})
+		Expect(err).To(BeNil())
+		endpoint.Subsets[0].Addresses = []corev1.EndpointAddress{{IP: ip}}
+		_, err = fakeClient.CoreV1().Endpoints(metav1.NamespaceDefault).Update(context.TODO(), endpoint, metav1.UpdateOptions{})
+		Expect(err).To(BeNil())
+		Expect(sharedK8sInformerFactory.Core().V1().Endpoints().Informer().GetStore().Update(endpoint)).To(Succeed())
+
+		Expect(controller.reconcileTarget(context.TODO(), firstTarget(controller))).To(Succeed())
+		endpoint, err = fakeClient.CoreV1().Endpoints(metav1.NamespaceDefault).Get(context.TODO(), "kubernetes", metav1.GetOptions{})
+		Expect(err).To(BeNil())
+		return endpoint.Subsets[0].Addresses[0].IP
+	}
+
+	It("should repair changes within the minimum refresh interval from the last lookup", func() {
+		Expect(controller.reconcileTarget(context.TODO(), firstTarget(controller))).To(Succeed())
+
+		// the new answer is not looked up, the drift is repaired with the addresses of the last lookup
+		resolver["elb.example.com."] = []string{"5.6.7.8"}
+		Expect(overwrite("9.9.9.9")).To(Equal("1.2.3.4"))
+
+		// a scheduled refresh looks the hostnames up again
+		firstTarget(controller).requestRefresh()
+		Expect(overwrite("9.9.9.9")).To(Equal("5.6.7.8"))
+	})
+
+	It("should look up the hostnames again once the minimum refresh interval elapsed", func() {
+		Expect(controller.reconcileTarget(context.TODO(), firstTarget(controller))).To(Succeed())
+		firstTarget(controller).lastLookupAt = time.Now().Add(-time.Hour)
+
+		resolver["elb.example.com."] = []string{"5.6.7.8"}
+		Expect(overwrite("9.9.9.9")).To(Equal("5.6.7.8"))
+	})
+
+	It("should keep a pending refresh when reconciles are triggered by changes", func() {
+		t := firstTarget(controller)
+		Expect(controller.scheduleRefresh(t, time.Hour)).To(BeTrue())
+		Expect(controller.scheduleRefresh(t, time.Minute)).To(BeFalse())
+		t.refresh.timer.Stop()
+	})
+})
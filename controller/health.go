@@ -0,0 +1,179 @@
+// SPDX-FileCopyrightText: 2020 SAP SE or an SAP affiliate company and Gardener contributors
+//
+// SPDX-License-Identifier: Apache-2.0
+
+package controller
+
+import (
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+
+	utilerrors "k8s.io/apimachinery/pkg/util/errors"
+)
+
+// HealthOptions configure when the controller reports itself as not live or not ready
+type HealthOptions struct {
+	// StallTimeout is the time a target may go without a finished reconcile (liveness) or without a successful
+	// reconcile (readiness) on top of its refresh period, or the maximum refresh interval when refreshing by TTL. It
+	// also bounds the time the informers may take to sync. Zero disables these checks.
+	StallTimeout time.Duration
+	// MaxConsecutiveFailures is the number of consecutive failed reconciles of a target after which the controller is
+	// no longer live. Zero disables the check.
+	MaxConsecutiveFailures int
+}
+
+// targetHealth is the outcome of the recent reconciles of a target
+type targetHealth struct {
+	lastReconcile       time.Time
+	lastSuccess         time.Time
+	consecutiveFailures int
+}
+
+// health tracks the state the liveness and readiness checks are computed from
+type health struct {
+	HealthOptions
+
+	lock    sync.Mutex
+	created time.Time
+	// running is the time the reconcile loop started, it is zero while it is not running, e.g. on followers
+	running time.Time
+	targets map[string]*targetHealth
+}
+
+func newHealth(options HealthOptions) *health {
+	return &health{
+		HealthOptions: options,
+		created:       time.Now(),
+		targets:       map[string]*targetHealth{},
+	}
+}
+
+func (h *health) setRunning(running bool) {
+	h.lock.Lock()
+	defer h.lock.Unlock()
+
+	h.running = time.Time{}
+	if running {
+		h.running = time.Now()
+	}
+}
+
+func (h *health) observeReconcile(key string, err error) {
+	h.lock.Lock()
+	defer h.lock.Unlock()
+
+	th, ok := h.targets[key]
+	if !ok {
+		th = &targetHealth{}
+		h.targets[key] = th
+	}
+
+	th.lastReconcile = time.Now()
+	if writesSuspended(err) {
+		// the reconcile neither failed nor brought the endpoint in sync
+		return
+	}
+	if err != nil {
+		th.consecutiveFailures++
+		return
+	}
+	th.lastSuccess = th.lastReconcile
+	th.consecutiveFailures = 0
+}
+
+// checkSynced fails if the informers did not sync, after the stall timeout passed this is also reported to liveness
+func (c *AWSLBReadvertiserController) checkSynced() error {
+	for _, synced := range c.listersSynced() {
+		if !synced() {
+			return fmt.Errorf("informers have not synced since %s", c.health.created.Format(time.RFC3339))
+		}
+	}
+	return nil
+}
+
+// CheckLiveness fails when the informers did not sync within the stall timeout, when the reconcile loop has stalled
+// for a target or when a target failed more than the allowed number of times in a row. Followers of a leader election
+// only check the informers.
+func (c *AWSLBReadvertiserController) CheckLiveness() error {
+	if err := c.checkSynced(); err != nil {
+		if c.health.StallTimeout != 0 && time.Since(c.health.created) > c.health.StallTimeout {
+			return err
+		}
+		return nil
+	}
+
+	c.health.lock.Lock()
+	defer c.health.lock.Unlock()
+
+	if c.health.running.IsZero() {
+		return nil
+	}
+
+	var errs []error
+	for _, t := range c.sortedTargets() {
+		th := c.health.targets[t.Key()]
+		if th == nil {
+			th = &targetHealth{}
+		}
+
+		if c.health.StallTimeout != 0 {
+			// a reconcile before the loop (re)started does not count, the loop gets a full period to catch up
+			last := th.lastReconcile
+			if last.Before(c.health.running) {
+				last = c.health.running
+			}
+			if since := time.Since(last); since > c.maxRefreshInterval(t)+c.health.StallTimeout {
+				errs = append(errs, fmt.Errorf("target %s: no reconcile finished for %s", t.Key(), since.Round(time.Second)))
+			}
+		}
+		if c.health.MaxConsecutiveFailures != 0 && th.consecutiveFailures > c.health.MaxConsecutiveFailures {
+			errs = append(errs, fmt.Errorf("target %s: %d consecutive reconciles failed", t.Key(), th.consecutiveFailures))
+		}
+	}
+	return utilerrors.NewAggregate(errs)
+}
+
+// CheckReadiness fails until the informers synced and, while reconciling, until every target was reconciled
+// successfully within its refresh interval plus the stall timeout
+func (c *AWSLBReadvertiserController) CheckReadiness() error {
+	if err := c.checkSynced(); err != nil {
+		return err
+	}
+
+	c.health.lock.Lock()
+	defer c.health.lock.Unlock()
+
+	if c.health.running.IsZero() {
+		return nil
+	}
+
+	var errs []error
+	for _, t := range c.sortedTargets() {
+		th := c.health.targets[t.Key()]
+		if th == nil || th.lastSuccess.IsZero() {
+			errs = append(errs, fmt.Errorf("target %s: not reconciled successfully yet", t.Key()))
+			continue
+		}
+		if c.health.StallTimeout == 0 {
+			continue
+		}
+		if since := time.Since(th.lastSuccess); since > c.maxRefreshInterval(t)+c.health.StallTimeout {
+			errs = append(errs, fmt.Errorf("target %s: last successful reconcile was %s ago", t.Key(), since.Round(time.Second)))
+		}
+	}
+	return utilerrors.NewAggregate(errs)
+}
+
+// sortedTargets returns the targets ordered by key, so that the reported errors are stable
+func (c *AWSLBReadvertiserController) sortedTargets() []*target {
+	targets := make([]*target, 0, len(c.targets))
+	for _, t := range c.targets {
+		targets = append(targets, t)
+	}
+	sort.Slice(targets, func(i, j int) bool {
+		return targets[i].Key() < targets[j].Key()
+	})
+	return targets
+}
@@ -0,0 +1,92 @@
+// SPDX-FileCopyrightText: 2020 SAP SE or an SAP affiliate company and Gardener contributors
+//
+// SPDX-License-Identifier: Apache-2.0
+
+package controller
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	k8sinformers "k8s.io/client-go/informers"
+	"k8s.io/client-go/kubernetes/fake"
+	"k8s.io/client-go/tools/cache"
+	"k8s.io/client-go/tools/record"
+)
+
+var _ = Describe("#health", func() {
+	var (
+		ctx                      context.Context
+		cancel                   context.CancelFunc
+		sharedK8sInformerFactory k8sinformers.SharedInformerFactory
+		controller               *AWSLBReadvertiserController
+	)
+
+	BeforeEach(func() {
+		ctx, cancel = context.WithCancel(context.Background())
+		fakeClient := fake.NewSimpleClientset()
+		sharedK8sInformerFactory = k8sinformers.NewSharedInformerFactory(fakeClient, time.Hour)
+		controller = newTestController(fakeClient, sharedK8sInformerFactory, staticResolver{}, record.NewFakeRecorder(100), []Target{
+			{Hostnames: []string{"elb.example.com."}, EndpointNamespace: metav1.NamespaceDefault, EndpointName: "kubernetes", Ports: DefaultEndpointPorts(), RefreshPeriod: time.Minute},
+		}, Options{Health: HealthOptions{StallTimeout: time.Minute, MaxConsecutiveFailures: 2}})
+	})
+
+	AfterEach(func() {
+		cancel()
+		controller.queue.ShutDown()
+	})
+
+	startInformers := func() {
+		sharedK8sInformerFactory.Start(ctx.Done())
+		Expect(cache.WaitForCacheSync(ctx.Done(), controller.listersSynced()...)).To(BeTrue())
+	}
+
+	It("should only fail liveness if the informers do not sync within the stall timeout", func() {
+		Expect(controller.CheckLiveness()).To(Succeed())
+		Expect(controller.CheckReadiness()).NotTo(Succeed())
+
+		controller.health.created = time.Now().Add(-2 * time.Minute)
+		Expect(controller.CheckLiveness()).NotTo(Succeed())
+	})
+
+	It("should be live and ready while not leading once the informers synced", func() {
+		startInformers()
+		Expect(controller.CheckLiveness()).To(Succeed())
+		Expect(controller.CheckReadiness()).To(Succeed())
+	})
+
+	It("should require a recent successful reconcile while reconciling", func() {
+		startInformers()
+		controller.health.setRunning(true)
+		Expect(controller.CheckReadiness()).NotTo(Succeed())
+
+		controller.health.observeReconcile("default/kubernetes", nil)
+		Expect(controller.CheckLiveness()).To(Succeed())
+		Expect(controller.CheckReadiness()).To(Succeed())
+
+		controller.health.targets["default/kubernetes"].lastSuccess = time.Now().Add(-3 * time.Minute)
+		Expect(controller.CheckReadiness()).NotTo(Succeed())
+	})
+
+	It("should fail liveness if the reconcile loop stalled or failed too often", func() {
+		startInformers()
+		controller.health.setRunning(true)
+
+		controller.health.running = time.Now().Add(-3 * time.Minute)
+		Expect(controller.CheckLiveness()).NotTo(Succeed())
+
+		for i := 0; i < 2; i++ {
+			controller.health.observeReconcile("default/kubernetes", fmt.Errorf("lookup failed"))
+			Expect(controller.CheckLiveness()).To(Succeed())
+		}
+		controller.health.observeReconcile("default/kubernetes", fmt.Errorf("lookup failed"))
+		Expect(controller.CheckLiveness()).NotTo(Succeed())
+
+		controller.health.observeReconcile("default/kubernetes", nil)
+		Expect(controller.CheckLiveness()).To(Succeed())
+	})
+})
@@ -0,0 +1,149 @@
+// SPDX-FileCopyrightText: 2020 SAP SE or an SAP affiliate company and Gardener contributors
+//
+// SPDX-License-Identifier: Apache-2.0
+
+package controller
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/util/sets"
+)
+
+// IdentityOptions configure the verification of the TLS identity served by the resolved IPs
+type IdentityOptions struct {
+	// CAFile is the CA bundle the presented certificate chain must be signed by, empty disables the verification
+	CAFile string
+	// ServerName is the name the certificate must carry as SAN, the hostname the IP was resolved from is used if empty
+	ServerName string
+	// Port is the port the TLS handshake is made on
+	Port int
+	// Timeout is the timeout of a single handshake
+	Timeout time.Duration
+}
+
+// Validate checks that the identity options are consistent
+func (o IdentityOptions) Validate() error {
+	if len(o.CAFile) == 0 {
+		return nil
+	}
+	if o.Port < 1 || o.Port > 65535 {
+		return fmt.Errorf("identity verification port must be between 1 and 65535")
+	}
+	if o.Timeout <= 0 {
+		return fmt.Errorf("identity verification timeout must be positive")
+	}
+	return nil
+}
+
+// IdentityVerifier checks whether a resolved IP serves a certificate for the expected name, so that a poisoned or
+// mis-resolved DNS answer is not advertised. The hostname is the load balancer name the IP was resolved from.
+type IdentityVerifier interface {
+	VerifyIdentity(ctx context.Context, ip, hostname string) error
+}
+
+// NewIdentityVerifier returns the IdentityVerifier for the given options, nil if the verification is disabled
+func NewIdentityVerifier(options IdentityOptions) (IdentityVerifier, error) {
+	if len(options.CAFile) == 0 {
+		return nil, nil
+	}
+	pem, err := os.ReadFile(options.CAFile)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read identity CA file: %v", err)
+	}
+	pool := x509.NewCertPool()
+	if !pool.AppendCertsFromPEM(pem) {
+		return nil, fmt.Errorf("identity CA file %q contains no certificates", options.CAFile)
+	}
+	return &tlsIdentityVerifier{
+		rootCAs:    pool,
+		serverName: options.ServerName,
+		port:       options.Port,
+		timeout:    options.Timeout,
+	}, nil
+}
+
+// tlsIdentityVerifier verifies an IP with a TLS handshake
+type tlsIdentityVerifier struct {
+	rootCAs    *x509.CertPool
+	serverName string
+	port       int
+	timeout    time.Duration
+}
+
+// VerifyIdentity implements IdentityVerifier
+func (v *tlsIdentityVerifier) VerifyIdentity(ctx context.Context, ip, hostname string) error {
+	ctx, cancel := context.WithTimeout(ctx, v.timeout)
+	defer cancel()
+
+	serverName := v.serverName
+	if len(serverName) == 0 {
+		serverName = strings.TrimSuffix(hostname, ".")
+	}
+	// the handshake fails unless the chain is signed by the CA and the leaf carries the server name as SAN
+	dialer := &tls.Dialer{Config: &tls.Config{
+		RootCAs:    v.rootCAs,
+		ServerName: serverName,
+		MinVersion: tls.VersionTLS12,
+	}}
+	conn, err := dialer.DialContext(ctx, "tcp", net.JoinHostPort(ip, strconv.Itoa(v.port)))
+	if err != nil {
+		return err
+	}
+	return conn.Close()
+}
+
+// verifyIdentities verifies the TLS identity of the resolved IPs in parallel and returns the verified ones. IPs which
+// fail the verification are never advertised.
+func (c *AWSLBReadvertiserController) verifyIdentities(ctx context.Context, t *target, ips []string) []string {
+	if c.options.IdentityVerifier == nil {
+		return ips
+	}
+
+	var (
+		wg     sync.WaitGroup
+		lock   sync.Mutex
+		failed = map[string]error{}
+	)
+	for _, ip := range ips {
+		wg.Add(1)
+		go func(ip string) {
+			defer wg.Done()
+			err := c.options.IdentityVerifier.VerifyIdentity(ctx, ip, t.hostnameOf(ip))
+			recordIdentityVerification(t, err)
+			if err != nil {
+				lock.Lock()
+				failed[ip] = err
+				lock.Unlock()
+			}
+		}(ip)
+	}
+	wg.Wait()
+
+	unverifiedIPs.WithLabelValues(t.Key()).Set(float64(len(failed)))
+	if len(failed) == 0 {
+		return ips
+	}
+
+	var verified []string
+	for _, ip := range ips {
+		if _, ok := failed[ip]; !ok {
+			verified = append(verified, ip)
+		}
+	}
+	for _, ip := range sets.StringKeySet(failed).List() {
+		t.log.Warnf("TLS identity verification of IP %s failed, not advertising it: %v", ip, failed[ip])
+		c.recordEvent(t, corev1.EventTypeWarning, EventReasonIdentityVerificationFailed, "Not advertising IP %s of %s: %v", ip, t.hostnameOf(ip), failed[ip])
+	}
+	return verified
+}
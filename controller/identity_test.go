@@ -0,0 +1,125 @@
+// SPDX-FileCopyrightText: 2020 SAP SE or an SAP affiliate company and Gardener contributors
+//
+// SPDX-License-Identifier: Apache-2.0
+
+package controller
+
+import (
+	"context"
+	"encoding/pem"
+	"errors"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"time"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	k8sinformers "k8s.io/client-go/informers"
+	"k8s.io/client-go/kubernetes/fake"
+	"k8s.io/client-go/tools/record"
+)
+
+// rejectingVerifier fails the verification of the given IPs
+type rejectingVerifier map[string]bool
+
+func (v rejectingVerifier) VerifyIdentity(_ context.Context, ip, _ string) error {
+	if v[ip] {
+		return errors.New("x509: certificate signed by unknown authority")
+	}
+	return nil
+}
+
+var _ = Describe("IdentityVerifier", func() {
+	var (
+		server *httptest.Server
+		dir    string
+		caFile string
+		port   int
+	)
+
+	BeforeEach(func() {
+		server = httptest.NewTLSServer(http.NotFoundHandler())
+		port = server.Listener.Addr().(*net.TCPAddr).Port
+
+		var err error
+		dir, err = os.MkdirTemp("", "identity")
+		Expect(err).To(BeNil())
+		caFile = filepath.Join(dir, "ca.crt")
+		Expect(os.WriteFile(caFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: server.Certificate().Raw}), 0600)).To(Succeed())
+	})
+
+	AfterEach(func() {
+		server.Close()
+		Expect(os.RemoveAll(dir)).To(Succeed())
+	})
+
+	// the test certificate is valid for example.com
+	It("should accept an IP serving a certificate for the hostname it was resolved from", func() {
+		verifier, err := NewIdentityVerifier(IdentityOptions{CAFile: caFile, Port: port, Timeout: time.Second})
+		Expect(err).To(BeNil())
+		Expect(verifier.VerifyIdentity(context.TODO(), "127.0.0.1", "example.com.")).To(Succeed())
+		Expect(verifier.VerifyIdentity(context.TODO(), "127.0.0.1", "elb.example.org.")).NotTo(Succeed())
+	})
+
+	It("should require the configured server name instead of the hostname", func() {
+		verifier, err := NewIdentityVerifier(IdentityOptions{CAFile: caFile, ServerName: "example.com", Port: port, Timeout: time.Second})
+		Expect(err).To(BeNil())
+		Expect(verifier.VerifyIdentity(context.TODO(), "127.0.0.1", "elb.example.org.")).To(Succeed())
+
+		verifier, err = NewIdentityVerifier(IdentityOptions{CAFile: caFile, ServerName: "api.example.org", Port: port, Timeout: time.Second})
+		Expect(err).To(BeNil())
+		Expect(verifier.VerifyIdentity(context.TODO(), "127.0.0.1", "example.com.")).NotTo(Succeed())
+	})
+
+	It("should be disabled without a CA file", func() {
+		verifier, err := NewIdentityVerifier(IdentityOptions{})
+		Expect(err).To(BeNil())
+		Expect(verifier).To(BeNil())
+		Expect(IdentityOptions{}.Validate()).To(Succeed())
+		Expect(IdentityOptions{CAFile: caFile, Port: 443}.Validate()).NotTo(Succeed())
+	})
+})
+
+var _ = Describe("#verifyIdentities", func() {
+	var (
+		fakeClient *fake.Clientset
+		recorder   *record.FakeRecorder
+		resolver   staticResolver
+		controller *AWSLBReadvertiserController
+	)
+
+	BeforeEach(func() {
+		fakeClient = fake.NewSimpleClientset()
+		sharedK8sInformerFactory := k8sinformers.NewSharedInformerFactory(fakeClient, time.Hour)
+		recorder = record.NewFakeRecorder(10)
+		resolver = staticResolver{}
+		controller = newTestController(fakeClient, sharedK8sInformerFactory, resolver, recorder, nil, Options{IdentityVerifier: rejectingVerifier{"6.6.6.6": true}})
+	})
+
+	AfterEach(func() {
+		controller.queue.ShutDown()
+	})
+
+	It("should not advertise IPs failing the verification", func() {
+		resolver["elb.example.com."] = []string{"1.1.1.1", "6.6.6.6"}
+		Expect(controller.reconcileTarget(context.TODO(), firstTarget(controller))).To(Succeed())
+
+		endpoint, err := fakeClient.CoreV1().Endpoints(metav1.NamespaceDefault).Get(context.TODO(), "kubernetes", metav1.GetOptions{})
+		Expect(err).To(BeNil())
+		Expect(endpoint.Subsets[0].Addresses).To(Equal([]corev1.EndpointAddress{{IP: "1.1.1.1"}}))
+		Expect(recorder.Events).To(Receive(Equal(`Warning IdentityVerificationFailed Not advertising IP 6.6.6.6 of elb.example.com.: x509: certificate signed by unknown authority`)))
+	})
+
+	It("should keep the endpoint if no IP passes the verification", func() {
+		resolver["elb.example.com."] = []string{"6.6.6.6"}
+		Expect(controller.reconcileTarget(context.TODO(), firstTarget(controller))).NotTo(Succeed())
+
+		_, err := fakeClient.CoreV1().Endpoints(metav1.NamespaceDefault).Get(context.TODO(), "kubernetes", metav1.GetOptions{})
+		Expect(err).NotTo(BeNil())
+	})
+})
@@ -0,0 +1,169 @@
+// SPDX-FileCopyrightText: 2020 SAP SE or an SAP affiliate company and Gardener contributors
+//
+// SPDX-License-Identifier: Apache-2.0
+
+package controller
+
+import (
+	"encoding/json"
+	"fmt"
+	"net"
+	"strings"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/util/sets"
+)
+
+const (
+	// IPRangesModeEnforce drops resolved IPs outside of the AWS IP ranges
+	IPRangesModeEnforce = "enforce"
+	// IPRangesModeAudit only logs resolved IPs outside of the AWS IP ranges and advertises them anyway
+	IPRangesModeAudit = "audit"
+
+	// IPRangesConfigMapKey is the key of the ip-ranges.json document in a ConfigMap
+	IPRangesConfigMapKey = "ip-ranges.json"
+)
+
+// IPRangesOptions configure the validation of the resolved IPs against the IP ranges published by AWS
+type IPRangesOptions struct {
+	// File is the path of an ip-ranges.json document
+	File string
+	// ConfigMap is the <namespace>/<name> of a ConfigMap carrying an ip-ranges.json document at IPRangesConfigMapKey
+	ConfigMap string
+	// Regions restricts the ranges to the given regions, e.g. eu-west-1, all regions are used if empty
+	Regions []string
+	// Services restricts the ranges to the given services, e.g. EC2 or AMAZON, all services are used if empty
+	Services []string
+	// Mode is one of IPRangesModeEnforce or IPRangesModeAudit
+	Mode string
+}
+
+// Enabled returns whether the resolved IPs are validated
+func (o IPRangesOptions) Enabled() bool {
+	return len(o.File) != 0 || len(o.ConfigMap) != 0
+}
+
+// Validate checks that the IP ranges options are consistent
+func (o IPRangesOptions) Validate() error {
+	if !o.Enabled() {
+		return nil
+	}
+	if len(o.File) != 0 && len(o.ConfigMap) != 0 {
+		return fmt.Errorf("only one of an IP ranges file or ConfigMap can be given")
+	}
+	if len(o.ConfigMap) != 0 {
+		if parts := strings.Split(o.ConfigMap, "/"); len(parts) != 2 || len(parts[0]) == 0 || len(parts[1]) == 0 {
+			return fmt.Errorf("IP ranges ConfigMap must be given as <namespace>/<name>")
+		}
+	}
+	if o.Mode != IPRangesModeEnforce && o.Mode != IPRangesModeAudit {
+		return fmt.Errorf("IP ranges mode must be one of %q or %q", IPRangesModeEnforce, IPRangesModeAudit)
+	}
+	return nil
+}
+
+// ipRangesDocument is the format of https://ip-ranges.amazonaws.com/ip-ranges.json
+type ipRangesDocument struct {
+	Prefixes []struct {
+		IPPrefix string `json:"ip_prefix"`
+		Region   string `json:"region"`
+		Service  string `json:"service"`
+	} `json:"prefixes"`
+	IPv6Prefixes []struct {
+		IPv6Prefix string `json:"ipv6_prefix"`
+		Region     string `json:"region"`
+		Service    string `json:"service"`
+	} `json:"ipv6_prefixes"`
+}
+
+// IPRanges are the AWS IP ranges the resolved IPs are validated against
+type IPRanges struct {
+	networks []*net.IPNet
+	audit    bool
+}
+
+// NewIPRanges parses the given ip-ranges.json document and keeps the prefixes of the regions and services of the
+// options
+func NewIPRanges(data []byte, options IPRangesOptions) (*IPRanges, error) {
+	var document ipRangesDocument
+	if err := json.Unmarshal(data, &document); err != nil {
+		return nil, fmt.Errorf("invalid IP ranges document: %v", err)
+	}
+
+	regions, services := sets.NewString(options.Regions...), sets.NewString(options.Services...)
+	matches := func(region, service string) bool {
+		return (regions.Len() == 0 || regions.Has(region)) && (services.Len() == 0 || services.Has(service))
+	}
+
+	ranges := &IPRanges{audit: options.Mode == IPRangesModeAudit}
+	add := func(prefix string) error {
+		_, network, err := net.ParseCIDR(prefix)
+		if err != nil {
+			return fmt.Errorf("invalid IP ranges document: %v", err)
+		}
+		ranges.networks = append(ranges.networks, network)
+		return nil
+	}
+	for _, p := range document.Prefixes {
+		if matches(p.Region, p.Service) {
+			if err := add(p.IPPrefix); err != nil {
+				return nil, err
+			}
+		}
+	}
+	for _, p := range document.IPv6Prefixes {
+		if matches(p.Region, p.Service) {
+			if err := add(p.IPv6Prefix); err != nil {
+				return nil, err
+			}
+		}
+	}
+	if len(ranges.networks) == 0 {
+		return nil, fmt.Errorf("the IP ranges document contains no prefix of regions %v and services %v", options.Regions, options.Services)
+	}
+	return ranges, nil
+}
+
+// Contains returns whether the given IP is part of one of the ranges
+func (r *IPRanges) Contains(ip string) bool {
+	parsed := net.ParseIP(ip)
+	if parsed == nil {
+		return false
+	}
+	for _, network := range r.networks {
+		if network.Contains(parsed) {
+			return true
+		}
+	}
+	return false
+}
+
+// validateIPRanges returns the resolved IPs which are part of the AWS IP ranges. In audit mode all IPs are returned
+// and the ones outside of the ranges are only logged.
+func (c *AWSLBReadvertiserController) validateIPRanges(t *target, ips []string) []string {
+	ranges := c.options.IPRanges
+	if ranges == nil {
+		return ips
+	}
+
+	var valid, outside []string
+	for _, ip := range ips {
+		if ranges.Contains(ip) {
+			valid = append(valid, ip)
+		} else {
+			outside = append(outside, ip)
+		}
+	}
+	outsideIPRanges.WithLabelValues(t.Key()).Set(float64(len(outside)))
+	if len(outside) == 0 {
+		return ips
+	}
+
+	if ranges.audit {
+		t.log.Warnf("IPs %v are outside of the AWS IP ranges, advertising them anyway in audit mode", outside)
+		return ips
+	}
+	t.log.Warnf("IPs %v are outside of the AWS IP ranges, not advertising them", outside)
+	c.recordEvent(t, corev1.EventTypeWarning, EventReasonOutsideIPRanges, "Not advertising IPs %v which are outside of the AWS IP ranges", outside)
+	return valid
+}
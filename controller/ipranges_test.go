@@ -0,0 +1,120 @@
+// SPDX-FileCopyrightText: 2020 SAP SE or an SAP affiliate company and Gardener contributors
+//
+// SPDX-License-Identifier: Apache-2.0
+
+package controller
+
+import (
+	"context"
+	"time"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	k8sinformers "k8s.io/client-go/informers"
+	"k8s.io/client-go/kubernetes/fake"
+	"k8s.io/client-go/tools/record"
+)
+
+const ipRangesDocumentJSON = `{
+  "syncToken": "1700000000",
+  "createDate": "2023-11-14-22-13-20",
+  "prefixes": [
+    {"ip_prefix": "3.5.140.0/22", "region": "ap-northeast-2", "service": "AMAZON", "network_border_group": "ap-northeast-2"},
+    {"ip_prefix": "52.94.0.0/22", "region": "eu-west-1", "service": "AMAZON", "network_border_group": "eu-west-1"},
+    {"ip_prefix": "52.95.0.0/16", "region": "eu-west-1", "service": "EC2", "network_border_group": "eu-west-1"}
+  ],
+  "ipv6_prefixes": [
+    {"ipv6_prefix": "2a05:d018::/36", "region": "eu-west-1", "service": "EC2", "network_border_group": "eu-west-1"}
+  ]
+}`
+
+var _ = Describe("IPRanges", func() {
+	It("should contain the IPs of the prefixes of the given regions and services", func() {
+		ranges, err := NewIPRanges([]byte(ipRangesDocumentJSON), IPRangesOptions{Regions: []string{"eu-west-1"}, Services: []string{"EC2"}})
+		Expect(err).To(BeNil())
+
+		Expect(ranges.Contains("52.95.1.1")).To(BeTrue())
+		Expect(ranges.Contains("2a05:d018::1")).To(BeTrue())
+		Expect(ranges.Contains("52.94.0.1")).To(BeFalse())
+		Expect(ranges.Contains("3.5.140.1")).To(BeFalse())
+		Expect(ranges.Contains("not-an-ip")).To(BeFalse())
+	})
+
+	It("should use all prefixes without regions and services", func() {
+		ranges, err := NewIPRanges([]byte(ipRangesDocumentJSON), IPRangesOptions{})
+		Expect(err).To(BeNil())
+		Expect(ranges.Contains("3.5.140.1")).To(BeTrue())
+	})
+
+	It("should fail if no prefix matches", func() {
+		_, err := NewIPRanges([]byte(ipRangesDocumentJSON), IPRangesOptions{Regions: []string{"us-east-1"}})
+		Expect(err).NotTo(BeNil())
+		_, err = NewIPRanges([]byte(`{"prefixes": [{"ip_prefix": "no-cidr"}]}`), IPRangesOptions{})
+		Expect(err).NotTo(BeNil())
+	})
+
+	It("should validate the options", func() {
+		Expect(IPRangesOptions{}.Validate()).To(Succeed())
+		Expect(IPRangesOptions{File: "ip-ranges.json", Mode: IPRangesModeAudit}.Validate()).To(Succeed())
+		Expect(IPRangesOptions{ConfigMap: "kube-system/ip-ranges", Mode: IPRangesModeEnforce}.Validate()).To(Succeed())
+		Expect(IPRangesOptions{File: "ip-ranges.json", ConfigMap: "kube-system/ip-ranges", Mode: IPRangesModeEnforce}.Validate()).NotTo(Succeed())
+		Expect(IPRangesOptions{ConfigMap: "ip-ranges", Mode: IPRangesModeEnforce}.Validate()).NotTo(Succeed())
+		Expect(IPRangesOptions{File: "ip-ranges.json", Mode: "warn"}.Validate()).NotTo(Succeed())
+	})
+})
+
+var _ = Describe("#validateIPRanges", func() {
+	var (
+		fakeClient *fake.Clientset
+		recorder   *record.FakeRecorder
+		resolver   staticResolver
+		controller *AWSLBReadvertiserController
+	)
+
+	newController := func(mode string) {
+		ranges, err := NewIPRanges([]byte(ipRangesDocumentJSON), IPRangesOptions{Regions: []string{"eu-west-1"}, Mode: mode})
+		Expect(err).To(BeNil())
+
+		fakeClient = fake.NewSimpleClientset()
+		sharedK8sInformerFactory := k8sinformers.NewSharedInformerFactory(fakeClient, time.Hour)
+		recorder = record.NewFakeRecorder(10)
+		resolver = staticResolver{}
+		controller = newTestController(fakeClient, sharedK8sInformerFactory, resolver, recorder, nil, Options{IPRanges: ranges})
+	}
+
+	AfterEach(func() {
+		controller.queue.ShutDown()
+	})
+
+	advertisedIPs := func() []corev1.EndpointAddress {
+		endpoint, err := fakeClient.CoreV1().Endpoints(metav1.NamespaceDefault).Get(context.TODO(), "kubernetes", metav1.GetOptions{})
+		Expect(err).To(BeNil())
+		return endpoint.Subsets[0].Addresses
+	}
+
+	It("should drop IPs outside of the ranges in enforce mode", func() {
+		newController(IPRangesModeEnforce)
+		resolver["elb.example.com."] = []string{"52.95.1.1", "6.6.6.6"}
+		Expect(controller.reconcileTarget(context.TODO(), firstTarget(controller))).To(Succeed())
+
+		Expect(advertisedIPs()).To(Equal([]corev1.EndpointAddress{{IP: "52.95.1.1"}}))
+		Expect(recorder.Events).To(Receive(Equal(`Warning OutsideIPRanges Not advertising IPs [6.6.6.6] which are outside of the AWS IP ranges`)))
+	})
+
+	It("should fail if no IP is part of the ranges in enforce mode", func() {
+		newController(IPRangesModeEnforce)
+		resolver["elb.example.com."] = []string{"6.6.6.6"}
+		Expect(controller.reconcileTarget(context.TODO(), firstTarget(controller))).NotTo(Succeed())
+	})
+
+	It("should advertise IPs outside of the ranges in audit mode", func() {
+		newController(IPRangesModeAudit)
+		resolver["elb.example.com."] = []string{"52.95.1.1", "6.6.6.6"}
+		Expect(controller.reconcileTarget(context.TODO(), firstTarget(controller))).To(Succeed())
+
+		Expect(advertisedIPs()).To(Equal([]corev1.EndpointAddress{{IP: "52.95.1.1"}, {IP: "6.6.6.6"}}))
+		Expect(recorder.Events).NotTo(Receive(ContainSubstring("OutsideIPRanges")))
+	})
+})
@@ -0,0 +1,295 @@
+// SPDX-FileCopyrightText: 2020 SAP SE or an SAP affiliate company and Gardener contributors
+//
+// SPDX-License-Identifier: Apache-2.0
+
+package controller
+
+import (
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+const (
+	metricsNamespace = "aws_lb_readvertiser"
+
+	resultSuccess   = "success"
+	resultError     = "error"
+	resultSuspended = "suspended"
+
+	resourceEndpoints     = "endpoints"
+	resourceEndpointSlice = "endpointslice"
+
+	operationCreate = "create"
+	operationPatch  = "patch"
+	operationUpdate = "update"
+	operationApply  = "apply"
+	operationDelete = "delete"
+)
+
+var (
+	buildInfo = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: metricsNamespace,
+		Name:      "build_info",
+		Help:      "Build information of the readvertiser, the value is always 1.",
+	}, []string{"version"})
+
+	dnsLookupDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: metricsNamespace,
+		Name:      "dns_lookup_duration_seconds",
+		Help:      "Duration of the DNS lookups of the load balancer hostnames.",
+		Buckets:   []float64{0.001, 0.005, 0.01, 0.025, 0.05, 0.1, 0.25, 0.5, 1, 2.5, 5, 10},
+	}, []string{"target", "hostname", "result"})
+
+	dnsLookups = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: metricsNamespace,
+		Name:      "dns_lookups_total",
+		Help:      "Number of DNS lookups of the load balancer hostnames by result.",
+	}, []string{"target", "hostname", "result"})
+
+	resolvedIPs = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: metricsNamespace,
+		Name:      "resolved_ips",
+		Help:      "Number of IPs the hostnames of a target resolved to in the last successful lookup.",
+	}, []string{"target"})
+
+	dnsTTL = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: metricsNamespace,
+		Name:      "dns_ttl_seconds",
+		Help:      "Minimum TTL of the records of the last successful lookup of a target, 0 if the resolver does not expose TTLs.",
+	}, []string{"target"})
+
+	aggregatedIPs = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: metricsNamespace,
+		Name:      "aggregated_ips",
+		Help:      "Number of IPs of a target returned by any lookup within the aggregation window.",
+	}, []string{"target"})
+
+	dampingPending = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: metricsNamespace,
+		Name:      "damping_pending",
+		Help:      "Whether the last lookup of a target returned a candidate set of IPs which is not advertised yet because of damping (1) or not (0).",
+	}, []string{"target"})
+
+	dampingCandidateObservations = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: metricsNamespace,
+		Name:      "damping_candidate_observations",
+		Help:      "Number of recent lookups of a target which returned the set of IPs of the last lookup.",
+	}, []string{"target"})
+
+	retainedIPs = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: metricsNamespace,
+		Name:      "retained_ips",
+		Help:      "Number of IPs of a target which disappeared from DNS and are retained for the removal grace period.",
+	}, []string{"target"})
+
+	probes = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: metricsNamespace,
+		Name:      "probes_total",
+		Help:      "Number of probes of the resolved IPs of a target by result.",
+	}, []string{"target", "result"})
+
+	failingIPs = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: metricsNamespace,
+		Name:      "probe_failing_ips",
+		Help:      "Number of IPs of a target which failed their last probe and are advertised as not ready.",
+	}, []string{"target"})
+
+	filteredAddresses = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: metricsNamespace,
+		Name:      "filtered_addresses_total",
+		Help:      "Number of resolved addresses of a target which were not advertised by the reason they were filtered for.",
+	}, []string{"target", "reason"})
+
+	outsideIPRanges = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: metricsNamespace,
+		Name:      "outside_ip_ranges_ips",
+		Help:      "Number of resolved IPs of a target of the last lookup which are outside of the AWS IP ranges.",
+	}, []string{"target"})
+
+	identityVerifications = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: metricsNamespace,
+		Name:      "identity_verifications_total",
+		Help:      "Number of TLS identity verifications of the resolved IPs of a target by result.",
+	}, []string{"target", "result"})
+
+	unverifiedIPs = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: metricsNamespace,
+		Name:      "unverified_ips",
+		Help:      "Number of resolved IPs of a target which failed the TLS identity verification of the last lookup and are not advertised.",
+	}, []string{"target"})
+
+	lastKnownGoodAge = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: metricsNamespace,
+		Name:      "last_known_good_age_seconds",
+		Help:      "Age of the last known good addresses of a target the last failed resolution fell back to, zero after a successful resolution.",
+	}, []string{"target"})
+
+	endpointOverwrites = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: metricsNamespace,
+		Name:      "endpoint_overwrites_total",
+		Help:      "Number of times the addresses written to the endpoint of a target were overwritten, by the field manager of the overwrite.",
+	}, []string{"target", "manager"})
+
+	endpointFight = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: metricsNamespace,
+		Name:      "endpoint_fight",
+		Help:      "Whether the endpoint of a target is fought over by another writer (1) or not (0).",
+	}, []string{"target"})
+
+	endpointWriteConflicts = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: metricsNamespace,
+		Name:      "endpoint_write_conflicts_total",
+		Help:      "Number of writes of the managed endpoint objects which conflicted with a concurrent change and were retried with the current object.",
+	}, []string{"target", "resource"})
+
+	endpointWrites = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: metricsNamespace,
+		Name:      "endpoint_writes_total",
+		Help:      "Number of create, patch, update, apply and delete requests for the managed endpoint objects by result.",
+	}, []string{"target", "resource", "operation", "result"})
+
+	reconciles = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: metricsNamespace,
+		Name:      "reconciles_total",
+		Help:      "Number of reconciles of a target by result.",
+	}, []string{"target", "result"})
+
+	endpointInSync = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: metricsNamespace,
+		Name:      "endpoint_in_sync",
+		Help:      "Whether the managed endpoint objects of a target matched the resolved IPs in the last reconcile (1) or not (0).",
+	}, []string{"target"})
+
+	lastSuccessfulReconcile = &reconcileAgeCollector{
+		desc: prometheus.NewDesc(
+			prometheus.BuildFQName(metricsNamespace, "", "seconds_since_last_successful_reconcile"),
+			"Seconds since the last successful reconcile of a target.",
+			[]string{"target"}, nil,
+		),
+		lastSuccess: map[string]time.Time{},
+	}
+)
+
+func init() {
+	prometheus.MustRegister(
+		buildInfo,
+		dnsLookupDuration,
+		dnsLookups,
+		resolvedIPs,
+		dnsTTL,
+		aggregatedIPs,
+		dampingPending,
+		dampingCandidateObservations,
+		retainedIPs,
+		probes,
+		failingIPs,
+		filteredAddresses,
+		outsideIPRanges,
+		identityVerifications,
+		unverifiedIPs,
+		lastKnownGoodAge,
+		endpointOverwrites,
+		endpointFight,
+		endpointWriteConflicts,
+		endpointWrites,
+		reconciles,
+		endpointInSync,
+		lastSuccessfulReconcile,
+	)
+}
+
+// SetBuildInfo publishes the version of the readvertiser as metric
+func SetBuildInfo(version string) {
+	buildInfo.WithLabelValues(version).Set(1)
+}
+
+// reconcileAgeCollector computes the time since the last successful reconcile of every target at scrape time
+type reconcileAgeCollector struct {
+	desc *prometheus.Desc
+
+	lock        sync.Mutex
+	lastSuccess map[string]time.Time
+}
+
+// Describe implements prometheus.Collector
+func (r *reconcileAgeCollector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- r.desc
+}
+
+// Collect implements prometheus.Collector
+func (r *reconcileAgeCollector) Collect(ch chan<- prometheus.Metric) {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+
+	for target, last := range r.lastSuccess {
+		ch <- prometheus.MustNewConstMetric(r.desc, prometheus.GaugeValue, time.Since(last).Seconds(), target)
+	}
+}
+
+func (r *reconcileAgeCollector) observe(target string, t time.Time) {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+
+	r.lastSuccess[target] = t
+}
+
+func resultLabel(err error) string {
+	if err != nil {
+		return resultError
+	}
+	return resultSuccess
+}
+
+// recordDNSLookup records the duration and result of a lookup of one hostname of a target
+func recordDNSLookup(t *target, hostname string, start time.Time, err error) {
+	result := resultLabel(err)
+	dnsLookupDuration.WithLabelValues(t.Key(), hostname, result).Observe(time.Since(start).Seconds())
+	dnsLookups.WithLabelValues(t.Key(), hostname, result).Inc()
+}
+
+// recordDampingCandidate records whether the addresses of the last lookup are held back and how often they were seen
+func recordDampingCandidate(t *target, pending bool, observations int) {
+	value := 0.0
+	if pending {
+		value = 1
+	}
+	dampingPending.WithLabelValues(t.Key()).Set(value)
+	dampingCandidateObservations.WithLabelValues(t.Key()).Set(float64(observations))
+}
+
+// recordProbe records the result of a probe of an IP of a target
+func recordProbe(t *target, err error) {
+	probes.WithLabelValues(t.Key(), resultLabel(err)).Inc()
+}
+
+// recordIdentityVerification records the result of a TLS identity verification of an IP of a target
+func recordIdentityVerification(t *target, err error) {
+	identityVerifications.WithLabelValues(t.Key(), resultLabel(err)).Inc()
+}
+
+// recordEndpointWrite records a create, patch, update or apply request for a managed endpoint object
+func recordEndpointWrite(t *target, resource, operation string, err error) {
+	endpointWrites.WithLabelValues(t.Key(), resource, operation, resultLabel(err)).Inc()
+}
+
+// recordReconcile records the result of a reconcile of a target, a reconcile with suspended writes leaves the endpoint
+// out of sync without being successful. A successful reconcile only marks the endpoint in sync if it compared the
+// managed objects with the advertised addresses, otherwise the gauge is left as it is.
+func recordReconcile(t *target, err error) {
+	if writesSuspended(err) {
+		reconciles.WithLabelValues(t.Key(), resultSuspended).Inc()
+		endpointInSync.WithLabelValues(t.Key()).Set(0)
+		return
+	}
+	reconciles.WithLabelValues(t.Key(), resultLabel(err)).Inc()
+	if err != nil {
+		endpointInSync.WithLabelValues(t.Key()).Set(0)
+		return
+	}
+	if t.compared {
+		endpointInSync.WithLabelValues(t.Key()).Set(1)
+	}
+	lastSuccessfulReconcile.observe(t.Key(), time.Now())
+}
@@ -0,0 +1,87 @@
+// SPDX-FileCopyrightText: 2020 SAP SE or an SAP affiliate company and Gardener contributors
+//
+// SPDX-License-Identifier: Apache-2.0
+
+package controller
+
+import (
+	"context"
+	"time"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	k8sinformers "k8s.io/client-go/informers"
+	"k8s.io/client-go/kubernetes/fake"
+	"k8s.io/client-go/tools/record"
+)
+
+var _ = Describe("#metrics", func() {
+	It("should record lookups, writes and the reconcile result per target", func() {
+		var (
+			fakeClient               = fake.NewSimpleClientset()
+			sharedK8sInformerFactory = k8sinformers.NewSharedInformerFactory(fakeClient, time.Hour)
+			resolver                 = staticResolver{"metrics.example.com.": {"1.2.3.4", "5.6.7.8"}}
+		)
+		controller := newTestController(fakeClient, sharedK8sInformerFactory, resolver, record.NewFakeRecorder(100), []Target{
+			{Hostnames: []string{"metrics.example.com."}, EndpointNamespace: metav1.NamespaceDefault, EndpointName: "metrics", Ports: DefaultEndpointPorts(), RefreshPeriod: time.Second},
+			{Hostnames: []string{"unresolvable.example.com."}, EndpointNamespace: metav1.NamespaceDefault, EndpointName: "unresolvable", Ports: DefaultEndpointPorts(), RefreshPeriod: time.Second},
+		}, Options{})
+
+		controller.queue.Add("default/metrics")
+		controller.queue.Add("default/unresolvable")
+		Expect(controller.processNextWorkItem(context.TODO())).To(BeTrue())
+		Expect(controller.processNextWorkItem(context.TODO())).To(BeTrue())
+
+		Expect(testutil.ToFloat64(dnsLookups.WithLabelValues("default/metrics", "metrics.example.com.", resultSuccess))).To(Equal(1.0))
+		Expect(testutil.ToFloat64(resolvedIPs.WithLabelValues("default/metrics"))).To(Equal(2.0))
+		Expect(testutil.ToFloat64(endpointWrites.WithLabelValues("default/metrics", resourceEndpoints, operationCreate, resultSuccess))).To(Equal(1.0))
+		Expect(testutil.ToFloat64(reconciles.WithLabelValues("default/metrics", resultSuccess))).To(Equal(1.0))
+		Expect(testutil.ToFloat64(endpointInSync.WithLabelValues("default/metrics"))).To(Equal(1.0))
+
+		Expect(testutil.ToFloat64(dnsLookups.WithLabelValues("default/unresolvable", "unresolvable.example.com.", resultError))).To(Equal(1.0))
+		Expect(testutil.ToFloat64(reconciles.WithLabelValues("default/unresolvable", resultError))).To(Equal(1.0))
+		Expect(testutil.ToFloat64(endpointInSync.WithLabelValues("default/unresolvable"))).To(Equal(0.0))
+
+		Expect(testutil.CollectAndCount(lastSuccessfulReconcile)).To(BeNumerically(">=", 1))
+	})
+
+	It("should only mark the endpoint in sync after comparing it with the advertised addresses", func() {
+		var (
+			fakeClient               = fake.NewSimpleClientset()
+			sharedK8sInformerFactory = k8sinformers.NewSharedInformerFactory(fakeClient, time.Hour)
+			resolver                 = staticResolver{"damped.example.com.": {"1.2.3.4"}}
+		)
+		controller := newTestController(fakeClient, sharedK8sInformerFactory, resolver, record.NewFakeRecorder(100), []Target{
+			{Hostnames: []string{"damped.example.com."}, EndpointNamespace: metav1.NamespaceDefault, EndpointName: "damped", Ports: DefaultEndpointPorts(), RefreshPeriod: time.Second},
+		}, Options{Damping: DampingOptions{Mode: DampingModeConsensus, Observations: 2, Lookups: 2}})
+		t := firstTarget(controller)
+		inSync := func() float64 {
+			return testutil.ToFloat64(endpointInSync.WithLabelValues(t.Key()))
+		}
+		reconcile := func() {
+			controller.queue.Add(t.Key())
+			Expect(controller.processNextWorkItem(context.TODO())).To(BeTrue())
+		}
+
+		// the damping holds the addresses back, the endpoint is not compared
+		reconcile()
+		Expect(testutil.ToFloat64(reconciles.WithLabelValues(t.Key(), resultSuccess))).To(Equal(1.0))
+		Expect(inSync()).To(Equal(0.0))
+
+		reconcile()
+		Expect(testutil.ToFloat64(endpointWrites.WithLabelValues(t.Key(), resourceEndpoints, operationCreate, resultSuccess))).To(Equal(1.0))
+		Expect(inSync()).To(Equal(1.0))
+
+		// the cached endpoint predates its write, hence it is not compared
+		endpointInSync.WithLabelValues(t.Key()).Set(0)
+		endpoint, err := fakeClient.CoreV1().Endpoints(metav1.NamespaceDefault).Get(context.TODO(), "damped", metav1.GetOptions{})
+		Expect(err).To(BeNil())
+		Expect(sharedK8sInformerFactory.Core().V1().Endpoints().Informer().GetStore().Add(endpoint)).To(Succeed())
+		t.writes = map[string]pendingWrite{resourceEndpoints: {base: endpoint.ResourceVersion, written: endpoint.ResourceVersion + "1", at: time.Now()}}
+		reconcile()
+		Expect(testutil.ToFloat64(reconciles.WithLabelValues(t.Key(), resultSuccess))).To(Equal(3.0))
+		Expect(inSync()).To(Equal(0.0))
+	})
+})
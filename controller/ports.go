@@ -0,0 +1,105 @@
+// SPDX-FileCopyrightText: 2020 SAP SE or an SAP affiliate company and Gardener contributors
+//
+// SPDX-License-Identifier: Apache-2.0
+
+package controller
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/equality"
+	"k8s.io/apimachinery/pkg/util/sets"
+	"k8s.io/apimachinery/pkg/util/validation"
+)
+
+// DefaultEndpointPorts returns the ports advertised when no ports are configured, i.e. the kube-apiserver port 443
+func DefaultEndpointPorts() []corev1.EndpointPort {
+	return []corev1.EndpointPort{
+		{
+			Name:     "https",
+			Port:     443,
+			Protocol: corev1.ProtocolTCP,
+		},
+	}
+}
+
+// ParseEndpointPort parses a port of the form <name>:<port>[/<protocol>[/<appProtocol>]], e.g. "https:443/TCP".
+// The protocol defaults to TCP.
+func ParseEndpointPort(value string) (corev1.EndpointPort, error) {
+	var port corev1.EndpointPort
+
+	name, rest, found := strings.Cut(value, ":")
+	if !found {
+		return port, fmt.Errorf("port %q must be of the form <name>:<port>[/<protocol>[/<appProtocol>]]", value)
+	}
+	port.Name = name
+
+	parts := strings.SplitN(rest, "/", 3)
+	number, err := strconv.ParseInt(parts[0], 10, 32)
+	if err != nil {
+		return port, fmt.Errorf("port %q has an invalid port number: %v", value, err)
+	}
+	port.Port = int32(number)
+
+	port.Protocol = corev1.ProtocolTCP
+	if len(parts) > 1 && len(parts[1]) != 0 {
+		port.Protocol = corev1.Protocol(strings.ToUpper(parts[1]))
+	}
+	if len(parts) > 2 && len(parts[2]) != 0 {
+		appProtocol := parts[2]
+		port.AppProtocol = &appProtocol
+	}
+
+	return port, nil
+}
+
+// ValidateEndpointPorts checks that the given ports can be written to an Endpoints object and an EndpointSlice
+func ValidateEndpointPorts(ports []corev1.EndpointPort) error {
+	if len(ports) == 0 {
+		return fmt.Errorf("at least one port must be given")
+	}
+
+	names := sets.NewString()
+	for _, port := range ports {
+		if len(port.Name) == 0 && len(ports) > 1 {
+			return fmt.Errorf("port %d must be named as more than one port is given", port.Port)
+		}
+		if len(port.Name) != 0 {
+			if errs := validation.IsDNS1123Label(port.Name); len(errs) != 0 {
+				return fmt.Errorf("port name %q is invalid: %s", port.Name, strings.Join(errs, ", "))
+			}
+		}
+		if names.Has(port.Name) {
+			return fmt.Errorf("port name %q is given more than once", port.Name)
+		}
+		names.Insert(port.Name)
+
+		if errs := validation.IsValidPortNum(int(port.Port)); len(errs) != 0 {
+			return fmt.Errorf("port %q is invalid: %s", port.Name, strings.Join(errs, ", "))
+		}
+		switch port.Protocol {
+		case corev1.ProtocolTCP, corev1.ProtocolUDP, corev1.ProtocolSCTP:
+		default:
+			return fmt.Errorf("port %q has unsupported protocol %q", port.Name, port.Protocol)
+		}
+	}
+	return nil
+}
+
+// checkEndpointPortsAreStillValid checks if the ports of an endpoint subset match the desired ports regardless of
+// their order
+func checkEndpointPortsAreStillValid(currentPorts, desiredPorts []corev1.EndpointPort) bool {
+	return equality.Semantic.DeepEqual(sortedEndpointPorts(currentPorts), sortedEndpointPorts(desiredPorts))
+}
+
+func sortedEndpointPorts(ports []corev1.EndpointPort) []corev1.EndpointPort {
+	sorted := append([]corev1.EndpointPort(nil), ports...)
+	sort.Slice(sorted, func(i, j int) bool {
+		return sorted[i].Name < sorted[j].Name
+	})
+	return sorted
+}
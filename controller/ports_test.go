@@ -0,0 +1,54 @@
+// SPDX-FileCopyrightText: 2020 SAP SE or an SAP affiliate company and Gardener contributors
+//
+// SPDX-License-Identifier: Apache-2.0
+
+package controller
+
+import (
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/utils/pointer"
+)
+
+var _ = Describe("#ParseEndpointPort", func() {
+	It("should parse name, port, protocol and app protocol", func() {
+		port, err := ParseEndpointPort("konnectivity:8132/tcp/kubernetes.io/h2c")
+		Expect(err).To(BeNil())
+		Expect(port).To(Equal(corev1.EndpointPort{Name: "konnectivity", Port: 8132, Protocol: corev1.ProtocolTCP, AppProtocol: pointer.String("kubernetes.io/h2c")}))
+	})
+
+	It("should default the protocol to TCP", func() {
+		port, err := ParseEndpointPort("https:8443")
+		Expect(err).To(BeNil())
+		Expect(port).To(Equal(corev1.EndpointPort{Name: "https", Port: 8443, Protocol: corev1.ProtocolTCP}))
+	})
+
+	It("should reject malformed ports", func() {
+		_, err := ParseEndpointPort("8443")
+		Expect(err).NotTo(BeNil())
+		_, err = ParseEndpointPort("https:port")
+		Expect(err).NotTo(BeNil())
+	})
+})
+
+var _ = Describe("#ValidateEndpointPorts", func() {
+	It("should accept the default ports", func() {
+		Expect(ValidateEndpointPorts(DefaultEndpointPorts())).To(Succeed())
+	})
+
+	It("should reject duplicate, unnamed and out of range ports", func() {
+		Expect(ValidateEndpointPorts([]corev1.EndpointPort{{Name: "https", Port: 443, Protocol: corev1.ProtocolTCP}, {Name: "https", Port: 8443, Protocol: corev1.ProtocolTCP}})).NotTo(Succeed())
+		Expect(ValidateEndpointPorts([]corev1.EndpointPort{{Port: 443, Protocol: corev1.ProtocolTCP}, {Name: "sni", Port: 8443, Protocol: corev1.ProtocolTCP}})).NotTo(Succeed())
+		Expect(ValidateEndpointPorts([]corev1.EndpointPort{{Name: "https", Port: 70000, Protocol: corev1.ProtocolTCP}})).NotTo(Succeed())
+		Expect(ValidateEndpointPorts([]corev1.EndpointPort{{Name: "https", Port: 443, Protocol: "HTTP"}})).NotTo(Succeed())
+	})
+})
+
+var _ = Describe("#checkEndpointPortsAreStillValid", func() {
+	It("should ignore the order of the ports and detect changed ports", func() {
+		current := []corev1.EndpointPort{{Name: "https", Port: 443, Protocol: corev1.ProtocolTCP}, {Name: "sni", Port: 8443, Protocol: corev1.ProtocolTCP}}
+		Expect(checkEndpointPortsAreStillValid(current, []corev1.EndpointPort{current[1], current[0]})).To(BeTrue())
+		Expect(checkEndpointPortsAreStillValid(current, DefaultEndpointPorts())).To(BeFalse())
+	})
+})
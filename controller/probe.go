@@ -0,0 +1,216 @@
+// SPDX-FileCopyrightText: 2020 SAP SE or an SAP affiliate company and Gardener contributors
+//
+// SPDX-License-Identifier: Apache-2.0
+
+package controller
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"k8s.io/apimachinery/pkg/util/sets"
+)
+
+const (
+	// ProbeModeNone advertises all resolved IPs as ready
+	ProbeModeNone = "none"
+	// ProbeModeTCP advertises a resolved IP as ready if a TCP connection to it can be established
+	ProbeModeTCP = "tcp"
+	// ProbeModeHTTPS advertises a resolved IP as ready if an HTTPS request to it succeeds
+	ProbeModeHTTPS = "https"
+)
+
+// ProbeOptions configure how the resolved IPs are probed before they are advertised as ready
+type ProbeOptions struct {
+	// Mode is one of ProbeModeNone, ProbeModeTCP or ProbeModeHTTPS
+	Mode string
+	// Port is the port the IPs are probed on
+	Port int
+	// Path is requested in ProbeModeHTTPS, e.g. /readyz
+	Path string
+	// CAFile is the CA bundle the serving certificates are verified with in ProbeModeHTTPS, the system roots are used
+	// if empty
+	CAFile string
+	// Timeout is the timeout of a single probe
+	Timeout time.Duration
+}
+
+// Validate checks that the probe options are consistent
+func (o ProbeOptions) Validate() error {
+	switch o.Mode {
+	case "", ProbeModeNone:
+		return nil
+	case ProbeModeTCP, ProbeModeHTTPS:
+	default:
+		return fmt.Errorf("probe mode must be one of %q, %q or %q", ProbeModeNone, ProbeModeTCP, ProbeModeHTTPS)
+	}
+
+	if o.Port < 1 || o.Port > 65535 {
+		return fmt.Errorf("probe port must be between 1 and 65535")
+	}
+	if o.Mode == ProbeModeHTTPS && !strings.HasPrefix(o.Path, "/") {
+		return fmt.Errorf("probe path must start with /")
+	}
+	if o.Timeout <= 0 {
+		return fmt.Errorf("probe timeout must be positive")
+	}
+	return nil
+}
+
+// Prober checks whether a resolved IP of a load balancer is serving. The hostname is the load balancer name the IP was
+// resolved from, it is used for SNI and the verification of the serving certificate.
+type Prober interface {
+	Probe(ctx context.Context, ip, hostname string) error
+}
+
+// NewProber returns the Prober for the given options, nil if probing is disabled
+func NewProber(options ProbeOptions) (Prober, error) {
+	switch options.Mode {
+	case ProbeModeTCP:
+		return &tcpProber{port: options.Port, timeout: options.Timeout}, nil
+	case ProbeModeHTTPS:
+		tlsConfig := &tls.Config{MinVersion: tls.VersionTLS12}
+		if len(options.CAFile) != 0 {
+			pem, err := os.ReadFile(options.CAFile)
+			if err != nil {
+				return nil, fmt.Errorf("failed to read probe CA file: %v", err)
+			}
+			pool := x509.NewCertPool()
+			if !pool.AppendCertsFromPEM(pem) {
+				return nil, fmt.Errorf("probe CA file %q contains no certificates", options.CAFile)
+			}
+			tlsConfig.RootCAs = pool
+		}
+		return &httpsProber{
+			port:      options.Port,
+			path:      options.Path,
+			timeout:   options.Timeout,
+			tlsConfig: tlsConfig,
+		}, nil
+	default:
+		return nil, nil
+	}
+}
+
+// tcpProber probes an IP by establishing a TCP connection
+type tcpProber struct {
+	port    int
+	timeout time.Duration
+}
+
+// Probe implements Prober
+func (p *tcpProber) Probe(ctx context.Context, ip, _ string) error {
+	ctx, cancel := context.WithTimeout(ctx, p.timeout)
+	defer cancel()
+
+	var dialer net.Dialer
+	conn, err := dialer.DialContext(ctx, "tcp", net.JoinHostPort(ip, strconv.Itoa(p.port)))
+	if err != nil {
+		return err
+	}
+	return conn.Close()
+}
+
+// httpsProber probes an IP with an HTTPS GET request, the load balancer hostname is sent as SNI and Host header
+type httpsProber struct {
+	port      int
+	path      string
+	timeout   time.Duration
+	tlsConfig *tls.Config
+}
+
+// Probe implements Prober
+func (p *httpsProber) Probe(ctx context.Context, ip, hostname string) error {
+	ctx, cancel := context.WithTimeout(ctx, p.timeout)
+	defer cancel()
+
+	hostname = strings.TrimSuffix(hostname, ".")
+	tlsConfig := p.tlsConfig.Clone()
+	tlsConfig.ServerName = hostname
+
+	address := net.JoinHostPort(ip, strconv.Itoa(p.port))
+	client := &http.Client{
+		Transport: &http.Transport{
+			// the request must reach the probed IP, not whatever the hostname currently resolves to
+			DialContext: func(ctx context.Context, network, _ string) (net.Conn, error) {
+				var dialer net.Dialer
+				return dialer.DialContext(ctx, network, address)
+			},
+			TLSClientConfig:   tlsConfig,
+			DisableKeepAlives: true,
+		},
+		CheckRedirect: func(*http.Request, []*http.Request) error {
+			return http.ErrUseLastResponse
+		},
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, fmt.Sprintf("https://%s%s", net.JoinHostPort(hostname, strconv.Itoa(p.port)), p.path), nil)
+	if err != nil {
+		return err
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	_, _ = io.Copy(io.Discard, io.LimitReader(resp.Body, 4096))
+
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return fmt.Errorf("%s returned status %d", p.path, resp.StatusCode)
+	}
+	return nil
+}
+
+// probeAddresses probes the ready addresses in parallel and moves the failing ones to the not ready addresses
+func (c *AWSLBReadvertiserController) probeAddresses(ctx context.Context, t *target, addresses advertisedAddresses) advertisedAddresses {
+	if c.options.Prober == nil || len(addresses.ready) == 0 {
+		return addresses
+	}
+
+	var (
+		wg     sync.WaitGroup
+		lock   sync.Mutex
+		failed = sets.NewString()
+	)
+	for _, ip := range addresses.ready {
+		wg.Add(1)
+		go func(ip string) {
+			defer wg.Done()
+			err := c.options.Prober.Probe(ctx, ip, t.hostnameOf(ip))
+			recordProbe(t, err)
+			if err != nil {
+				t.log.Warnf("Probe of IP %s failed, advertising it as not ready: %v", ip, err)
+				lock.Lock()
+				failed.Insert(ip)
+				lock.Unlock()
+			}
+		}(ip)
+	}
+	wg.Wait()
+
+	failingIPs.WithLabelValues(t.Key()).Set(float64(failed.Len()))
+	if failed.Len() == 0 {
+		return addresses
+	}
+
+	probed := advertisedAddresses{
+		notReady: append(append([]string(nil), addresses.notReady...), failed.List()...),
+		retained: addresses.retained,
+	}
+	for _, ip := range addresses.ready {
+		if !failed.Has(ip) {
+			probed.ready = append(probed.ready, ip)
+		}
+	}
+	return probed
+}
@@ -0,0 +1,108 @@
+// SPDX-FileCopyrightText: 2020 SAP SE or an SAP affiliate company and Gardener contributors
+//
+// SPDX-License-Identifier: Apache-2.0
+
+package controller
+
+import (
+	"context"
+	"encoding/pem"
+	"errors"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strconv"
+	"time"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	k8sinformers "k8s.io/client-go/informers"
+	"k8s.io/client-go/kubernetes/fake"
+	"k8s.io/client-go/tools/record"
+)
+
+// failingProber fails the probes of the given IPs
+type failingProber map[string]bool
+
+func (p failingProber) Probe(_ context.Context, ip, _ string) error {
+	if p[ip] {
+		return errors.New("connection refused")
+	}
+	return nil
+}
+
+var _ = Describe("Prober", func() {
+	It("should probe an IP via TCP", func() {
+		listener, err := net.Listen("tcp", "127.0.0.1:0")
+		Expect(err).To(BeNil())
+		port := listener.Addr().(*net.TCPAddr).Port
+
+		prober, err := NewProber(ProbeOptions{Mode: ProbeModeTCP, Port: port, Timeout: time.Second})
+		Expect(err).To(BeNil())
+		Expect(prober.Probe(context.TODO(), "127.0.0.1", "elb.example.com.")).To(Succeed())
+
+		Expect(listener.Close()).To(Succeed())
+		Expect(prober.Probe(context.TODO(), "127.0.0.1", "elb.example.com.")).NotTo(Succeed())
+	})
+
+	It("should probe an IP via HTTPS with the hostname as SNI", func() {
+		var serverName, host string
+		server := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			serverName, host = r.TLS.ServerName, r.Host
+			if r.URL.Path != "/readyz" {
+				w.WriteHeader(http.StatusServiceUnavailable)
+			}
+		}))
+		server.StartTLS()
+		defer server.Close()
+		port := server.Listener.Addr().(*net.TCPAddr).Port
+
+		dir, err := os.MkdirTemp("", "probe")
+		Expect(err).To(BeNil())
+		defer os.RemoveAll(dir)
+		caFile := filepath.Join(dir, "ca.crt")
+		Expect(os.WriteFile(caFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: server.Certificate().Raw}), 0600)).To(Succeed())
+
+		// the test certificate is valid for example.com
+		prober, err := NewProber(ProbeOptions{Mode: ProbeModeHTTPS, Port: port, Path: "/readyz", CAFile: caFile, Timeout: time.Second})
+		Expect(err).To(BeNil())
+		Expect(prober.Probe(context.TODO(), "127.0.0.1", "example.com.")).To(Succeed())
+		Expect(serverName).To(Equal("example.com"))
+		Expect(host).To(Equal(net.JoinHostPort("example.com", strconv.Itoa(port))))
+
+		Expect(prober.Probe(context.TODO(), "127.0.0.1", "elb.example.org.")).NotTo(Succeed())
+
+		prober, err = NewProber(ProbeOptions{Mode: ProbeModeHTTPS, Port: port, Path: "/livez", CAFile: caFile, Timeout: time.Second})
+		Expect(err).To(BeNil())
+		Expect(prober.Probe(context.TODO(), "127.0.0.1", "example.com.")).To(MatchError(ContainSubstring("status 503")))
+	})
+
+	It("should validate the options", func() {
+		Expect(ProbeOptions{}.Validate()).To(Succeed())
+		Expect(ProbeOptions{Mode: ProbeModeTCP, Port: 443, Timeout: time.Second}.Validate()).To(Succeed())
+		Expect(ProbeOptions{Mode: "icmp"}.Validate()).NotTo(Succeed())
+		Expect(ProbeOptions{Mode: ProbeModeTCP, Port: 0, Timeout: time.Second}.Validate()).NotTo(Succeed())
+		Expect(ProbeOptions{Mode: ProbeModeHTTPS, Port: 443, Path: "readyz", Timeout: time.Second}.Validate()).NotTo(Succeed())
+	})
+})
+
+var _ = Describe("#probeAddresses", func() {
+	var controller *AWSLBReadvertiserController
+
+	AfterEach(func() {
+		controller.queue.ShutDown()
+	})
+
+	It("should advertise the IPs failing their probe as not ready", func() {
+		fakeClient := fake.NewSimpleClientset()
+		sharedK8sInformerFactory := k8sinformers.NewSharedInformerFactory(fakeClient, time.Hour)
+		controller = newTestController(fakeClient, sharedK8sInformerFactory, staticResolver{}, record.NewFakeRecorder(100), nil, Options{Prober: failingProber{"2.2.2.2": true}})
+		t := firstTarget(controller)
+
+		addresses := controller.probeAddresses(context.TODO(), t, advertisedAddresses{ready: []string{"1.1.1.1", "2.2.2.2"}, notReady: []string{"3.3.3.3"}})
+		Expect(addresses.ready).To(Equal([]string{"1.1.1.1"}))
+		Expect(addresses.notReady).To(Equal([]string{"3.3.3.3", "2.2.2.2"}))
+	})
+})
@@ -0,0 +1,148 @@
+// SPDX-FileCopyrightText: 2020 SAP SE or an SAP affiliate company and Gardener contributors
+//
+// SPDX-License-Identifier: Apache-2.0
+
+package controller
+
+import (
+	"fmt"
+	"math/rand"
+	"sync"
+	"time"
+)
+
+const (
+	// RefreshModeFixed resolves the hostnames of a target at its refresh period
+	RefreshModeFixed = "fixed"
+	// RefreshModeTTL resolves the hostnames of a target shortly before the minimum TTL of their records expires
+	RefreshModeTTL = "ttl"
+
+	// ttlRefreshFraction is the fraction of the TTL after which the hostnames are resolved again, so that the new
+	// answer is known before the old one expires
+	ttlRefreshFraction = 0.9
+)
+
+// RefreshOptions configure when the hostnames of the targets are resolved
+type RefreshOptions struct {
+	// Mode is RefreshModeFixed or RefreshModeTTL
+	Mode string
+	// MinInterval and MaxInterval bound the interval between two lookups in RefreshModeTTL
+	MinInterval, MaxInterval time.Duration
+	// Jitter is the maximum fraction by which an interval is shortened at random in RefreshModeTTL, so that many
+	// readvertisers resolving the same hostnames do not query in lock-step
+	Jitter float64
+}
+
+// Validate checks that the refresh options are consistent
+func (o RefreshOptions) Validate() error {
+	switch o.Mode {
+	case RefreshModeFixed:
+		if o.MinInterval < 0 {
+			return fmt.Errorf("minimum refresh interval must not be negative")
+		}
+		return nil
+	case RefreshModeTTL:
+	default:
+		return fmt.Errorf("refresh mode must be one of %q or %q", RefreshModeFixed, RefreshModeTTL)
+	}
+
+	if o.MinInterval <= 0 {
+		return fmt.Errorf("minimum refresh interval must be positive")
+	}
+	if o.MaxInterval < o.MinInterval {
+		return fmt.Errorf("maximum refresh interval must not be less than the minimum refresh interval")
+	}
+	if o.Jitter < 0 || o.Jitter >= 1 {
+		return fmt.Errorf("refresh jitter must be in [0, 1)")
+	}
+	return nil
+}
+
+// refreshState records whether a scheduled refresh of a target is due. It is set by the refresh tickers and timers and
+// consumed by the worker reconciling the target.
+type refreshState struct {
+	lock sync.Mutex
+	due  bool
+	// timer is the pending refresh in RefreshModeTTL, nil if none is pending
+	timer *time.Timer
+}
+
+// requestRefresh marks the refresh of the target as due
+func (t *target) requestRefresh() {
+	t.refresh.lock.Lock()
+	defer t.refresh.lock.Unlock()
+	t.refresh.due = true
+}
+
+// takeRefresh returns whether a refresh of the target is due and clears the request
+func (t *target) takeRefresh() bool {
+	t.refresh.lock.Lock()
+	defer t.refresh.lock.Unlock()
+	due := t.refresh.due
+	t.refresh.due = false
+	return due
+}
+
+// lookupDue returns whether a reconcile of the target at now resolves its hostnames. Scheduled refreshes, retries and
+// the first reconcile always do. Reconciles triggered by changes of the managed objects within the minimum refresh
+// interval after the last lookup write the addresses of that lookup again instead, so that watch traffic neither
+// bypasses the refresh schedule nor multiplies lookups, probes and identity verifications.
+func (c *AWSLBReadvertiserController) lookupDue(t *target, refresh bool, now time.Time) bool {
+	if refresh || t.lastLookup == nil || c.queue.NumRequeues(t.Key()) > 0 {
+		return true
+	}
+	return now.Sub(t.lastLookupAt) >= c.options.Refresh.MinInterval
+}
+
+// scheduleRefresh requests the refresh of the target after the given interval in RefreshModeTTL. A pending refresh is
+// kept, so that reconciles triggered by changes of the managed objects do not postpone it.
+func (c *AWSLBReadvertiserController) scheduleRefresh(t *target, after time.Duration) bool {
+	t.refresh.lock.Lock()
+	defer t.refresh.lock.Unlock()
+	if t.refresh.timer != nil {
+		return false
+	}
+	t.refresh.timer = time.AfterFunc(after, func() {
+		t.refresh.lock.Lock()
+		t.refresh.timer = nil
+		t.refresh.lock.Unlock()
+		c.enqueueRefresh(t)
+	})
+	return true
+}
+
+// refreshByTTL returns true if the targets are refreshed based on the TTL of their records
+func (c *AWSLBReadvertiserController) refreshByTTL() bool {
+	return c.options.Refresh.Mode == RefreshModeTTL
+}
+
+// nextRefresh returns the time until the hostnames of the target are resolved again in RefreshModeTTL. Without a
+// known TTL, e.g. with the system resolver, the refresh period of the target is used.
+func (c *AWSLBReadvertiserController) nextRefresh(t *target) time.Duration {
+	interval := t.RefreshPeriod
+	if t.ttl > 0 {
+		interval = time.Duration(float64(t.ttl) * ttlRefreshFraction)
+	}
+
+	if interval < c.options.Refresh.MinInterval {
+		interval = c.options.Refresh.MinInterval
+	}
+	if interval > c.options.Refresh.MaxInterval {
+		interval = c.options.Refresh.MaxInterval
+	}
+
+	// jitter only shortens the interval, so that the bounds are kept and the answer is never refreshed late
+	jittered := interval - time.Duration(rand.Float64()*c.options.Refresh.Jitter*float64(interval))
+	if jittered < c.options.Refresh.MinInterval {
+		jittered = c.options.Refresh.MinInterval
+	}
+	return jittered
+}
+
+// maxRefreshInterval returns the longest time the target may go without being refreshed
+func (c *AWSLBReadvertiserController) maxRefreshInterval(t *target) time.Duration {
+	if c.refreshByTTL() && c.options.Refresh.MaxInterval > t.RefreshPeriod {
+		return c.options.Refresh.MaxInterval
+	}
+	return t.RefreshPeriod
+}
@@ -0,0 +1,92 @@
+// SPDX-FileCopyrightText: 2020 SAP SE or an SAP affiliate company and Gardener contributors
+//
+// SPDX-License-Identifier: Apache-2.0
+
+package controller
+
+import (
+	"context"
+	"time"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	k8sinformers "k8s.io/client-go/informers"
+	"k8s.io/client-go/kubernetes/fake"
+	"k8s.io/client-go/tools/record"
+)
+
+// ttlResolver answers every lookup with the same address and TTL
+type ttlResolver time.Duration
+
+func (r ttlResolver) LookupHost(_ context.Context, _ string, _ IPFamily) ([]string, time.Duration, error) {
+	return []string{"1.2.3.4"}, time.Duration(r), nil
+}
+
+var _ = Describe("#RefreshOptions", func() {
+	It("should validate the options", func() {
+		Expect(RefreshOptions{Mode: RefreshModeFixed}.Validate()).To(Succeed())
+		Expect(RefreshOptions{Mode: RefreshModeTTL, MinInterval: time.Second, MaxInterval: time.Minute, Jitter: 0.1}.Validate()).To(Succeed())
+
+		Expect(RefreshOptions{Mode: "sometimes"}.Validate()).NotTo(Succeed())
+		Expect(RefreshOptions{Mode: RefreshModeTTL, MaxInterval: time.Minute}.Validate()).NotTo(Succeed())
+		Expect(RefreshOptions{Mode: RefreshModeTTL, MinInterval: time.Minute, MaxInterval: time.Second}.Validate()).NotTo(Succeed())
+		Expect(RefreshOptions{Mode: RefreshModeTTL, MinInterval: time.Second, MaxInterval: time.Minute, Jitter: 1}.Validate()).NotTo(Succeed())
+	})
+})
+
+var _ = Describe("#nextRefresh", func() {
+	var (
+		controller *AWSLBReadvertiserController
+		t          *target
+	)
+
+	newController := func(resolver Resolver, options RefreshOptions) {
+		fakeClient := fake.NewSimpleClientset()
+		sharedK8sInformerFactory := k8sinformers.NewSharedInformerFactory(fakeClient, time.Hour)
+		controller = newTestController(fakeClient, sharedK8sInformerFactory, resolver, record.NewFakeRecorder(100), []Target{
+			{Hostnames: []string{"elb.example.com."}, EndpointNamespace: metav1.NamespaceDefault, EndpointName: "kubernetes", Ports: DefaultEndpointPorts(), RefreshPeriod: 30 * time.Second},
+		}, Options{Refresh: options})
+		t = firstTarget(controller)
+	}
+
+	AfterEach(func() {
+		controller.queue.ShutDown()
+	})
+
+	It("should refresh shortly before the TTL expires within the bounds", func() {
+		newController(ttlResolver(0), RefreshOptions{Mode: RefreshModeTTL, MinInterval: 5 * time.Second, MaxInterval: 5 * time.Minute})
+
+		t.ttl = 60 * time.Second
+		Expect(controller.nextRefresh(t)).To(Equal(54 * time.Second))
+		t.ttl = time.Second
+		Expect(controller.nextRefresh(t)).To(Equal(5 * time.Second))
+		t.ttl = time.Hour
+		Expect(controller.nextRefresh(t)).To(Equal(5 * time.Minute))
+
+		// the refresh period is used if the resolver does not expose TTLs
+		t.ttl = 0
+		Expect(controller.nextRefresh(t)).To(Equal(30 * time.Second))
+	})
+
+	It("should only shorten the interval by the jitter", func() {
+		newController(ttlResolver(0), RefreshOptions{Mode: RefreshModeTTL, MinInterval: 5 * time.Second, MaxInterval: 5 * time.Minute, Jitter: 0.5})
+
+		t.ttl = 100 * time.Second
+		for i := 0; i < 100; i++ {
+			next := controller.nextRefresh(t)
+			Expect(next).To(BeNumerically(">=", 45*time.Second))
+			Expect(next).To(BeNumerically("<=", 90*time.Second))
+		}
+	})
+
+	It("should schedule the next refresh after a successful reconcile", func() {
+		newController(ttlResolver(time.Second), RefreshOptions{Mode: RefreshModeTTL, MinInterval: 50 * time.Millisecond, MaxInterval: 100 * time.Millisecond})
+
+		controller.queue.Add(t.Key())
+		Expect(controller.processNextWorkItem(context.TODO())).To(BeTrue())
+		Expect(t.ttl).To(Equal(time.Second))
+		Expect(controller.queue.Len()).To(Equal(0))
+		Eventually(controller.queue.Len).Should(Equal(1))
+	})
+})
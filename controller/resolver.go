@@ -0,0 +1,369 @@
+// SPDX-FileCopyrightText: 2020 SAP SE or an SAP affiliate company and Gardener contributors
+//
+// SPDX-License-Identifier: Apache-2.0
+
+package controller
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"strings"
+	"time"
+
+	"golang.org/x/net/dns/dnsmessage"
+)
+
+const (
+	// DNSTransportUDP sends DNS queries over UDP and falls back to TCP for truncated answers
+	DNSTransportUDP = "udp"
+	// DNSTransportTCP sends DNS queries over TCP only
+	DNSTransportTCP = "tcp"
+
+	defaultDNSPort = "53"
+	maxUDPSize     = 4096
+)
+
+// errMismatchingResponse is returned if a nameserver only answered with responses which do not match the query
+var errMismatchingResponse = errors.New("received mismatching DNS response")
+
+// IPFamily selects the address records which are resolved and advertised
+type IPFamily string
+
+const (
+	// IPFamilyIPv4 only resolves and advertises A records
+	IPFamilyIPv4 IPFamily = "ipv4"
+	// IPFamilyIPv6 only resolves and advertises AAAA records
+	IPFamilyIPv6 IPFamily = "ipv6"
+	// IPFamilyDual resolves and advertises both A and AAAA records
+	IPFamilyDual IPFamily = "dual"
+)
+
+// Resolver resolves the DNS name of a load balancer to its IP addresses of the given family. The returned TTL is
+// the minimum TTL of the records the addresses were resolved from, it is zero if the resolver cannot tell.
+type Resolver interface {
+	LookupHost(ctx context.Context, host string, family IPFamily) ([]string, time.Duration, error)
+}
+
+// systemResolver resolves hosts with the resolver configured for the process (e.g. /etc/resolv.conf)
+type systemResolver struct {
+	resolver *net.Resolver
+	timeout  time.Duration
+}
+
+// NewSystemResolver returns a Resolver backed by the resolver of the operating system.
+// A timeout of zero means that only the context limits the duration of a lookup.
+func NewSystemResolver(timeout time.Duration) Resolver {
+	return &systemResolver{
+		resolver: net.DefaultResolver,
+		timeout:  timeout,
+	}
+}
+
+// LookupHost returns the addresses of the given family of the given host, the system resolver does not expose TTLs
+func (r *systemResolver) LookupHost(ctx context.Context, host string, family IPFamily) ([]string, time.Duration, error) {
+	if r.timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, r.timeout)
+		defer cancel()
+	}
+
+	network := "ip"
+	switch family {
+	case IPFamilyIPv4:
+		network = "ip4"
+	case IPFamilyIPv6:
+		network = "ip6"
+	}
+
+	ips, err := r.resolver.LookupIP(ctx, network, host)
+	if err != nil {
+		return nil, 0, err
+	}
+	var addrs []string
+	for _, ip := range ips {
+		addrs = append(addrs, ip.String())
+	}
+	return addrs, 0, nil
+}
+
+// dnsResolver is a minimal DNS client which queries the configured nameservers directly, bypassing the
+// search path and ndots handling of the system resolver
+type dnsResolver struct {
+	nameservers []string
+	transport   string
+	timeout     time.Duration
+}
+
+// NewDNSResolver returns a Resolver which sends its queries directly to the given nameservers using the given
+// transport ("udp" or "tcp"). Nameservers are tried in order until one of them answers. A nameserver without
+// a port uses port 53. The timeout applies to every query sent to a nameserver, so that an unresponsive nameserver
+// does not use up the time of the following ones; a lookup of both A and AAAA records sends two queries.
+func NewDNSResolver(nameservers []string, transport string, timeout time.Duration) (Resolver, error) {
+	if len(nameservers) == 0 {
+		return nil, errors.New("at least one nameserver must be given")
+	}
+	if transport != DNSTransportUDP && transport != DNSTransportTCP {
+		return nil, fmt.Errorf("unsupported DNS transport %q, must be one of %q or %q", transport, DNSTransportUDP, DNSTransportTCP)
+	}
+
+	var servers []string
+	for _, ns := range nameservers {
+		if _, _, err := net.SplitHostPort(ns); err != nil {
+			ns = net.JoinHostPort(strings.Trim(ns, "[]"), defaultDNSPort)
+		}
+		servers = append(servers, ns)
+	}
+
+	return &dnsResolver{
+		nameservers: servers,
+		transport:   transport,
+		timeout:     timeout,
+	}, nil
+}
+
+// LookupHost returns the addresses of the given family of the given host and the minimum TTL of the answer records
+func (r *dnsResolver) LookupHost(ctx context.Context, host string, family IPFamily) ([]string, time.Duration, error) {
+	if !strings.HasSuffix(host, ".") {
+		host += "."
+	}
+	name, err := dnsmessage.NewName(host)
+	if err != nil {
+		return nil, 0, fmt.Errorf("invalid host name %q: %v", host, err)
+	}
+
+	var errs []string
+	for _, ns := range r.nameservers {
+		var (
+			addrs []string
+			ttl   time.Duration
+		)
+		for _, qtype := range queryTypes(family) {
+			answers, answerTTL, err := r.query(ctx, ns, name, qtype)
+			if err != nil {
+				addrs = nil
+				errs = append(errs, fmt.Sprintf("%s: %v", ns, err))
+				break
+			}
+			addrs = append(addrs, answers...)
+			ttl = minTTL(ttl, answerTTL)
+		}
+		if addrs != nil {
+			return addrs, ttl, nil
+		}
+		if ctx.Err() != nil {
+			break
+		}
+	}
+
+	if len(errs) == 0 {
+		return nil, 0, fmt.Errorf("no such host %q", host)
+	}
+	return nil, 0, fmt.Errorf("lookup of %q failed: %s", host, strings.Join(errs, "; "))
+}
+
+// minTTL returns the smaller of the given TTLs, a zero TTL is unknown and ignored
+func minTTL(a, b time.Duration) time.Duration {
+	if a == 0 || (b != 0 && b < a) {
+		return b
+	}
+	return a
+}
+
+// queryTypes returns the record types which are queried for the given family
+func queryTypes(family IPFamily) []dnsmessage.Type {
+	switch family {
+	case IPFamilyIPv4:
+		return []dnsmessage.Type{dnsmessage.TypeA}
+	case IPFamilyIPv6:
+		return []dnsmessage.Type{dnsmessage.TypeAAAA}
+	default:
+		return []dnsmessage.Type{dnsmessage.TypeA, dnsmessage.TypeAAAA}
+	}
+}
+
+// query sends a single question to the given nameserver and returns the matching addresses of the answer section
+// together with the minimum TTL of the answer records, including the CNAMEs the name was resolved through. The
+// timeout of the resolver bounds each query, the retry of a truncated answer via TCP is part of the query.
+func (r *dnsResolver) query(ctx context.Context, nameserver string, name dnsmessage.Name, qtype dnsmessage.Type) ([]string, time.Duration, error) {
+	if r.timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, r.timeout)
+		defer cancel()
+	}
+
+	id, err := queryID()
+	if err != nil {
+		return nil, 0, err
+	}
+	question := dnsmessage.Question{Name: name, Type: qtype, Class: dnsmessage.ClassINET}
+	msg := dnsmessage.Message{
+		Header:    dnsmessage.Header{ID: id, RecursionDesired: true},
+		Questions: []dnsmessage.Question{question},
+	}
+	if r.transport == DNSTransportUDP {
+		msg.Additionals = []dnsmessage.Resource{ednsResource()}
+	}
+	query, err := msg.Pack()
+	if err != nil {
+		return nil, 0, fmt.Errorf("failed to pack DNS query: %v", err)
+	}
+
+	matches := func(resp *dnsmessage.Message) bool {
+		return resp.ID == id && resp.Response && answersQuestion(resp, question)
+	}
+	resp, err := r.exchange(ctx, r.transport, nameserver, query, matches)
+	if err == nil && resp.Truncated && r.transport == DNSTransportUDP {
+		resp, err = r.exchange(ctx, DNSTransportTCP, nameserver, query, matches)
+	}
+	if err != nil {
+		return nil, 0, err
+	}
+	switch resp.RCode {
+	case dnsmessage.RCodeSuccess, dnsmessage.RCodeNameError:
+	default:
+		return nil, 0, fmt.Errorf("nameserver returned %s", resp.RCode)
+	}
+
+	var (
+		addrs []string
+		ttl   time.Duration
+	)
+	for _, answer := range resp.Answers {
+		ttl = minTTL(ttl, time.Duration(answer.Header.TTL)*time.Second)
+		switch body := answer.Body.(type) {
+		case *dnsmessage.AResource:
+			addrs = append(addrs, net.IP(body.A[:]).String())
+		case *dnsmessage.AAAAResource:
+			addrs = append(addrs, net.IP(body.AAAA[:]).String())
+		}
+	}
+	return addrs, ttl, nil
+}
+
+// queryID returns a random ID for a query, so that spoofed responses cannot guess it
+func queryID() (uint16, error) {
+	var id [2]byte
+	if _, err := rand.Read(id[:]); err != nil {
+		return 0, fmt.Errorf("failed to generate DNS query ID: %v", err)
+	}
+	return binary.BigEndian.Uint16(id[:]), nil
+}
+
+// answersQuestion returns whether the response carries exactly the given question. Names are compared
+// case-insensitively as nameservers may change their case.
+func answersQuestion(resp *dnsmessage.Message, question dnsmessage.Question) bool {
+	if len(resp.Questions) != 1 {
+		return false
+	}
+	q := resp.Questions[0]
+	return q.Type == question.Type && q.Class == question.Class && strings.EqualFold(q.Name.String(), question.Name.String())
+}
+
+// exchange sends the packed query over the given network and waits for the response for which matches returns true.
+// Over UDP responses which do not match, e.g. late answers to an earlier query or spoofed ones, are skipped until the
+// deadline. Cancelling the context aborts the exchange.
+func (r *dnsResolver) exchange(ctx context.Context, network, nameserver string, query []byte, matches func(*dnsmessage.Message) bool) (*dnsmessage.Message, error) {
+	var dialer net.Dialer
+	conn, err := dialer.DialContext(ctx, network, nameserver)
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+
+	if deadline, ok := ctx.Deadline(); ok {
+		if err := conn.SetDeadline(deadline); err != nil {
+			return nil, err
+		}
+	}
+	done := make(chan struct{})
+	defer close(done)
+	go func() {
+		select {
+		case <-ctx.Done():
+			// unblock pending reads and writes
+			_ = conn.SetDeadline(time.Now())
+		case <-done:
+		}
+	}()
+
+	var resp *dnsmessage.Message
+	if network == DNSTransportTCP {
+		resp, err = exchangeTCP(conn, query, matches)
+	} else {
+		resp, err = exchangeUDP(conn, query, matches)
+	}
+	if err != nil {
+		if ctx.Err() != nil && !errors.Is(err, errMismatchingResponse) {
+			return nil, ctx.Err()
+		}
+		return nil, err
+	}
+	return resp, nil
+}
+
+// exchangeUDP sends the query and reads datagrams until one carries a matching response
+func exchangeUDP(conn net.Conn, query []byte, matches func(*dnsmessage.Message) bool) (*dnsmessage.Message, error) {
+	if _, err := conn.Write(query); err != nil {
+		return nil, err
+	}
+	buf := make([]byte, maxUDPSize)
+	mismatches := 0
+	for {
+		n, err := conn.Read(buf)
+		if err != nil {
+			if mismatches > 0 {
+				return nil, fmt.Errorf("%w %d times before the read failed: %v", errMismatchingResponse, mismatches, err)
+			}
+			return nil, err
+		}
+		var resp dnsmessage.Message
+		if err := resp.Unpack(buf[:n]); err != nil || !matches(&resp) {
+			mismatches++
+			continue
+		}
+		return &resp, nil
+	}
+}
+
+// exchangeTCP sends the query and reads the response from the connection, which is opened for the query alone and
+// hence must match it
+func exchangeTCP(conn net.Conn, query []byte, matches func(*dnsmessage.Message) bool) (*dnsmessage.Message, error) {
+	// messages sent over TCP are prefixed with their two byte length
+	req := make([]byte, 2+len(query))
+	binary.BigEndian.PutUint16(req, uint16(len(query)))
+	copy(req[2:], query)
+	if _, err := conn.Write(req); err != nil {
+		return nil, err
+	}
+
+	var length [2]byte
+	if _, err := io.ReadFull(conn, length[:]); err != nil {
+		return nil, err
+	}
+	buf := make([]byte, binary.BigEndian.Uint16(length[:]))
+	if _, err := io.ReadFull(conn, buf); err != nil {
+		return nil, err
+	}
+
+	var resp dnsmessage.Message
+	if err := resp.Unpack(buf); err != nil {
+		return nil, fmt.Errorf("failed to unpack DNS response: %v", err)
+	}
+	if !matches(&resp) {
+		return nil, errMismatchingResponse
+	}
+	return &resp, nil
+}
+
+// ednsResource advertises a larger UDP payload size so that load balancers with many addresses fit into one answer
+func ednsResource() dnsmessage.Resource {
+	var opt dnsmessage.Resource
+	_ = opt.Header.SetEDNS0(maxUDPSize, dnsmessage.RCodeSuccess, false)
+	opt.Body = &dnsmessage.OPTResource{}
+	return opt
+}
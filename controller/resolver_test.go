@@ -0,0 +1,211 @@
+// SPDX-FileCopyrightText: 2020 SAP SE or an SAP affiliate company and Gardener contributors
+//
+// SPDX-License-Identifier: Apache-2.0
+
+package controller
+
+import (
+	"context"
+	"encoding/binary"
+	"io"
+	"net"
+	"time"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	"golang.org/x/net/dns/dnsmessage"
+)
+
+// answerDNSQuery builds the response of a fake nameserver which knows a single host, A records are answered with a
+// TTL of 60s and AAAA records with a TTL of 30s
+func answerDNSQuery(raw []byte, host string, a [][4]byte, aaaa [][16]byte) []byte {
+	var req dnsmessage.Message
+	Expect(req.Unpack(raw)).To(Succeed())
+
+	resp := dnsmessage.Message{
+		Header:    dnsmessage.Header{ID: req.ID, Response: true, RecursionAvailable: true},
+		Questions: req.Questions,
+	}
+	q := req.Questions[0]
+	if q.Name.String() != host {
+		resp.RCode = dnsmessage.RCodeNameError
+	}
+	header := dnsmessage.ResourceHeader{Name: q.Name, Class: dnsmessage.ClassINET, TTL: 60}
+	if q.Name.String() == host && q.Type == dnsmessage.TypeA {
+		for _, ip := range a {
+			resp.Answers = append(resp.Answers, dnsmessage.Resource{Header: header, Body: &dnsmessage.AResource{A: ip}})
+		}
+	}
+	if q.Name.String() == host && q.Type == dnsmessage.TypeAAAA {
+		header.TTL = 30
+		for _, ip := range aaaa {
+			resp.Answers = append(resp.Answers, dnsmessage.Resource{Header: header, Body: &dnsmessage.AAAAResource{AAAA: ip}})
+		}
+	}
+
+	packed, err := resp.Pack()
+	Expect(err).To(BeNil())
+	return packed
+}
+
+var _ = Describe("#dnsResolver", func() {
+	var (
+		host = "elb.example.com."
+		a    = [][4]byte{{1, 2, 3, 4}, {4, 3, 2, 1}}
+		aaaa = [][16]byte{{0x20, 0x01, 0x0d, 0xb8, 15: 1}}
+	)
+
+	It("should resolve A and AAAA records over udp", func() {
+		conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+		Expect(err).To(BeNil())
+		defer conn.Close()
+		go func() {
+			defer GinkgoRecover()
+			buf := make([]byte, 512)
+			for {
+				n, addr, err := conn.ReadFrom(buf)
+				if err != nil {
+					return
+				}
+				_, _ = conn.WriteTo(answerDNSQuery(buf[:n], host, a, aaaa), addr)
+			}
+		}()
+
+		resolver, err := NewDNSResolver([]string{conn.LocalAddr().String()}, DNSTransportUDP, time.Second)
+		Expect(err).To(BeNil())
+		ips, ttl, err := resolver.LookupHost(context.TODO(), "elb.example.com", IPFamilyDual)
+		Expect(err).To(BeNil())
+		Expect(ips).To(Equal([]string{"1.2.3.4", "4.3.2.1", "2001:db8::1"}))
+		Expect(ttl).To(Equal(30 * time.Second))
+
+		ips, ttl, err = resolver.LookupHost(context.TODO(), "elb.example.com", IPFamilyIPv4)
+		Expect(err).To(BeNil())
+		Expect(ips).To(Equal([]string{"1.2.3.4", "4.3.2.1"}))
+		Expect(ttl).To(Equal(60 * time.Second))
+
+		ips, _, err = resolver.LookupHost(context.TODO(), "elb.example.com", IPFamilyIPv6)
+		Expect(err).To(BeNil())
+		Expect(ips).To(Equal([]string{"2001:db8::1"}))
+
+		_, _, err = resolver.LookupHost(context.TODO(), "unknown.example.com", IPFamilyDual)
+		Expect(err).NotTo(BeNil())
+	})
+
+	It("should resolve A and AAAA records over tcp", func() {
+		listener, err := net.Listen("tcp", "127.0.0.1:0")
+		Expect(err).To(BeNil())
+		defer listener.Close()
+		go func() {
+			defer GinkgoRecover()
+			for {
+				conn, err := listener.Accept()
+				if err != nil {
+					return
+				}
+				var length [2]byte
+				if _, err := io.ReadFull(conn, length[:]); err != nil {
+					conn.Close()
+					continue
+				}
+				buf := make([]byte, binary.BigEndian.Uint16(length[:]))
+				if _, err := io.ReadFull(conn, buf); err != nil {
+					conn.Close()
+					continue
+				}
+				resp := answerDNSQuery(buf, host, a, aaaa)
+				binary.BigEndian.PutUint16(length[:], uint16(len(resp)))
+				_, _ = conn.Write(append(length[:], resp...))
+				conn.Close()
+			}
+		}()
+
+		resolver, err := NewDNSResolver([]string{listener.Addr().String()}, DNSTransportTCP, time.Second)
+		Expect(err).To(BeNil())
+		ips, _, err := resolver.LookupHost(context.TODO(), host, IPFamilyDual)
+		Expect(err).To(BeNil())
+		Expect(ips).To(Equal([]string{"1.2.3.4", "4.3.2.1", "2001:db8::1"}))
+	})
+
+	It("should give up when the context is cancelled", func() {
+		conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+		Expect(err).To(BeNil())
+		defer conn.Close()
+
+		resolver, err := NewDNSResolver([]string{conn.LocalAddr().String()}, DNSTransportUDP, time.Minute)
+		Expect(err).To(BeNil())
+		ctx, cancel := context.WithTimeout(context.TODO(), 100*time.Millisecond)
+		defer cancel()
+		_, _, err = resolver.LookupHost(ctx, host, IPFamilyDual)
+		Expect(err).NotTo(BeNil())
+	})
+
+	It("should reject responses to another question", func() {
+		conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+		Expect(err).To(BeNil())
+		defer conn.Close()
+		go func() {
+			defer GinkgoRecover()
+			buf := make([]byte, 512)
+			for {
+				n, addr, err := conn.ReadFrom(buf)
+				if err != nil {
+					return
+				}
+				// the ID matches, but the answer is about a name the resolver did not ask for
+				var req dnsmessage.Message
+				Expect(req.Unpack(buf[:n])).To(Succeed())
+				req.Questions[0].Name = dnsmessage.MustNewName("attacker.example.com.")
+				packed, err := req.Pack()
+				Expect(err).To(BeNil())
+				_, _ = conn.WriteTo(answerDNSQuery(packed, "attacker.example.com.", a, nil), addr)
+			}
+		}()
+
+		resolver, err := NewDNSResolver([]string{conn.LocalAddr().String()}, DNSTransportUDP, 200*time.Millisecond)
+		Expect(err).To(BeNil())
+		_, _, err = resolver.LookupHost(context.TODO(), host, IPFamilyIPv4)
+		Expect(err).To(MatchError(ContainSubstring("mismatching DNS response")))
+	})
+
+	It("should skip udp responses with another ID and wait for the matching one", func() {
+		conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+		Expect(err).To(BeNil())
+		defer conn.Close()
+		go func() {
+			defer GinkgoRecover()
+			buf := make([]byte, 512)
+			for {
+				n, addr, err := conn.ReadFrom(buf)
+				if err != nil {
+					return
+				}
+				// a bogus response, e.g. a late answer to an earlier query, arrives before the real one
+				bogus := answerDNSQuery(buf[:n], host, [][4]byte{{6, 6, 6, 6}}, nil)
+				binary.BigEndian.PutUint16(bogus, binary.BigEndian.Uint16(bogus)+1)
+				_, _ = conn.WriteTo(bogus, addr)
+				_, _ = conn.WriteTo(answerDNSQuery(buf[:n], host, a, aaaa), addr)
+			}
+		}()
+
+		resolver, err := NewDNSResolver([]string{conn.LocalAddr().String()}, DNSTransportUDP, time.Second)
+		Expect(err).To(BeNil())
+		ips, _, err := resolver.LookupHost(context.TODO(), host, IPFamilyIPv4)
+		Expect(err).To(BeNil())
+		Expect(ips).To(Equal([]string{"1.2.3.4", "4.3.2.1"}))
+	})
+
+	It("should use unpredictable query IDs", func() {
+		ids := map[uint16]bool{}
+		for i := 0; i < 16; i++ {
+			id, err := queryID()
+			Expect(err).To(BeNil())
+			ids[id] = true
+		}
+		Expect(len(ids)).To(BeNumerically(">", 1))
+	})
+
+	It("should reject unknown transports", func() {
+		_, err := NewDNSResolver([]string{"10.0.0.2"}, "quic", time.Second)
+		Expect(err).NotTo(BeNil())
+	})
+})
@@ -0,0 +1,111 @@
+// SPDX-FileCopyrightText: 2020 SAP SE or an SAP affiliate company and Gardener contributors
+//
+// SPDX-License-Identifier: Apache-2.0
+
+package controller
+
+import (
+	"fmt"
+	"net"
+
+	discoveryv1 "k8s.io/api/discovery/v1"
+	"k8s.io/apimachinery/pkg/util/sets"
+)
+
+// filterReasonExcluded is the reason resolved addresses excluded for their target are filtered for
+const filterReasonExcluded = "excluded"
+
+// normalizeAddresses validates the given static or excluded addresses of a target and returns them in their canonical
+// form, so that they compare equal to the resolved addresses
+func normalizeAddresses(addresses []string) ([]string, error) {
+	var normalized []string
+	for _, address := range addresses {
+		ip := net.ParseIP(address)
+		if ip == nil {
+			return nil, fmt.Errorf("%q is no IP", address)
+		}
+		normalized = append(normalized, ip.String())
+	}
+	return normalized, nil
+}
+
+// excludeAddresses drops the resolved addresses which are excluded for the target
+func (c *AWSLBReadvertiserController) excludeAddresses(t *target, records []string) []string {
+	if len(t.ExcludeAddresses) == 0 {
+		return records
+	}
+
+	excluded := sets.NewString(t.ExcludeAddresses...)
+	var (
+		kept    []string
+		dropped []string
+	)
+	for _, record := range records {
+		if !excluded.Has(record) {
+			kept = append(kept, record)
+			continue
+		}
+		filteredAddresses.WithLabelValues(t.Key(), filterReasonExcluded).Inc()
+		dropped = append(dropped, record)
+	}
+
+	if len(dropped) != 0 {
+		t.log.Infof("Not advertising the excluded IPs %q", dropped)
+	}
+	return kept
+}
+
+// addStaticAddresses merges the static addresses of the target of the configured IP family into the given addresses
+// as ready addresses. They are added after the resolved addresses were validated, aggregated, damped, retained and
+// probed, as they are trusted by configuration and advertised regardless of DNS.
+func (c *AWSLBReadvertiserController) addStaticAddresses(t *target, addresses advertisedAddresses) advertisedAddresses {
+	ipv4, ipv6 := partitionIPFamilies(t.StaticAddresses, c.ipFamily)
+	static := sets.NewString(append(ipv4, ipv6...)...)
+	if static.Len() == 0 {
+		return addresses
+	}
+
+	added := static.Difference(sets.NewString(addresses.all()...))
+	if added.Len() != 0 {
+		t.log.Debugf("Advertising the static IPs %q in addition to the resolved IPs %q", added.List(), addresses.all())
+	}
+	merged := addresses
+	merged.ready = sets.NewString(addresses.ready...).Union(static).List()
+	merged.notReady = sets.NewString(addresses.notReady...).Difference(static).List()
+	merged.static = added.List()
+	return merged
+}
+
+// missesStaticAddresses returns whether one of the static addresses of the target is not advertised as ready
+func (c *AWSLBReadvertiserController) missesStaticAddresses(t *target, addresses advertisedAddresses) bool {
+	ipv4, ipv6 := partitionIPFamilies(t.StaticAddresses, c.ipFamily)
+	return !sets.NewString(addresses.ready...).HasAll(append(ipv4, ipv6...)...)
+}
+
+// cachedAddresses returns the addresses advertised by the cached managed objects of the target
+func (c *AWSLBReadvertiserController) cachedAddresses(t *target) advertisedAddresses {
+	ready, notReady := sets.NewString(), sets.NewString()
+	if c.manageEndpoints() {
+		if endpoint, err := c.endpointsLister.Endpoints(t.EndpointNamespace).Get(t.EndpointName); err == nil {
+			r, n := subsetIPs(managedSubsets(endpoint, t.Ports, nil))
+			ready.Insert(r...)
+			notReady.Insert(n...)
+		}
+	}
+	if c.manageEndpointSlices() {
+		for _, addressType := range []discoveryv1.AddressType{discoveryv1.AddressTypeIPv4, discoveryv1.AddressTypeIPv6} {
+			slice, err := c.endpointSliceLister.EndpointSlices(t.EndpointNamespace).Get(endpointSliceName(t.EndpointName, addressType))
+			if err != nil {
+				continue
+			}
+			for _, endpoint := range slice.Endpoints {
+				if endpoint.Conditions.Ready == nil || *endpoint.Conditions.Ready {
+					ready.Insert(endpoint.Addresses...)
+				} else {
+					notReady.Insert(endpoint.Addresses...)
+				}
+			}
+		}
+	}
+	return advertisedAddresses{ready: ready.List(), notReady: notReady.Difference(ready).List()}
+}
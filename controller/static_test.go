@@ -0,0 +1,157 @@
+// SPDX-FileCopyrightText: 2020 SAP SE or an SAP affiliate company and Gardener contributors
+//
+// SPDX-License-Identifier: Apache-2.0
+
+package controller
+
+import (
+	"context"
+	"time"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	k8sinformers "k8s.io/client-go/informers"
+	"k8s.io/client-go/kubernetes/fake"
+	"k8s.io/client-go/tools/record"
+)
+
+var _ = Describe("#addStaticAddresses", func() {
+	var (
+		fakeClient               *fake.Clientset
+		sharedK8sInformerFactory k8sinformers.SharedInformerFactory
+		resolver                 staticResolver
+		controller               *AWSLBReadvertiserController
+	)
+
+	BeforeEach(func() {
+		fakeClient = fake.NewSimpleClientset()
+		resolver = staticResolver{"elb.example.com.": {"1.1.1.1", "2.2.2.2"}}
+	})
+
+	AfterEach(func() {
+		controller.queue.ShutDown()
+	})
+
+	newController := func(family IPFamily, target Target) {
+		target.Hostnames = []string{"elb.example.com"}
+		target.EndpointNamespace = metav1.NamespaceDefault
+		target.EndpointName = "kubernetes"
+		target.Ports = DefaultEndpointPorts()
+		target.RefreshPeriod = time.Second
+		targets := []Target{target}
+		Expect(ValidateTargets(targets)).To(Succeed())
+
+		sharedK8sInformerFactory = k8sinformers.NewSharedInformerFactory(fakeClient, time.Hour)
+		controller = newTestController(fakeClient, sharedK8sInformerFactory, resolver, record.NewFakeRecorder(100), targets, Options{IPFamily: family})
+	}
+
+	advertisedIPs := func() []corev1.EndpointAddress {
+		endpoint, err := fakeClient.CoreV1().Endpoints(metav1.NamespaceDefault).Get(context.TODO(), "kubernetes", metav1.GetOptions{})
+		Expect(err).To(BeNil())
+		return endpoint.Subsets[0].Addresses
+	}
+
+	It("should advertise the static addresses in addition to the resolved ones", func() {
+		newController(IPFamilyIPv4, Target{StaticAddresses: []string{"10.0.0.1", "2.2.2.2"}})
+		Expect(controller.reconcileTarget(context.TODO(), firstTarget(controller))).To(Succeed())
+		Expect(advertisedIPs()).To(Equal([]corev1.EndpointAddress{{IP: "1.1.1.1"}, {IP: "10.0.0.1"}, {IP: "2.2.2.2"}}))
+	})
+
+	It("should ignore static addresses of another IP family", func() {
+		newController(IPFamilyIPv4, Target{StaticAddresses: []string{"fd00::1"}})
+		Expect(controller.addStaticAddresses(firstTarget(controller), readyAddresses([]string{"1.1.1.1"}))).To(Equal(readyAddresses([]string{"1.1.1.1"})))
+	})
+
+	It("should not advertise excluded addresses", func() {
+		newController(IPFamilyIPv4, Target{ExcludeAddresses: []string{"2.2.2.2"}})
+		before := testutil.ToFloat64(filteredAddresses.WithLabelValues("default/kubernetes", filterReasonExcluded))
+
+		Expect(controller.reconcileTarget(context.TODO(), firstTarget(controller))).To(Succeed())
+		Expect(advertisedIPs()).To(Equal([]corev1.EndpointAddress{{IP: "1.1.1.1"}}))
+		Expect(testutil.ToFloat64(filteredAddresses.WithLabelValues("default/kubernetes", filterReasonExcluded)) - before).To(Equal(1.0))
+	})
+
+	It("should advertise the static addresses alone if all resolved addresses are excluded", func() {
+		newController(IPFamilyIPv4, Target{StaticAddresses: []string{"10.0.0.1"}, ExcludeAddresses: []string{"1.1.1.1", "2.2.2.2"}})
+		Expect(controller.reconcileTarget(context.TODO(), firstTarget(controller))).NotTo(Succeed())
+		Expect(advertisedIPs()).To(Equal([]corev1.EndpointAddress{{IP: "10.0.0.1"}}))
+	})
+
+	It("should keep the advertised addresses and add the static ones if the resolution fails", func() {
+		newController(IPFamilyIPv4, Target{StaticAddresses: []string{"10.0.0.1"}})
+		endpoint := &corev1.Endpoints{
+			ObjectMeta: metav1.ObjectMeta{Name: "kubernetes", Namespace: metav1.NamespaceDefault},
+			Subsets:    []corev1.EndpointSubset{{Addresses: []corev1.EndpointAddress{{IP: "1.1.1.1"}}, Ports: DefaultEndpointPorts()}},
+		}
+		_, err := fakeClient.CoreV1().Endpoints(metav1.NamespaceDefault).Create(context.TODO(), endpoint, metav1.CreateOptions{})
+		Expect(err).To(BeNil())
+		Expect(sharedK8sInformerFactory.Core().V1().Endpoints().Informer().GetStore().Add(endpoint)).To(Succeed())
+		delete(resolver, "elb.example.com.")
+
+		Expect(controller.reconcileTarget(context.TODO(), firstTarget(controller))).NotTo(Succeed())
+		Expect(advertisedIPs()).To(Equal([]corev1.EndpointAddress{{IP: "1.1.1.1"}, {IP: "10.0.0.1"}}))
+
+		// nothing is written while the static addresses are advertised
+		updated, err := fakeClient.CoreV1().Endpoints(metav1.NamespaceDefault).Get(context.TODO(), "kubernetes", metav1.GetOptions{})
+		Expect(err).To(BeNil())
+		Expect(sharedK8sInformerFactory.Core().V1().Endpoints().Informer().GetStore().Update(updated)).To(Succeed())
+		fakeClient.ClearActions()
+		Expect(controller.reconcileTarget(context.TODO(), firstTarget(controller))).NotTo(Succeed())
+		Expect(fakeClient.Actions()).To(BeEmpty())
+	})
+
+	It("should not damp, retain or persist the static addresses", func() {
+		newController(IPFamilyIPv4, Target{StaticAddresses: []string{"10.0.0.1"}})
+		controller.options.Aggregation = AggregationOptions{Window: time.Hour}
+		controller.options.Damping = DampingOptions{Mode: DampingModeConsensus, Observations: 2, Lookups: 2}
+		controller.options.GracePeriod = GracePeriodOptions{RemovalGracePeriod: time.Hour}
+		controller.options.Fallback = FallbackOptions{MaxStaleness: time.Hour}
+		t := firstTarget(controller)
+
+		Expect(controller.reconcileTarget(context.TODO(), t)).To(Succeed())
+		Expect(controller.reconcileTarget(context.TODO(), t)).To(Succeed())
+		endpoint, err := fakeClient.CoreV1().Endpoints(metav1.NamespaceDefault).Get(context.TODO(), "kubernetes", metav1.GetOptions{})
+		Expect(err).To(BeNil())
+		Expect(endpoint.Subsets[0].Addresses).To(Equal([]corev1.EndpointAddress{{IP: "1.1.1.1"}, {IP: "10.0.0.1"}, {IP: "2.2.2.2"}}))
+		restored, err := parseLastKnownGoodAnnotation(endpoint.Annotations)
+		Expect(err).To(BeNil())
+		Expect(restored.Addresses).To(Equal([]string{"1.1.1.1", "2.2.2.2"}))
+		Expect(t.grace.previous.Has("10.0.0.1")).To(BeFalse())
+		Expect(t.damping.accepted).To(Equal([]string{"1.1.1.1", "2.2.2.2"}))
+		Expect(t.lastSeen).NotTo(HaveKey("10.0.0.1"))
+	})
+})
+
+var _ = Describe("#ValidateTargets", func() {
+	target := func(static, excluded []string) []Target {
+		return []Target{{
+			Hostnames:         []string{"elb.example.com"},
+			EndpointNamespace: metav1.NamespaceDefault,
+			EndpointName:      "kubernetes",
+			Ports:             DefaultEndpointPorts(),
+			RefreshPeriod:     time.Second,
+			StaticAddresses:   static,
+			ExcludeAddresses:  excluded,
+		}}
+	}
+
+	It("should normalize the static and excluded addresses", func() {
+		targets := target([]string{"fd00:0::1"}, []string{"::ffff:1.1.1.1"})
+		Expect(ValidateTargets(targets)).To(Succeed())
+		Expect(targets[0].StaticAddresses).To(Equal([]string{"fd00::1"}))
+		Expect(targets[0].ExcludeAddresses).To(Equal([]string{"1.1.1.1"}))
+	})
+
+	It("should reject invalid and special-purpose addresses", func() {
+		Expect(ValidateTargets(target([]string{"elb.example.com"}, nil))).To(MatchError(ContainSubstring("invalid static address")))
+		Expect(ValidateTargets(target([]string{"127.0.0.1"}, nil))).To(MatchError(ContainSubstring("loopback")))
+		Expect(ValidateTargets(target(nil, []string{"1.1.1.0/24"}))).To(MatchError(ContainSubstring("invalid excluded address")))
+	})
+
+	It("should reject addresses which are both static and excluded", func() {
+		Expect(ValidateTargets(target([]string{"1.1.1.1"}, []string{"1.1.1.1"}))).To(MatchError(ContainSubstring("both static and excluded")))
+	})
+})
@@ -0,0 +1,111 @@
+// SPDX-FileCopyrightText: 2020 SAP SE or an SAP affiliate company and Gardener contributors
+//
+// SPDX-License-Identifier: Apache-2.0
+
+package controller
+
+import (
+	"fmt"
+	"strings"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/util/sets"
+)
+
+// managedPortsAnnotation records the ports of the subset managed by the readvertiser, so that the subset is still
+// recognized after the configured ports changed
+const managedPortsAnnotation = "aws-lb-readvertiser.gardener.cloud/managed-ports"
+
+// endpointPortsKey identifies a set of ports regardless of their order, e.g. "https:443/TCP"
+func endpointPortsKey(ports []corev1.EndpointPort) string {
+	var keys []string
+	for _, port := range sortedEndpointPorts(ports) {
+		key := fmt.Sprintf("%s:%d/%s", port.Name, port.Port, port.Protocol)
+		if port.AppProtocol != nil {
+			key += "/" + *port.AppProtocol
+		}
+		keys = append(keys, key)
+	}
+	return strings.Join(keys, ",")
+}
+
+// isManagedSubset returns whether the subset of the endpoint is managed by the readvertiser, which is the case if it
+// carries the configured ports or the ports the readvertiser wrote last. An endpoint the readvertiser did not write
+// yet, e.g. one written by an earlier version of it, lacks the managed ports, its subset is adopted if it is the only
+// one or holds only the given advertised addresses.
+func isManagedSubset(endpoint *corev1.Endpoints, subset corev1.EndpointSubset, ports []corev1.EndpointPort, advertised []string) bool {
+	key := endpointPortsKey(subset.Ports)
+	if key == endpointPortsKey(ports) {
+		return true
+	}
+	if managed, ok := endpoint.Annotations[managedPortsAnnotation]; ok {
+		return key == managed
+	}
+	if len(endpoint.Subsets) == 1 {
+		return true
+	}
+	ready, notReady := subsetIPs([]corev1.EndpointSubset{subset})
+	ips := append(ready, notReady...)
+	return len(ips) != 0 && sets.NewString(advertised...).HasAll(ips...)
+}
+
+// managedSubsets returns the subsets of the endpoint managed by the readvertiser, all other subsets are left untouched.
+// advertised are the addresses the readvertiser advertises, they identify the managed subset of an endpoint it did not
+// write yet.
+func managedSubsets(endpoint *corev1.Endpoints, ports []corev1.EndpointPort, advertised []string) []corev1.EndpointSubset {
+	var managed []corev1.EndpointSubset
+	for _, subset := range endpoint.Subsets {
+		if isManagedSubset(endpoint, subset, ports, advertised) {
+			managed = append(managed, subset)
+		}
+	}
+	return managed
+}
+
+// subsetIPs returns the ready and not ready IPs of the given subsets
+func subsetIPs(subsets []corev1.EndpointSubset) ([]string, []string) {
+	var ready, notReady []string
+	for _, subset := range subsets {
+		for _, address := range subset.Addresses {
+			ready = append(ready, address.IP)
+		}
+		for _, address := range subset.NotReadyAddresses {
+			notReady = append(notReady, address.IP)
+		}
+	}
+	return ready, notReady
+}
+
+// desiredSubsets returns the subsets of the endpoint with the managed ones replaced by the given subset. It takes the
+// position of the first managed subset, or is appended if there is none. endpoint may be nil if it does not exist.
+func desiredSubsets(endpoint *corev1.Endpoints, subset corev1.EndpointSubset, ports []corev1.EndpointPort, advertised []string) []corev1.EndpointSubset {
+	if endpoint == nil {
+		return []corev1.EndpointSubset{subset}
+	}
+
+	var subsets []corev1.EndpointSubset
+	replaced := false
+	for _, current := range endpoint.Subsets {
+		if !isManagedSubset(endpoint, current, ports, advertised) {
+			subsets = append(subsets, current)
+			continue
+		}
+		if !replaced {
+			subsets = append(subsets, subset)
+			replaced = true
+		}
+	}
+	if !replaced {
+		subsets = append(subsets, subset)
+	}
+	return subsets
+}
+
+// setManagedPortsAnnotation records the given ports as the ports of the managed subset in the given annotations
+func setManagedPortsAnnotation(annotations map[string]string, ports []corev1.EndpointPort) map[string]string {
+	if annotations == nil {
+		annotations = map[string]string{}
+	}
+	annotations[managedPortsAnnotation] = endpointPortsKey(ports)
+	return annotations
+}
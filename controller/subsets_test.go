@@ -0,0 +1,130 @@
+// SPDX-FileCopyrightText: 2020 SAP SE or an SAP affiliate company and Gardener contributors
+//
+// SPDX-License-Identifier: Apache-2.0
+
+package controller
+
+import (
+	"context"
+	"time"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	k8sinformers "k8s.io/client-go/informers"
+	"k8s.io/client-go/kubernetes/fake"
+	"k8s.io/client-go/tools/record"
+)
+
+var _ = Describe("#managedSubsets", func() {
+	var (
+		fakeClient               *fake.Clientset
+		sharedK8sInformerFactory k8sinformers.SharedInformerFactory
+		controller               *AWSLBReadvertiserController
+
+		metricsPorts = []corev1.EndpointPort{{Name: "metrics", Port: 9090, Protocol: corev1.ProtocolTCP}}
+		// unmanaged is a subset added by another tool
+		unmanaged = corev1.EndpointSubset{
+			Addresses:         []corev1.EndpointAddress{{IP: "10.1.1.1"}},
+			NotReadyAddresses: []corev1.EndpointAddress{{IP: "10.1.1.2"}},
+			Ports:             metricsPorts,
+		}
+	)
+
+	BeforeEach(func() {
+		fakeClient = fake.NewSimpleClientset()
+		sharedK8sInformerFactory = k8sinformers.NewSharedInformerFactory(fakeClient, time.Hour)
+		controller = newTestController(fakeClient, sharedK8sInformerFactory, staticResolver{}, record.NewFakeRecorder(100), nil, Options{})
+	})
+
+	AfterEach(func() {
+		controller.queue.ShutDown()
+	})
+
+	// createEndpoint creates the endpoint with the given subsets and annotations in the API server and the cache
+	createEndpoint := func(annotations map[string]string, subsets ...corev1.EndpointSubset) {
+		endpoint := &corev1.Endpoints{
+			ObjectMeta: metav1.ObjectMeta{Name: "kubernetes", Namespace: metav1.NamespaceDefault, Annotations: annotations},
+			Subsets:    subsets,
+		}
+		_, err := fakeClient.CoreV1().Endpoints(metav1.NamespaceDefault).Create(context.TODO(), endpoint, metav1.CreateOptions{})
+		Expect(err).To(BeNil())
+		Expect(sharedK8sInformerFactory.Core().V1().Endpoints().Informer().GetStore().Add(endpoint)).To(Succeed())
+		fakeClient.ClearActions()
+	}
+
+	reconcile := func(ips ...string) []corev1.EndpointSubset {
+		Expect(controller.reconcileEndpoints(context.TODO(), firstTarget(controller), readyAddresses(ips))).To(Succeed())
+		endpoint, err := fakeClient.CoreV1().Endpoints(metav1.NamespaceDefault).Get(context.TODO(), "kubernetes", metav1.GetOptions{})
+		Expect(err).To(BeNil())
+		return endpoint.Subsets
+	}
+
+	managed := func(ips ...string) corev1.EndpointSubset {
+		subset := corev1.EndpointSubset{Ports: DefaultEndpointPorts()}
+		for _, ip := range ips {
+			subset.Addresses = append(subset.Addresses, corev1.EndpointAddress{IP: ip})
+		}
+		return subset
+	}
+
+	It("should only replace the subset with the configured ports", func() {
+		createEndpoint(nil, managed("10.0.0.1"), unmanaged)
+		Expect(reconcile("1.1.1.1")).To(Equal([]corev1.EndpointSubset{managed("1.1.1.1"), unmanaged}))
+	})
+
+	It("should add a subset with the configured ports if there is none", func() {
+		createEndpoint(map[string]string{managedPortsAnnotation: "https:443/TCP"}, unmanaged)
+		Expect(reconcile("1.1.1.1")).To(Equal([]corev1.EndpointSubset{unmanaged, managed("1.1.1.1")}))
+	})
+
+	It("should detect drift in a subset which is not the first one", func() {
+		annotations := map[string]string{managedPortsAnnotation: "https:443/TCP"}
+		createEndpoint(annotations, unmanaged, managed("1.1.1.1"))
+		Expect(reconcile("1.1.1.1")).To(Equal([]corev1.EndpointSubset{unmanaged, managed("1.1.1.1")}))
+		Expect(fakeClient.Actions()).To(HaveLen(1))
+
+		Expect(fakeClient.CoreV1().Endpoints(metav1.NamespaceDefault).Delete(context.TODO(), "kubernetes", metav1.DeleteOptions{})).To(Succeed())
+		createEndpoint(annotations, unmanaged, managed("9.9.9.9"))
+		Expect(reconcile("1.1.1.1")).To(Equal([]corev1.EndpointSubset{unmanaged, managed("1.1.1.1")}))
+	})
+
+	It("should merge several subsets with the configured ports", func() {
+		createEndpoint(nil, managed("1.1.1.1"), unmanaged, managed("2.2.2.2"))
+		Expect(reconcile("1.1.1.1", "2.2.2.2")).To(Equal([]corev1.EndpointSubset{managed("1.1.1.1", "2.2.2.2"), unmanaged}))
+	})
+
+	It("should replace the subset with the ports written last after the configured ports changed", func() {
+		previous := corev1.EndpointSubset{Addresses: []corev1.EndpointAddress{{IP: "1.1.1.1"}}, Ports: []corev1.EndpointPort{{Name: "https", Port: 6443, Protocol: corev1.ProtocolTCP}}}
+		createEndpoint(map[string]string{managedPortsAnnotation: "https:6443/TCP"}, previous, unmanaged)
+		Expect(reconcile("1.1.1.1")).To(Equal([]corev1.EndpointSubset{managed("1.1.1.1"), unmanaged}))
+
+		endpoint, err := fakeClient.CoreV1().Endpoints(metav1.NamespaceDefault).Get(context.TODO(), "kubernetes", metav1.GetOptions{})
+		Expect(err).To(BeNil())
+		Expect(endpoint.Annotations).To(HaveKeyWithValue(managedPortsAnnotation, "https:443/TCP"))
+	})
+
+	It("should adopt the only subset of an endpoint written without the managed ports after the ports changed", func() {
+		createEndpoint(nil, managed("1.1.1.1"))
+		ports := []corev1.EndpointPort{{Name: "https", Port: 6443, Protocol: corev1.ProtocolTCP}}
+		firstTarget(controller).Ports = ports
+		Expect(reconcile("1.1.1.1")).To(Equal([]corev1.EndpointSubset{{Addresses: []corev1.EndpointAddress{{IP: "1.1.1.1"}}, Ports: ports}}))
+
+		endpoint, err := fakeClient.CoreV1().Endpoints(metav1.NamespaceDefault).Get(context.TODO(), "kubernetes", metav1.GetOptions{})
+		Expect(err).To(BeNil())
+		Expect(endpoint.Annotations).To(HaveKeyWithValue(managedPortsAnnotation, "https:6443/TCP"))
+	})
+
+	It("should adopt the subset holding only advertised addresses of an endpoint written without the managed ports", func() {
+		previous := corev1.EndpointSubset{Addresses: []corev1.EndpointAddress{{IP: "1.1.1.1"}}, Ports: []corev1.EndpointPort{{Name: "https", Port: 6443, Protocol: corev1.ProtocolTCP}}}
+		createEndpoint(nil, previous, unmanaged)
+		Expect(reconcile("1.1.1.1", "2.2.2.2")).To(Equal([]corev1.EndpointSubset{managed("1.1.1.1", "2.2.2.2"), unmanaged}))
+	})
+
+	It("should identify the ports regardless of their order", func() {
+		ports := []corev1.EndpointPort{{Name: "https", Port: 443, Protocol: corev1.ProtocolTCP}, metricsPorts[0]}
+		Expect(endpointPortsKey(ports)).To(Equal("https:443/TCP,metrics:9090/TCP"))
+		Expect(endpointPortsKey([]corev1.EndpointPort{ports[1], ports[0]})).To(Equal(endpointPortsKey(ports)))
+	})
+})
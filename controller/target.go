@@ -0,0 +1,149 @@
+// SPDX-FileCopyrightText: 2020 SAP SE or an SAP affiliate company and Gardener contributors
+//
+// SPDX-License-Identifier: Apache-2.0
+
+package controller
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/util/sets"
+	"k8s.io/apimachinery/pkg/util/validation"
+)
+
+// Target is a set of load balancer hostnames whose IPs are advertised in one endpoint
+type Target struct {
+	// Hostnames are the DNS names of the load balancers, the advertised IPs are the union of their addresses
+	Hostnames []string
+	// EndpointNamespace and EndpointName identify the managed Endpoints object (and service)
+	EndpointNamespace, EndpointName string
+	// Ports are advertised for all IPs
+	Ports []corev1.EndpointPort
+	// RefreshPeriod is the period at which the hostnames are resolved
+	RefreshPeriod time.Duration
+	// StaticAddresses are always advertised in addition to the resolved addresses
+	StaticAddresses []string
+	// ExcludeAddresses are never advertised, even if a hostname resolves to them
+	ExcludeAddresses []string
+}
+
+// Key returns the namespace/name of the endpoint managed for the target
+func (t *Target) Key() string {
+	return fmt.Sprintf("%s/%s", t.EndpointNamespace, t.EndpointName)
+}
+
+// target is the state the controller keeps for a Target
+type target struct {
+	Target
+
+	log *log.Entry
+	// ttl is the minimum TTL of the records of the last lookup, zero if unknown
+	ttl time.Duration
+	// lastSeen is the time each address was returned by a lookup for the last time
+	lastSeen map[string]time.Time
+	// damping tracks which addresses the recent lookups agreed on
+	damping dampingState
+	// grace tracks the addresses retained after they disappeared from DNS
+	grace graceState
+	// lastKnownGood are the addresses advertised after the last successful resolution
+	lastKnownGood *lastKnownGood
+	// lastKnownGoodLoaded is set once the last known good addresses were restored from the managed objects
+	lastKnownGoodLoaded bool
+	// persistedLastKnownGood is the value of the last known good addresses last persisted in the ConfigMap
+	persistedLastKnownGood string
+	// fight tracks the overwrites of the managed endpoint by other writers
+	fight fightState
+	// hostnames maps every address to the hostname it was resolved from last
+	hostnames map[string]string
+	// writes are the writes of the managed objects the informer cache did not catch up with yet
+	writes map[string]pendingWrite
+	// refresh tracks whether a scheduled refresh of the target is due
+	refresh refreshState
+	// lastLookup are the addresses written after the last successful lookup, lastLookupAt is the time of the lookup
+	lastLookup   *advertisedAddresses
+	lastLookupAt time.Time
+	// compared is set if the current reconcile compared all managed objects with the advertised addresses, it is not
+	// while the damping holds the addresses back or a cached object predates its last write
+	compared bool
+}
+
+func newTarget(spec Target) *target {
+	return &target{
+		Target: spec,
+		log:    log.WithField("target", spec.Key()),
+	}
+}
+
+// hostnameOf returns the hostname the address was resolved from last, or the first hostname of the target if the
+// address is unknown
+func (t *target) hostnameOf(ip string) string {
+	if hostname, ok := t.hostnames[ip]; ok {
+		return hostname
+	}
+	return t.Hostnames[0]
+}
+
+// ValidateTargets checks that the targets are complete and that no two targets manage the same endpoint. Hostnames
+// are turned into fully qualified names, static and excluded addresses into canonical IPs.
+func ValidateTargets(targets []Target) error {
+	if len(targets) == 0 {
+		return fmt.Errorf("at least one target must be given")
+	}
+
+	keys := sets.NewString()
+	for i := range targets {
+		t := &targets[i]
+
+		if len(t.Hostnames) == 0 {
+			return fmt.Errorf("target %s: at least one hostname must be given", t.Key())
+		}
+		for j, hostname := range t.Hostnames {
+			if len(hostname) == 0 {
+				return fmt.Errorf("target %s: hostname must not be empty", t.Key())
+			}
+			// Check to see if the domain is a valid FQDN
+			if !strings.HasSuffix(hostname, ".") {
+				t.Hostnames[j] = fmt.Sprintf("%s.", hostname)
+			}
+		}
+
+		if errs := validation.IsDNS1123Subdomain(t.EndpointName); len(errs) != 0 {
+			return fmt.Errorf("target %s: endpoint name %q is invalid: %s", t.Key(), t.EndpointName, strings.Join(errs, ", "))
+		}
+		if errs := validation.IsDNS1123Label(t.EndpointNamespace); len(errs) != 0 {
+			return fmt.Errorf("target %s: endpoint namespace %q is invalid: %s", t.Key(), t.EndpointNamespace, strings.Join(errs, ", "))
+		}
+		if keys.Has(t.Key()) {
+			return fmt.Errorf("target %s: endpoint is managed by more than one target", t.Key())
+		}
+		keys.Insert(t.Key())
+
+		if err := ValidateEndpointPorts(t.Ports); err != nil {
+			return fmt.Errorf("target %s: %v", t.Key(), err)
+		}
+		if t.RefreshPeriod <= 0 {
+			return fmt.Errorf("target %s: refresh period must be positive", t.Key())
+		}
+
+		var err error
+		if t.StaticAddresses, err = normalizeAddresses(t.StaticAddresses); err != nil {
+			return fmt.Errorf("target %s: invalid static address: %v", t.Key(), err)
+		}
+		for _, address := range t.StaticAddresses {
+			if reason := (*AddressFilter)(nil).filterReason(address); len(reason) != 0 {
+				return fmt.Errorf("target %s: static address %s must not be advertised (%s)", t.Key(), address, reason)
+			}
+		}
+		if t.ExcludeAddresses, err = normalizeAddresses(t.ExcludeAddresses); err != nil {
+			return fmt.Errorf("target %s: invalid excluded address: %v", t.Key(), err)
+		}
+		if both := sets.NewString(t.StaticAddresses...).Intersection(sets.NewString(t.ExcludeAddresses...)); both.Len() != 0 {
+			return fmt.Errorf("target %s: addresses %q are both static and excluded", t.Key(), both.List())
+		}
+	}
+	return nil
+}
@@ -7,8 +7,10 @@ package controller
 import (
 	"errors"
 	"fmt"
+	"net"
 
 	corev1 "k8s.io/api/core/v1"
+	discoveryv1 "k8s.io/api/discovery/v1"
 	"k8s.io/apimachinery/pkg/util/sets"
 )
 
@@ -19,28 +21,26 @@ func checkEndpointIsStillValid(currentEndpointValues []string, elbFetchedRecords
 	return currentEndpoints.Equal(fetchedRecords)
 }
 
-// createEndpointSubset creates an endpoint subset from a set of IPs and currently with a constant port 443
-func createEndpointSubsetObjectFromRecords(ips []string) (*corev1.EndpointSubset, error) {
-	if len(ips) == 0 {
+// createEndpointSubset creates an endpoint subset from a set of ready and not ready IPs and the given ports
+func createEndpointSubsetObjectFromRecords(ips, notReadyIPs []string, ports []corev1.EndpointPort) (*corev1.EndpointSubset, error) {
+	if len(ips)+len(notReadyIPs) == 0 {
 		return nil, errors.New("Empty list of IPs")
 	}
 
-	var endpointAddresses []corev1.EndpointAddress
-	for _, ip := range ips {
-		endpointAddresses = append(endpointAddresses, corev1.EndpointAddress{
-			IP: ip,
-		})
+	toAddresses := func(ips []string) []corev1.EndpointAddress {
+		var endpointAddresses []corev1.EndpointAddress
+		for _, ip := range ips {
+			endpointAddresses = append(endpointAddresses, corev1.EndpointAddress{
+				IP: ip,
+			})
+		}
+		return endpointAddresses
 	}
 
 	return &corev1.EndpointSubset{
-		Addresses: endpointAddresses,
-		Ports: []corev1.EndpointPort{
-			{
-				Name:     "https",
-				Port:     443,
-				Protocol: "TCP",
-			},
-		},
+		Addresses:         toAddresses(ips),
+		NotReadyAddresses: toAddresses(notReadyIPs),
+		Ports:             append([]corev1.EndpointPort(nil), ports...),
 	}, nil
 }
 
@@ -56,3 +56,29 @@ func fetchEndpointIPsFromAddresses(addresses []corev1.EndpointAddress) ([]string
 	}
 	return ips, nil
 }
+
+// fetchEndpointSliceIPs returns the list of addresses of all endpoints of an EndpointSlice
+func fetchEndpointSliceIPs(endpointSlice *discoveryv1.EndpointSlice) []string {
+	var ips []string
+	for _, endpoint := range endpointSlice.Endpoints {
+		ips = append(ips, endpoint.Addresses...)
+	}
+	return ips
+}
+
+// partitionIPFamilies splits the given records into the IPv4 and IPv6 addresses of the given family,
+// records which are no IP or not of the family are dropped
+func partitionIPFamilies(records []string, family IPFamily) (ipv4, ipv6 []string) {
+	for _, record := range records {
+		ip := net.ParseIP(record)
+		switch {
+		case ip == nil:
+			continue
+		case ip.To4() != nil && family != IPFamilyIPv6:
+			ipv4 = append(ipv4, record)
+		case ip.To4() == nil && family != IPFamilyIPv4:
+			ipv6 = append(ipv6, record)
+		}
+	}
+	return ipv4, ipv6
+}
@@ -6,11 +6,14 @@ package main
 
 import (
 	"context"
+	"errors"
 	"flag"
 	"fmt"
+	"net/http"
 	"os"
 	"os/signal"
 	"strings"
+	"sync"
 	"syscall"
 	"time"
 
@@ -18,50 +21,272 @@ import (
 
 	"k8s.io/client-go/informers"
 
+	"github.com/prometheus/client_golang/prometheus/promhttp"
 	log "github.com/sirupsen/logrus"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/sets"
+	"k8s.io/apimachinery/pkg/util/uuid"
 	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/kubernetes/scheme"
+	typedcorev1 "k8s.io/client-go/kubernetes/typed/core/v1"
 	"k8s.io/client-go/rest"
 	"k8s.io/client-go/tools/clientcmd"
+	"k8s.io/client-go/tools/leaderelection"
+	"k8s.io/client-go/tools/leaderelection/resourcelock"
+	"k8s.io/client-go/tools/record"
 )
 
+// version is set at build time via -ldflags "-X main.version=<version>"
+var version = "unknown"
+
 // AWSReadvertiserOptions are the options for the AWSReadvertiser
 type AWSReadvertiserOptions struct {
 	endpointName           string
+	endpointNamespace      string
 	kubeconfig             string
 	elb                    string
 	refreshPeriod          int
 	controllerResyncPeriod int
+	nameservers            stringSliceFlag
+	dnsTransport           string
+	dnsTimeout             time.Duration
+	endpointAPI            string
+	ipFamily               string
+	ports                  stringSliceFlag
+	staticAddresses        stringSliceFlag
+	excludeAddresses       stringSliceFlag
+	configFile             string
+	targets                []controller.Target
+	leaderElection         leaderElectionOptions
+	metricsBindAddress     string
+	healthProbeBindAddress string
+	health                 controller.HealthOptions
+	refresh                controller.RefreshOptions
+	aggregation            controller.AggregationOptions
+	damping                controller.DampingOptions
+	gracePeriod            controller.GracePeriodOptions
+	probe                  controller.ProbeOptions
+	identity               controller.IdentityOptions
+	ipRanges               controller.IPRangesOptions
+	filter                 controller.FilterOptions
+	fallback               controller.FallbackOptions
+	fight                  controller.FightOptions
+	write                  controller.WriteOptions
+}
+
+// leaderElectionOptions configure the election of the replica which reconciles the targets
+type leaderElectionOptions struct {
+	enabled       bool
+	namespace     string
+	name          string
+	leaseDuration time.Duration
+	renewDeadline time.Duration
+	retryPeriod   time.Duration
+}
+
+// stringSliceFlag is a flag which can be given multiple times, each value is appended to the slice
+type stringSliceFlag []string
+
+func (s *stringSliceFlag) String() string {
+	return strings.Join(*s, ",")
+}
+
+func (s *stringSliceFlag) Set(value string) error {
+	*s = append(*s, value)
+	return nil
 }
 
 func (a *AWSReadvertiserOptions) addFlags() {
 	flag.StringVar(&a.kubeconfig, "kubeconfig", "", "kubeconfig")
+	flag.StringVar(&a.configFile, "config", "", "YAML or JSON file describing the targets to reconcile, replaces --elb-dns-name, --endpoint-name, --endpoint-namespace, --port, --static-address and --exclude-address")
 	flag.StringVar(&a.elb, "elb-dns-name", "", "DNS name of elb")
+	flag.StringVar(&a.endpointName, "endpoint-name", "kubernetes", "name of the endpoint the elb IPs are advertised in")
+	flag.StringVar(&a.endpointNamespace, "endpoint-namespace", metav1.NamespaceDefault, "namespace of the endpoint the elb IPs are advertised in")
 	flag.IntVar(&a.refreshPeriod, "refresh-period", 5, "the period at which the Loadbalancer value is checked (in seconds)")
+	flag.StringVar(&a.refresh.Mode, "refresh-mode", controller.RefreshModeFixed, "when the elb DNS names are resolved, fixed uses --refresh-period, ttl resolves shortly before the minimum TTL of the records expires")
+	flag.DurationVar(&a.refresh.MinInterval, "refresh-min-interval", 5*time.Second, "minimum interval between two lookups with --refresh-mode=ttl, changes of the managed objects within it are repaired with the addresses of the last lookup in both modes")
+	flag.DurationVar(&a.refresh.MaxInterval, "refresh-max-interval", 5*time.Minute, "maximum interval between two lookups with --refresh-mode=ttl")
+	flag.Float64Var(&a.refresh.Jitter, "refresh-jitter", 0.1, "maximum fraction by which the interval between two lookups is shortened at random with --refresh-mode=ttl")
+	flag.DurationVar(&a.aggregation.Window, "aggregation-window", 0, "time an elb IP is advertised after it was returned by a lookup for the last time, so that the union of the subsets returned by large load balancers is advertised (0 advertises the last lookup only)")
+	flag.StringVar(&a.damping.Mode, "damping-mode", controller.DampingModeNone, "how lookups have to agree on a new set of elb IPs before it is advertised, one of none, consensus (--damping-observations of the last --damping-lookups) or stable (every lookup for --damping-stable-duration)")
+	flag.IntVar(&a.damping.Observations, "damping-observations", 3, "number of lookups which must return a new set of IPs with --damping-mode=consensus")
+	flag.IntVar(&a.damping.Lookups, "damping-lookups", 5, "number of recent lookups considered with --damping-mode=consensus")
+	flag.DurationVar(&a.damping.StableDuration, "damping-stable-duration", 30*time.Second, "time every lookup must return a new set of IPs with --damping-mode=stable")
+	flag.DurationVar(&a.gracePeriod.RemovalGracePeriod, "removal-grace-period", 0, "time an elb IP is still advertised after it disappeared from DNS (0 removes it right away)")
+	flag.BoolVar(&a.gracePeriod.NotReady, "removal-grace-not-ready", false, "advertise the IPs retained for --removal-grace-period as not ready addresses")
+	flag.StringVar(&a.probe.Mode, "probe-mode", controller.ProbeModeNone, "how resolved elb IPs are probed before they are advertised as ready, one of none, tcp or https, failing IPs are advertised as not ready")
+	flag.IntVar(&a.probe.Port, "probe-port", 443, "port the elb IPs are probed on")
+	flag.StringVar(&a.probe.Path, "probe-path", "/readyz", "path requested with --probe-mode=https")
+	flag.StringVar(&a.probe.CAFile, "probe-ca-file", "", "CA bundle the serving certificates are verified with when --probe-mode=https (defaults to the system roots)")
+	flag.DurationVar(&a.probe.Timeout, "probe-timeout", 2*time.Second, "timeout of a single probe")
+	flag.StringVar(&a.identity.CAFile, "verify-ca-file", "", "CA bundle the certificate served by every resolved elb IP must be signed by, IPs failing the verification are not advertised (empty disables the verification)")
+	flag.StringVar(&a.identity.ServerName, "verify-server-name", "", "name the certificate served by the elb IPs must carry as SAN (defaults to the elb hostname the IP was resolved from)")
+	flag.IntVar(&a.identity.Port, "verify-port", 443, "port the TLS identity of the elb IPs is verified on")
+	flag.DurationVar(&a.identity.Timeout, "verify-timeout", 2*time.Second, "timeout of a single TLS identity verification")
+	flag.StringVar(&a.ipRanges.File, "ip-ranges-file", "", "AWS ip-ranges.json document the resolved elb IPs are validated against")
+	flag.StringVar(&a.ipRanges.ConfigMap, "ip-ranges-configmap", "", "<namespace>/<name> of a ConfigMap carrying the AWS ip-ranges.json document at key "+controller.IPRangesConfigMapKey+", replaces --ip-ranges-file")
+	flag.Var((*stringSliceFlag)(&a.ipRanges.Regions), "ip-ranges-region", "region of the AWS IP ranges the elb IPs must be part of, can be given multiple times (defaults to all regions)")
+	flag.Var((*stringSliceFlag)(&a.ipRanges.Services), "ip-ranges-service", "service of the AWS IP ranges the elb IPs must be part of, e.g. EC2 or AMAZON, can be given multiple times (defaults to all services)")
+	flag.StringVar(&a.ipRanges.Mode, "ip-ranges-mode", controller.IPRangesModeEnforce, "what happens to elb IPs outside of the AWS IP ranges, enforce drops them, audit only logs them")
+	flag.Var((*stringSliceFlag)(&a.filter.AllowCIDRs), "allow-cidr", "CIDR the resolved elb IPs must be part of, can be given multiple times (defaults to all addresses)")
+	flag.Var((*stringSliceFlag)(&a.filter.DenyCIDRs), "deny-cidr", "CIDR the resolved elb IPs must not be part of, can be given multiple times")
+	flag.DurationVar(&a.fallback.MaxStaleness, "last-known-good-max-staleness", 0, "time the last successfully resolved elb IPs are served and restored while DNS fails or returns nothing (0 disables the fallback)")
+	flag.StringVar(&a.fallback.ConfigMap, "last-known-good-configmap", "", "<namespace>/<name> of a ConfigMap the last known good elb IPs are persisted in besides the managed objects, so that they are restored even if the managed objects were deleted")
+	flag.StringVar(&a.fight.Policy, "fight-policy", controller.FightPolicyKeepFighting, "what happens while the endpoint is fought over by another writer like the kube-apiserver endpoint reconciler, one of keep-fighting, back-off or fail")
+	flag.IntVar(&a.fight.Threshold, "fight-threshold", 3, "number of overwrites of the endpoint within --fight-window after which it is considered fought over (0 disables the detection)")
+	flag.DurationVar(&a.fight.Window, "fight-window", 10*time.Minute, "time the overwrites of the endpoint are counted in")
+	flag.DurationVar(&a.fight.MaxBackoff, "fight-max-backoff", 30*time.Minute, "maximum time writes to a fought over endpoint are suspended with --fight-policy=back-off")
+	flag.StringVar(&a.write.FieldManager, "field-manager", controller.DefaultFieldManager, "field manager the writes of the managed objects are recorded with")
+	flag.BoolVar(&a.write.ServerSideApply, "server-side-apply", false, "write the managed Endpoints objects by server-side apply instead of a strategic merge patch of the cached copy")
+	flag.BoolVar(&a.write.ForceConflicts, "force-conflicts", false, "take over the fields of the Endpoints objects owned by other field managers with --server-side-apply instead of failing")
 	flag.IntVar(&a.controllerResyncPeriod, "resync-period", 30, "the period at which the controller sync with the cache will happen (in seconds)")
+	flag.Var(&a.nameservers, "nameserver", "nameserver (host[:port]) to send DNS queries for the elb to, can be given multiple times (defaults to the system resolver)")
+	flag.StringVar(&a.dnsTransport, "dns-transport", controller.DNSTransportUDP, "transport used for queries to --nameserver, one of udp or tcp")
+	flag.DurationVar(&a.dnsTimeout, "dns-timeout", 5*time.Second, "timeout of a single DNS lookup with the system resolver, or of every query sent to a --nameserver")
+	flag.StringVar(&a.ipFamily, "ip-family", string(controller.IPFamilyIPv4), "IP family of the advertised elb addresses, one of ipv4, ipv6 or dual")
+	flag.Var(&a.ports, "port", "port advertised for the elb IPs in the form <name>:<port>[/<protocol>[/<appProtocol>]], can be given multiple times (defaults to https:443/TCP)")
+	flag.Var(&a.staticAddresses, "static-address", "IP which is always advertised in addition to the elb IPs, can be given multiple times")
+	flag.Var(&a.excludeAddresses, "exclude-address", "IP which is never advertised even if the elb resolves to it, can be given multiple times")
+	flag.BoolVar(&a.leaderElection.enabled, "leader-elect", false, "elect a leader among the replicas using a coordination.k8s.io Lease, only the leader reconciles the targets")
+	flag.StringVar(&a.leaderElection.namespace, "leader-elect-namespace", "", "namespace of the leader election Lease (defaults to $POD_NAMESPACE or default)")
+	flag.StringVar(&a.leaderElection.name, "leader-elect-name", "aws-lb-readvertiser", "name of the leader election Lease")
+	flag.DurationVar(&a.leaderElection.leaseDuration, "leader-elect-lease-duration", 15*time.Second, "duration followers wait before trying to acquire a Lease which was not renewed")
+	flag.DurationVar(&a.leaderElection.renewDeadline, "leader-elect-renew-deadline", 10*time.Second, "duration the leader retries renewing the Lease before giving up leadership")
+	flag.DurationVar(&a.leaderElection.retryPeriod, "leader-elect-retry-period", 2*time.Second, "duration between attempts to acquire or renew the Lease")
+	flag.StringVar(&a.endpointAPI, "endpoint-api", controller.EndpointAPIEndpoints, "API used to advertise the elb IPs, one of endpoints, endpointslice or both")
+	flag.StringVar(&a.metricsBindAddress, "metrics-bind-address", ":8080", "address the Prometheus metrics are served on at /metrics, set to 0 to disable")
+	flag.StringVar(&a.healthProbeBindAddress, "health-probe-bind-address", ":8081", "address the liveness and readiness probes are served on at /healthz and /readyz, set to 0 to disable")
+	flag.DurationVar(&a.health.StallTimeout, "health-stall-timeout", 10*time.Minute, "time on top of its refresh period a target may go without a finished (liveness) or successful (readiness) reconcile, also bounds the informer sync (0 disables the check)")
+	flag.IntVar(&a.health.MaxConsecutiveFailures, "health-max-consecutive-failures", 10, "number of consecutive failed reconciles of a target after which the liveness probe fails (0 disables the check)")
 
 	flag.Parse()
 }
 
 func (a *AWSReadvertiserOptions) validateFlags() error {
-	if len(a.elb) == 0 {
-		return fmt.Errorf("The DNS value for the ELB needs to be set properly")
+	if a.dnsTransport != controller.DNSTransportUDP && a.dnsTransport != controller.DNSTransportTCP {
+		return fmt.Errorf("The DNS transport must be one of %q or %q", controller.DNSTransportUDP, controller.DNSTransportTCP)
 	}
 
-	// Check to see if the domain is a valid FQDN
-	if !strings.HasSuffix(a.elb, ".") {
-		a.elb = fmt.Sprintf("%s.", a.elb)
+	switch a.endpointAPI {
+	case controller.EndpointAPIEndpoints, controller.EndpointAPIEndpointSlice, controller.EndpointAPIBoth:
+	default:
+		return fmt.Errorf("The endpoint API must be one of %q, %q or %q", controller.EndpointAPIEndpoints, controller.EndpointAPIEndpointSlice, controller.EndpointAPIBoth)
 	}
 
-	if a.refreshPeriod == 0 {
-		log.Infof("The refresh period was not set, using default %d", a.refreshPeriod)
-		return nil
+	switch controller.IPFamily(a.ipFamily) {
+	case controller.IPFamilyIPv4, controller.IPFamilyIPv6, controller.IPFamilyDual:
+	default:
+		return fmt.Errorf("The IP family must be one of %q, %q or %q", controller.IPFamilyIPv4, controller.IPFamilyIPv6, controller.IPFamilyDual)
+	}
+
+	if a.leaderElection.enabled {
+		if len(a.leaderElection.namespace) == 0 {
+			a.leaderElection.namespace = metav1.NamespaceDefault
+			if namespace, ok := os.LookupEnv("POD_NAMESPACE"); ok && len(namespace) != 0 {
+				a.leaderElection.namespace = namespace
+			}
+		}
+		if a.leaderElection.leaseDuration <= a.leaderElection.renewDeadline {
+			return fmt.Errorf("The leader election lease duration must be greater than the renew deadline")
+		}
+		if a.leaderElection.renewDeadline <= a.leaderElection.retryPeriod {
+			return fmt.Errorf("The leader election renew deadline must be greater than the retry period")
+		}
+	}
+
+	if err := a.refresh.Validate(); err != nil {
+		return fmt.Errorf("The refresh options are invalid: %v", err)
+	}
+
+	if err := a.aggregation.Validate(); err != nil {
+		return fmt.Errorf("The aggregation options are invalid: %v", err)
+	}
+	if err := a.damping.Validate(); err != nil {
+		return fmt.Errorf("The damping options are invalid: %v", err)
+	}
+
+	if err := a.gracePeriod.Validate(); err != nil {
+		return fmt.Errorf("The grace period options are invalid: %v", err)
+	}
+
+	if err := a.probe.Validate(); err != nil {
+		return fmt.Errorf("The probe options are invalid: %v", err)
+	}
+
+	if err := a.identity.Validate(); err != nil {
+		return fmt.Errorf("The identity verification options are invalid: %v", err)
+	}
+
+	if err := a.ipRanges.Validate(); err != nil {
+		return fmt.Errorf("The IP ranges options are invalid: %v", err)
+	}
+
+	if err := a.fallback.Validate(); err != nil {
+		return fmt.Errorf("The fallback options are invalid: %v", err)
+	}
+
+	if err := a.fight.Validate(); err != nil {
+		return fmt.Errorf("The fight options are invalid: %v", err)
+	}
+	if err := a.write.Validate(); err != nil {
+		return fmt.Errorf("The write options are invalid: %v", err)
+	}
+
+	if a.health.StallTimeout < 0 {
+		return fmt.Errorf("The health stall timeout must not be negative")
+	}
+	if a.health.MaxConsecutiveFailures < 0 {
+		return fmt.Errorf("The maximum number of consecutive failures must not be negative")
 	}
 
 	if a.controllerResyncPeriod == 0 {
 		log.Infof("The controller resync period was not set, using default %d", a.controllerResyncPeriod)
 	}
 
-	return nil
+	if len(a.configFile) != 0 {
+		if len(a.elb) != 0 || len(a.ports) != 0 || len(a.staticAddresses) != 0 || len(a.excludeAddresses) != 0 {
+			return fmt.Errorf("--config cannot be combined with --elb-dns-name, --port, --static-address or --exclude-address")
+		}
+
+		config, err := controller.LoadConfig(a.configFile)
+		if err != nil {
+			return err
+		}
+		if a.targets, err = config.ToTargets(time.Duration(a.refreshPeriod) * time.Second); err != nil {
+			return fmt.Errorf("The config file %q is invalid: %v", a.configFile, err)
+		}
+		return nil
+	}
+
+	if len(a.elb) == 0 {
+		return fmt.Errorf("The DNS value for the ELB needs to be set properly")
+	}
+
+	endpointPorts := controller.DefaultEndpointPorts()
+	if len(a.ports) != 0 {
+		endpointPorts = nil
+		for _, value := range a.ports {
+			port, err := controller.ParseEndpointPort(value)
+			if err != nil {
+				return err
+			}
+			endpointPorts = append(endpointPorts, port)
+		}
+	}
+
+	a.targets = []controller.Target{
+		{
+			Hostnames:         []string{a.elb},
+			EndpointNamespace: a.endpointNamespace,
+			EndpointName:      a.endpointName,
+			Ports:             endpointPorts,
+			RefreshPeriod:     time.Duration(a.refreshPeriod) * time.Second,
+			StaticAddresses:   a.staticAddresses,
+			ExcludeAddresses:  a.excludeAddresses,
+		},
+	}
+	return controller.ValidateTargets(a.targets)
 }
 
 func (a *AWSReadvertiserOptions) initializeClient() (*kubernetes.Clientset, error) {
@@ -83,15 +308,207 @@ func (a *AWSReadvertiserOptions) initializeClient() (*kubernetes.Clientset, erro
 	return kubernetes.NewForConfig(config)
 }
 
-func (a *AWSReadvertiserOptions) run(ctx context.Context, client kubernetes.Interface) {
+func (a *AWSReadvertiserOptions) initializeResolver() (controller.Resolver, error) {
+	if len(a.nameservers) == 0 {
+		log.Info("Using the system resolver for DNS lookups")
+		return controller.NewSystemResolver(a.dnsTimeout), nil
+	}
+
+	log.Infof("Using nameservers %q via %s for DNS lookups", a.nameservers, a.dnsTransport)
+	return controller.NewDNSResolver(a.nameservers, a.dnsTransport, a.dnsTimeout)
+}
+
+// loadIPRanges reads the AWS IP ranges from the configured file or ConfigMap, nil if the validation is disabled
+func (a *AWSReadvertiserOptions) loadIPRanges(ctx context.Context, client kubernetes.Interface) (*controller.IPRanges, error) {
+	var data []byte
+	switch {
+	case len(a.ipRanges.File) != 0:
+		content, err := os.ReadFile(a.ipRanges.File)
+		if err != nil {
+			return nil, err
+		}
+		data = content
+	case len(a.ipRanges.ConfigMap) != 0:
+		namespace, name, _ := strings.Cut(a.ipRanges.ConfigMap, "/")
+		configMap, err := client.CoreV1().ConfigMaps(namespace).Get(ctx, name, metav1.GetOptions{})
+		if err != nil {
+			return nil, err
+		}
+		content, ok := configMap.Data[controller.IPRangesConfigMapKey]
+		if !ok {
+			return nil, fmt.Errorf("ConfigMap %s has no key %s", a.ipRanges.ConfigMap, controller.IPRangesConfigMapKey)
+		}
+		data = []byte(content)
+	default:
+		return nil, nil
+	}
+
+	ipRanges, err := controller.NewIPRanges(data, a.ipRanges)
+	if err != nil {
+		return nil, err
+	}
+	log.Infof("Validating the resolved IPs against the AWS IP ranges in %s mode", a.ipRanges.Mode)
+	return ipRanges, nil
+}
+
+// serveMetrics serves the Prometheus metrics until the context is cancelled
+func (a *AWSReadvertiserOptions) serveMetrics(ctx context.Context) {
+	mux := http.NewServeMux()
+	mux.Handle("/metrics", promhttp.Handler())
+	serveHTTP(ctx, "metrics", a.metricsBindAddress, mux)
+}
+
+// serveHealthProbes serves the liveness and readiness checks of the controller until the context is cancelled
+func (a *AWSReadvertiserOptions) serveHealthProbes(ctx context.Context, awsLBReadvertiserController *controller.AWSLBReadvertiserController) {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/healthz", probeHandler(awsLBReadvertiserController.CheckLiveness))
+	mux.HandleFunc("/readyz", probeHandler(awsLBReadvertiserController.CheckReadiness))
+	serveHTTP(ctx, "health probes", a.healthProbeBindAddress, mux)
+}
+
+// probeHandler answers with 200 if the check passes and with 500 and the reason otherwise
+func probeHandler(check func() error) http.HandlerFunc {
+	return func(w http.ResponseWriter, _ *http.Request) {
+		if err := check(); err != nil {
+			log.Warnf("Probe failed: %v", err)
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		fmt.Fprint(w, "ok")
+	}
+}
+
+// serveHTTP serves the handler on the address until the context is cancelled, the address 0 disables the server
+func serveHTTP(ctx context.Context, name, address string, handler http.Handler) {
+	if address == "0" || len(address) == 0 {
+		log.Infof("Serving %s is disabled", name)
+		return
+	}
+
+	server := &http.Server{
+		Addr:              address,
+		Handler:           handler,
+		ReadHeaderTimeout: 10 * time.Second,
+	}
+
+	go func() {
+		<-ctx.Done()
+		shutdownCtx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		if err := server.Shutdown(shutdownCtx); err != nil {
+			log.Errorf("failed to shut down %s server, error: %+v", name, err)
+		}
+	}()
+
+	log.Infof("Serving %s on %s", name, address)
+	if err := server.ListenAndServe(); err != nil && !errors.Is(err, http.ErrServerClosed) {
+		log.Fatalf("failed to serve %s, error: %+v", name, err)
+	}
+}
+
+func (a *AWSReadvertiserOptions) run(ctx context.Context, client kubernetes.Interface, resolver controller.Resolver, prober controller.Prober, verifier controller.IdentityVerifier, ipRanges *controller.IPRanges, filter *controller.AddressFilter) {
+	var informerOptions []informers.SharedInformerOption
+	// only watch a single namespace if all endpoints reside in it, so that namespaced RBAC permissions are sufficient
+	namespaces := sets.NewString()
+	for _, target := range a.targets {
+		namespaces.Insert(target.EndpointNamespace)
+	}
+	if namespaces.Len() == 1 {
+		informerOptions = append(informerOptions, informers.WithNamespace(namespaces.List()[0]))
+	}
+
+	// the broadcaster aggregates similar events and rate limits them per object, so that a target failing at every
+	// retry does not flood the API server
+	eventBroadcaster := record.NewBroadcaster()
+	eventBroadcaster.StartRecordingToSink(&typedcorev1.EventSinkImpl{Interface: client.CoreV1().Events("")})
+	defer eventBroadcaster.Shutdown()
+	recorder := eventBroadcaster.NewRecorder(scheme.Scheme, corev1.EventSource{Component: "aws-lb-readvertiser"})
+
 	var (
-		sharedInformers             = informers.NewSharedInformerFactory(client, time.Duration(a.controllerResyncPeriod)*time.Second)
-		awsLBReadvertiserController = controller.NewAWSLBEndpointsController(client, sharedInformers.Core().V1().Endpoints(), a.elb, "kubernetes")
-		refreshTicker               = time.NewTicker(time.Duration(a.refreshPeriod) * time.Second)
+		sharedInformers             = informers.NewSharedInformerFactoryWithOptions(client, time.Duration(a.controllerResyncPeriod)*time.Second, informerOptions...)
+		awsLBReadvertiserController = controller.NewAWSLBEndpointsController(client, sharedInformers.Core().V1().Endpoints(), sharedInformers.Discovery().V1().EndpointSlices(), resolver, recorder, a.targets, controller.Options{EndpointAPI: a.endpointAPI, IPFamily: controller.IPFamily(a.ipFamily), Health: a.health, Refresh: a.refresh, Aggregation: a.aggregation, Damping: a.damping, GracePeriod: a.gracePeriod, Prober: prober, IdentityVerifier: verifier, IPRanges: ipRanges, AddressFilter: filter, Fallback: a.fallback, Fight: a.fight, Write: a.write})
 	)
 
+	// informers are started before the election, so that followers keep warm caches and can take over immediately
 	go sharedInformers.Start(ctx.Done())
-	awsLBReadvertiserController.Run(ctx, refreshTicker)
+	go a.serveHealthProbes(ctx, awsLBReadvertiserController)
+
+	if !a.leaderElection.enabled {
+		awsLBReadvertiserController.Run(ctx)
+		return
+	}
+	a.runWithLeaderElection(ctx, client, awsLBReadvertiserController.Run)
+}
+
+// runWithLeaderElection runs the reconcile function while this replica holds the leader election Lease. The context
+// passed to it is cancelled when the leadership is lost. Losing the leadership terminates the process, so that it
+// rejoins the election with a fresh state.
+func (a *AWSReadvertiserOptions) runWithLeaderElection(ctx context.Context, client kubernetes.Interface, reconcile func(context.Context)) {
+	hostname, err := os.Hostname()
+	if err != nil {
+		log.Fatalf("failed to get hostname for leader election identity, error: %+v", err)
+	}
+	identity := fmt.Sprintf("%s_%s", hostname, uuid.NewUUID())
+
+	lock := &resourcelock.LeaseLock{
+		LeaseMeta: metav1.ObjectMeta{
+			Namespace: a.leaderElection.namespace,
+			Name:      a.leaderElection.name,
+		},
+		Client: client.CoordinationV1(),
+		LockConfig: resourcelock.ResourceLockConfig{
+			Identity: identity,
+		},
+	}
+
+	// reconcileDone is only set once this replica started leading
+	var (
+		mu            sync.Mutex
+		reconcileDone chan struct{}
+	)
+	log.Infof("Starting leader election for lease %s/%s as %q", a.leaderElection.namespace, a.leaderElection.name, identity)
+	leaderelection.RunOrDie(ctx, leaderelection.LeaderElectionConfig{
+		Lock:            lock,
+		LeaseDuration:   a.leaderElection.leaseDuration,
+		RenewDeadline:   a.leaderElection.renewDeadline,
+		RetryPeriod:     a.leaderElection.retryPeriod,
+		ReleaseOnCancel: true,
+		Callbacks: leaderelection.LeaderCallbacks{
+			OnStartedLeading: func(leaderCtx context.Context) {
+				done := make(chan struct{})
+				mu.Lock()
+				reconcileDone = done
+				mu.Unlock()
+				defer close(done)
+
+				log.Info("Acquired leadership, starting to reconcile")
+				reconcile(leaderCtx)
+			},
+			OnStoppedLeading: func() {
+				log.Info("Leadership released, waiting for the reconcile loop to stop")
+			},
+			OnNewLeader: func(leader string) {
+				if leader != identity {
+					log.Infof("Current leader is %q, standing by", leader)
+				}
+			},
+		},
+	})
+
+	mu.Lock()
+	done := reconcileDone
+	mu.Unlock()
+	if done != nil {
+		select {
+		case <-done:
+		case <-time.After(a.leaderElection.renewDeadline):
+			log.Warn("Timed out waiting for the reconcile loop to stop")
+		}
+	}
+
+	if ctx.Err() == nil {
+		log.Fatal("Lost leadership, exiting")
+	}
 }
 
 func main() {
@@ -116,6 +533,9 @@ func main() {
 	}()
 
 	awsReadvertiser.addFlags()
+	log.Infof("Starting aws-lb-readvertiser %s", version)
+	controller.SetBuildInfo(version)
+
 	if err := awsReadvertiser.validateFlags(); err != nil {
 		log.Fatalf("Invalid flags, reason: %+v", err)
 	}
@@ -125,5 +545,31 @@ func main() {
 		log.Fatalf("failed to initialize client, error: %+v", err)
 	}
 
-	awsReadvertiser.run(ctx, client)
+	resolver, err := awsReadvertiser.initializeResolver()
+	if err != nil {
+		log.Fatalf("failed to initialize resolver, error: %+v", err)
+	}
+
+	prober, err := controller.NewProber(awsReadvertiser.probe)
+	if err != nil {
+		log.Fatalf("failed to initialize prober, error: %+v", err)
+	}
+
+	verifier, err := controller.NewIdentityVerifier(awsReadvertiser.identity)
+	if err != nil {
+		log.Fatalf("failed to initialize identity verifier, error: %+v", err)
+	}
+
+	ipRanges, err := awsReadvertiser.loadIPRanges(ctx, client)
+	if err != nil {
+		log.Fatalf("failed to load AWS IP ranges, error: %+v", err)
+	}
+
+	filter, err := controller.NewAddressFilter(awsReadvertiser.filter)
+	if err != nil {
+		log.Fatalf("failed to initialize address filter, error: %+v", err)
+	}
+
+	go awsReadvertiser.serveMetrics(ctx)
+	awsReadvertiser.run(ctx, client, resolver, prober, verifier, ipRanges, filter)
 }